@@ -1,10 +1,13 @@
 package auth
 
 import (
+	"context"
+	"log"
 	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/kuromii5/sync-auth/internal/auth/server"
 	"github.com/kuromii5/sync-auth/internal/auth/server/logger"
@@ -12,24 +15,34 @@ import (
 	"github.com/kuromii5/sync-auth/internal/repo/postgres"
 	"github.com/kuromii5/sync-auth/internal/repo/redis"
 	"github.com/kuromii5/sync-auth/internal/service"
+	"github.com/kuromii5/sync-auth/internal/service/cookies"
+	"github.com/kuromii5/sync-auth/internal/service/events"
+	"github.com/kuromii5/sync-auth/internal/service/mfa"
 	"github.com/kuromii5/sync-auth/internal/service/oauth"
+	"github.com/kuromii5/sync-auth/internal/service/outbox"
 	"github.com/kuromii5/sync-auth/internal/service/tokens"
 	"github.com/kuromii5/sync-auth/internal/service/verification"
+	"github.com/kuromii5/sync-auth/internal/service/webauthn"
 )
 
 type AuthService struct {
 	server *server.Server
+	outbox *outbox.Worker
+
+	tokenManager    *tokens.TokenManager
+	keySyncInterval time.Duration
+	stopKeySync     context.CancelFunc
 }
 
 func NewAuthService() *AuthService {
 	// Init config
 	config := config.Load()
 
-	// Init oAuth clients
-	clients := LoadClientsFromConfig(
-		config.OauthGithub,
-	)
-	oAuthClients := LoadOauthClients(clients)
+	// Init oAuth providers
+	oAuthProviders, err := LoadOAuthProviders(config)
+	if err != nil {
+		log.Fatalf("failed to load oauth providers: %v", err)
+	}
 
 	// Init logger
 	logger := logger.New(config.Env, config.LogLevel)
@@ -40,18 +53,101 @@ func NewAuthService() *AuthService {
 	// Init Redis storage
 	storage := redis.NewStorage(config.TokensConfig.RedisAddr)
 
+	// Init signing keys
+	keyRing, err := LoadKeyRing(config.TokensConfig)
+	if err != nil {
+		log.Fatalf("failed to load signing keys: %v", err)
+	}
+
+	// Init mailer
+	mailer, err := LoadMailer(config.EVConfig)
+	if err != nil {
+		log.Fatalf("failed to init mailer: %v", err)
+	}
+	emailTemplates, err := LoadEmailTemplates(config.EVConfig)
+	if err != nil {
+		log.Fatalf("failed to load email templates: %v", err)
+	}
+	magicLinks, generatedSecret, err := LoadMagicLinks(config.EVConfig)
+	if err != nil {
+		log.Fatalf("failed to init magic links: %v", err)
+	}
+	if generatedSecret {
+		logger.Warn("MAGIC_LINK_SECRET is not set, magic links won't survive restart")
+	}
+
 	// Init managers
-	tokenManager := tokens.NewTokenManager(logger, config.TokensConfig.Secret, config.TokensConfig.AccessTTL, config.TokensConfig.RefreshTTL, storage, storage, storage)
-	verificationManager := verification.NewVerificationManager(logger, config.EVConfig.CodeTTL, config.EVConfig.AppEmail, config.EVConfig.AppPassword, config.EVConfig.AppSmtpHost)
-	oAuthManager := oauth.NewOAuthManager(logger, oAuthClients)
+	tokenManager := tokens.NewTokenManager(logger, keyRing, config.TokensConfig.Issuer, config.TokensConfig.Audience, config.TokensConfig.AccessTTL, config.TokensConfig.RefreshTTL, storage, storage, storage, storage, storage, storage, db)
+	// keys rotated by any instance are stored in the database
+	if err := tokenManager.SyncSigningKeys(context.Background()); err != nil {
+		log.Fatalf("failed to sync signing keys: %v", err)
+	}
+	verificationManager := verification.NewVerificationManager(logger, db, emailTemplates, config.EVConfig.CodeTTL, verification.CodeLimits{
+		ResendCooldown: config.EVConfig.CodeResendCooldown,
+		DailyLimit:     config.EVConfig.CodeDailyLimit,
+		MaxAttempts:    config.EVConfig.CodeMaxAttempts,
+	}, config.EVConfig.PasswordResetTTL, config.EVConfig.PasswordResetURL, magicLinks)
+	mfaManager := mfa.NewMFAManager(config.MFA.Issuer, config.MFA.ChallengeTTL, config.MFA.MaxAttempts, config.MFA.RecoveryCodes)
+	passkeyManager := webauthn.NewPasskeyManager(webauthn.Config{
+		RPID:        config.WebAuthn.RPID,
+		RPName:      config.WebAuthn.RPName,
+		Origins:     config.WebAuthn.Origins,
+		Timeout:     config.WebAuthn.Timeout,
+		Attestation: config.WebAuthn.Attestation,
+	})
+	cookiePolicy, err := cookies.NewPolicy(cookies.Config{
+		AccessName:  config.Cookies.AccessName,
+		RefreshName: config.Cookies.RefreshName,
+		Domain:      config.Cookies.Domain,
+		Path:        config.Cookies.Path,
+		Secure:      config.Cookies.Secure,
+		SameSite:    config.Cookies.SameSite,
+		AccessTTL:   config.TokensConfig.AccessTTL,
+		RefreshTTL:  config.TokensConfig.RefreshTTL,
+	})
+	if err != nil {
+		log.Fatalf("failed to load cookie policy: %v", err)
+	}
+	csrfProtection, generatedSecret, err := LoadCSRF(config.CSRF, cookiePolicy)
+	if err != nil {
+		log.Fatalf("failed to init csrf protection: %v", err)
+	}
+	if generatedSecret {
+		logger.Warn("CSRF_SECRET is not set, csrf tokens won't survive restart")
+	}
+	corsPolicy, err := LoadCORS(config.CORS, config.CSRF)
+	if err != nil {
+		log.Fatalf("failed to load cors policy: %v", err)
+	}
+	oAuthManager := oauth.NewOAuthManager(logger, oAuthProviders, storage, config.OAuthStateTTL)
+	securityEvents := events.NewLogEmitter(logger)
+
+	// Init email delivery
+	outboxWorker := outbox.NewWorker(logger, db, mailer, outbox.Config{
+		PollInterval:  config.Outbox.PollInterval,
+		BatchSize:     config.Outbox.BatchSize,
+		MaxAttempts:   config.Outbox.MaxAttempts,
+		BaseBackoff:   config.Outbox.BaseBackoff,
+		MaxBackoff:    config.Outbox.MaxBackoff,
+		SendTimeout:   config.Outbox.SendTimeout,
+		Retention:     config.Outbox.Retention,
+		PurgeInterval: config.Outbox.PurgeInterval,
+	})
 
 	// Init service
-	authService := service.NewAuthService(logger, verificationManager, db, db, tokenManager, tokenManager, storage, oAuthManager)
+	authService := service.NewAuthService(logger, verificationManager, mfaManager, passkeyManager, cookiePolicy, db, db, tokenManager, tokenManager, tokenManager, storage, storage, storage, storage, storage, db, storage, db, storage, oAuthManager, db, securityEvents)
 
 	// Init server
 	server := server.NewServer(
 		logger,
 		config.Port,
+		config.HTTPPort,
+		config.HTTPMultiplex,
+		config.AdminToken,
+		config.TokensConfig.RotationKeyFile,
+		config.IntrospectionClients,
+		csrfProtection,
+		corsPolicy,
 		authService,
 	)
 
@@ -60,13 +156,30 @@ func NewAuthService() *AuthService {
 			slog.Any("Postgres", config.PGConfig),
 			slog.String("Environment", config.Env),
 			slog.Int("Port", config.Port),
+			slog.Int("HTTP port", config.HTTPPort),
+			slog.Bool("HTTP multiplexed", config.HTTPMultiplex),
+			slog.Bool("CSRF protection", config.CSRF.Enabled),
+			slog.Any("CORS origins", config.CORS.AllowedOrigins),
+			slog.String("Signing algorithm", keyRing.Active().Method.Alg()),
+			slog.String("Mailer", config.EVConfig.Mailer),
 		),
 	)
 
-	return &AuthService{server: server}
+	return &AuthService{
+		server:          server,
+		outbox:          outboxWorker,
+		tokenManager:    tokenManager,
+		keySyncInterval: config.TokensConfig.KeySyncInterval,
+	}
 }
 
 func (a *AuthService) Run() {
+	a.outbox.Start()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	a.stopKeySync = cancel
+	go a.tokenManager.WatchSigningKeys(ctx, a.keySyncInterval)
+
 	go func() {
 		a.server.Run()
 	}()
@@ -80,4 +193,11 @@ func (a *AuthService) Run() {
 
 func (a *AuthService) Shutdown() {
 	a.server.Shutdown()
+
+	// requests are finished, so nothing is added to the outbox anymore
+	a.outbox.Stop()
+
+	if a.stopKeySync != nil {
+		a.stopKeySync()
+	}
 }
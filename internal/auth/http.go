@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"crypto/rand"
+	"slices"
+	"strings"
+
+	"github.com/kuromii5/sync-auth/internal/config"
+	"github.com/kuromii5/sync-auth/internal/service/cookies"
+	"github.com/kuromii5/sync-auth/internal/transport/cors"
+	"github.com/kuromii5/sync-auth/internal/transport/csrf"
+)
+
+// LoadCSRF creates CSRF protection of HTTP API, nil if it is disabled. Without
+// configured secret tokens are signed with random one and stop working after restart
+func LoadCSRF(cfg config.CSRFConfig, cookiePolicy *cookies.Policy) (*csrf.Protection, bool, error) {
+	if !cfg.Enabled {
+		return nil, false, nil
+	}
+
+	secret := []byte(cfg.Secret)
+
+	generated := len(secret) == 0
+	if generated {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, false, err
+		}
+	}
+
+	return csrf.New(csrf.Config{
+		Secret:         secret,
+		CookieName:     cfg.CookieName,
+		HeaderName:     cfg.HeaderName,
+		TrustedOrigins: cfg.TrustedOrigins,
+	}, cookiePolicy), generated, nil
+}
+
+// LoadCORS creates CORS policy of HTTP API. Browsers have to send CSRF
+// token in the header, so it is always allowed when CSRF protection is on
+func LoadCORS(cfg config.CORSConfig, csrfCfg config.CSRFConfig) (*cors.Policy, error) {
+	allowedHeaders := slices.Clone(cfg.AllowedHeaders)
+	if csrfCfg.Enabled && !slices.ContainsFunc(allowedHeaders, func(h string) bool { return strings.EqualFold(h, csrfCfg.HeaderName) }) {
+		allowedHeaders = append(allowedHeaders, csrfCfg.HeaderName)
+	}
+
+	routes := make([]cors.Route, 0, len(cfg.Routes))
+	for _, r := range cfg.Routes {
+		routes = append(routes, cors.Route{
+			Path:             r.Path,
+			AllowedOrigins:   r.AllowedOrigins,
+			AllowedMethods:   r.AllowedMethods,
+			AllowedHeaders:   r.AllowedHeaders,
+			ExposedHeaders:   r.ExposedHeaders,
+			AllowCredentials: r.AllowCredentials,
+			MaxAge:           r.MaxAge,
+		})
+	}
+
+	return cors.New(cors.Rules{
+		AllowedOrigins:   cfg.AllowedOrigins,
+		AllowedMethods:   cfg.AllowedMethods,
+		AllowedHeaders:   allowedHeaders,
+		ExposedHeaders:   cfg.ExposedHeaders,
+		AllowCredentials: cfg.AllowCredentials,
+		MaxAge:           cfg.MaxAge,
+	}, routes)
+}
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/kuromii5/sync-auth/internal/config"
+	"github.com/kuromii5/sync-auth/internal/mail"
+	"github.com/kuromii5/sync-auth/internal/service/verification"
+)
+
+// LoadMailer creates mailer selected by config
+func LoadMailer(cfg config.EmailVerificationConfig) (mail.Mailer, error) {
+	switch cfg.Mailer {
+	case "smtp":
+		if cfg.AppSmtpHost == "" {
+			return nil, errors.New("APP_SMTP_HOST is required for smtp mailer")
+		}
+
+		username := cfg.AppSmtpUsername
+		if username == "" {
+			username = cfg.AppEmail
+		}
+
+		return mail.NewSMTPMailer(mail.SMTPConfig{
+			Host:     cfg.AppSmtpHost,
+			Port:     cfg.AppSmtpPort,
+			Username: username,
+			Password: cfg.AppPassword,
+			From:     cfg.AppEmail,
+			Security: cfg.AppSmtpSecurity,
+			Auth:     cfg.AppSmtpAuth,
+			Timeout:  cfg.AppSmtpTimeout,
+		})
+	case "file":
+		return mail.NewFileMailer(cfg.MailOutboxDir, cfg.AppEmail)
+	default:
+		return nil, fmt.Errorf("unknown mailer %q", cfg.Mailer)
+	}
+}
+
+// LoadEmailTemplates parses templates from configured directory or built in ones
+func LoadEmailTemplates(cfg config.EmailVerificationConfig) (*verification.Templates, error) {
+	var fsys fs.FS = verification.BuiltinTemplates()
+	if cfg.TemplatesDir != "" {
+		fsys = os.DirFS(cfg.TemplatesDir)
+	}
+
+	return verification.LoadTemplates(fsys, cfg.DefaultLocale)
+}
+
+// LoadMagicLinks creates signer of passwordless login links. Without configured
+// secret links are signed with random one and stop working after restart
+func LoadMagicLinks(cfg config.EmailVerificationConfig) (*verification.MagicLinks, bool, error) {
+	secret := []byte(cfg.MagicLinkSecret)
+
+	generated := len(secret) == 0
+	if generated {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, false, err
+		}
+	}
+
+	return verification.NewMagicLinks(secret, cfg.MagicLinkURL, cfg.MagicLinkTTL), generated, nil
+}
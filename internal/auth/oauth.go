@@ -1,33 +1,44 @@
 package auth
 
 import (
+	"context"
+	"fmt"
+	"time"
+
 	"github.com/kuromii5/sync-auth/internal/config"
+	"github.com/kuromii5/sync-auth/internal/service/oauth"
 	"golang.org/x/oauth2"
 )
 
-func LoadClientsFromConfig(githubConfig config.GithubAuth) []config.ClientInfoManager {
-	var clients []config.ClientInfoManager
+const discoveryTimeout = 10 * time.Second
 
-	// add more implemented clients here
-	clients = append(clients, &githubConfig)
+// LoadOAuthProviders creates GitHub provider and every configured OIDC provider
+func LoadOAuthProviders(cfg config.Config) (map[string]oauth.Provider, error) {
+	providers := make(map[string]oauth.Provider)
 
-	return clients
-}
+	github := &cfg.OauthGithub
+	providers[github.Client()] = oauth.NewGithubProvider(&oauth2.Config{
+		ClientID:     github.GetClientID(),
+		ClientSecret: github.GetClientSecret(),
+		Endpoint:     github.Endpoint(),
+		RedirectURL:  cfg.OAuthRedirectURL,
+		Scopes:       []string{"user:email"},
+	})
 
-func LoadOauthClients(clients []config.ClientInfoManager) map[string]*oauth2.Config {
-	oauthClients := make(map[string]*oauth2.Config)
+	for _, p := range cfg.OIDCProviders {
+		if _, ok := providers[p.Name]; ok || p.Name == "" {
+			return nil, fmt.Errorf("invalid or duplicate oauth provider name %q", p.Name)
+		}
 
-	for _, client := range clients {
-		clientConfig := &oauth2.Config{
-			ClientID:     client.GetClientID(),
-			ClientSecret: client.GetClientSecret(),
-			Endpoint:     client.Endpoint(),
-			RedirectURL:  "http://localhost:8080/oauth/callback",
-			Scopes:       []string{"user:email"},
+		ctx, cancel := context.WithTimeout(context.Background(), discoveryTimeout)
+		provider, err := oauth.NewOIDCProvider(ctx, p.IssuerURL, p.ClientID, p.ClientSecret, cfg.OAuthRedirectURL, p.Scopes)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("provider %s: %w", p.Name, err)
 		}
 
-		oauthClients[client.Client()] = clientConfig
+		providers[p.Name] = provider
 	}
 
-	return oauthClients
+	return providers, nil
 }
@@ -1,29 +1,66 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"log/slog"
 	"net"
+	"net/http"
+	"time"
 
 	"github.com/kuromii5/sync-auth/internal/service"
 	"github.com/kuromii5/sync-auth/internal/transport"
+	"github.com/kuromii5/sync-auth/internal/transport/cors"
+	"github.com/kuromii5/sync-auth/internal/transport/csrf"
+	le "github.com/kuromii5/sync-auth/pkg/logger/l_err"
+	"github.com/soheilhy/cmux"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
 )
 
+const shutdownTimeout = 10 * time.Second
+
 type Server struct {
-	logger *slog.Logger
-	port   int
-	api    *grpc.Server
+	logger   *slog.Logger
+	port     int
+	httpPort int
+	// HTTP API shares the gRPC port
+	multiplex bool
+	api       *grpc.Server
+	httpApi   *http.Server
+	// connection of the gateway to the gRPC server
+	gatewayConn *grpc.ClientConn
 }
 
-func NewServer(logger *slog.Logger, port int, authService *service.Auth) *Server {
-	api := transport.NewGrpcServer(authService)
+func NewServer(logger *slog.Logger, port, httpPort int, multiplex bool, adminToken, rotationKeyFile string, introspectionClients map[string]string, csrf *csrf.Protection, cors *cors.Policy, authService *service.Auth) *Server {
+	api := transport.NewGrpcServer(logger, authService, adminToken, rotationKeyFile)
+
+	// connection is established lazily, when the first gateway request comes
+	gatewayConn, err := grpc.NewClient(fmt.Sprintf("localhost:%d", port), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		log.Fatalf("failed to create gateway connection: %v", err)
+	}
+	gateway, err := transport.NewGateway(gatewayConn)
+	if err != nil {
+		log.Fatalf("failed to register gateway: %v", err)
+	}
+
+	httpApi := &http.Server{
+		Addr:              fmt.Sprintf(":%d", httpPort),
+		Handler:           transport.NewHTTPHandler(authService, introspectionClients, gateway, csrf, cors),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
 
 	return &Server{
-		logger: logger,
-		port:   port,
-		api:    api,
+		logger:      logger,
+		port:        port,
+		httpPort:    httpPort,
+		multiplex:   multiplex,
+		api:         api,
+		httpApi:     httpApi,
+		gatewayConn: gatewayConn,
 	}
 }
 
@@ -33,6 +70,14 @@ func (s *Server) Run() {
 		log.Fatalf("failed to listen on port %d: %v", s.port, err)
 	}
 
+	if s.multiplex {
+		s.runMultiplexed(listener)
+
+		return
+	}
+
+	go s.runHTTP()
+
 	s.logger.Info("Starting Authentication service...", slog.Int("port", s.port), slog.String("addr", listener.Addr().String()))
 
 	if err := s.api.Serve(listener); err != nil {
@@ -40,8 +85,57 @@ func (s *Server) Run() {
 	}
 }
 
+func (s *Server) runHTTP() {
+	s.logger.Info("Starting HTTP server...", slog.Int("port", s.httpPort))
+
+	if err := s.httpApi.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("failed to serve HTTP server: %v", err)
+	}
+}
+
+// runMultiplexed serves gRPC and HTTP on one listener. gRPC clients are
+// recognized by content-type of HTTP/2 requests, everything else is HTTP
+func (s *Server) runMultiplexed(listener net.Listener) {
+	m := cmux.New(listener)
+	grpcListener := m.MatchWithWriters(cmux.HTTP2MatchHeaderFieldSendSettings("content-type", "application/grpc"))
+	httpListener := m.Match(cmux.Any())
+
+	go func() {
+		if err := s.api.Serve(grpcListener); err != nil && !closed(err) {
+			log.Fatalf("failed to serve gRPC server: %v", err)
+		}
+	}()
+	go func() {
+		if err := s.httpApi.Serve(httpListener); err != nil && !errors.Is(err, http.ErrServerClosed) && !closed(err) {
+			log.Fatalf("failed to serve HTTP server: %v", err)
+		}
+	}()
+
+	s.logger.Info("Starting Authentication service with HTTP API...", slog.Int("port", s.port), slog.String("addr", listener.Addr().String()))
+
+	// listener is closed on shutdown by the first stopped server
+	if err := m.Serve(); err != nil && !closed(err) {
+		log.Fatalf("failed to serve: %v", err)
+	}
+}
+
+func closed(err error) bool {
+	return errors.Is(err, net.ErrClosed) || errors.Is(err, cmux.ErrListenerClosed) || errors.Is(err, cmux.ErrServerClosed)
+}
+
+// Shutdown stops HTTP server first, its requests still need gRPC server
 func (s *Server) Shutdown() {
 	s.logger.Info("Stopping Authentication service...")
 
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := s.httpApi.Shutdown(ctx); err != nil {
+		s.logger.Error("failed to stop HTTP server", le.Err(err))
+	}
+	if err := s.gatewayConn.Close(); err != nil {
+		s.logger.Error("failed to close gateway connection", le.Err(err))
+	}
+
 	s.api.GracefulStop()
 }
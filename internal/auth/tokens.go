@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/kuromii5/sync-auth/internal/config"
+	"github.com/kuromii5/sync-auth/internal/service/tokens"
+)
+
+func LoadKeyRing(tokensConfig config.TokensConfig) (*tokens.KeyRing, error) {
+	active, err := loadSigningKey(tokensConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	ring := tokens.NewKeyRing(active)
+
+	// tokens signed by retired keys before restart expire within access TTL
+	retiredUntil := time.Now().Add(tokensConfig.AccessTTL)
+	for _, keyFile := range tokensConfig.VerificationKeyFiles {
+		key, err := tokens.LoadSigningKey(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		ring.Retire(key, retiredUntil)
+	}
+	for _, secret := range tokensConfig.PreviousSecrets {
+		key, err := tokens.NewHMACKey(secret)
+		if err != nil {
+			return nil, err
+		}
+		ring.Retire(key, retiredUntil)
+	}
+
+	return ring, nil
+}
+
+func loadSigningKey(tokensConfig config.TokensConfig) (*tokens.SigningKey, error) {
+	if tokensConfig.SigningKeyFile != "" {
+		return tokens.LoadSigningKey(tokensConfig.SigningKeyFile)
+	}
+
+	// fallback to shared secret
+	return tokens.NewHMACKey(tokensConfig.Secret)
+}
@@ -0,0 +1,23 @@
+package client
+
+import "context"
+
+// Info describes the device which made the request
+type Info struct {
+	IP        string
+	UserAgent string
+	// Accept-Language of the request
+	Locale string
+}
+
+type ctxKey struct{}
+
+func WithInfo(ctx context.Context, info Info) context.Context {
+	return context.WithValue(ctx, ctxKey{}, info)
+}
+
+// FromContext returns client info attached by transport layer, empty if none
+func FromContext(ctx context.Context) Info {
+	info, _ := ctx.Value(ctxKey{}).(Info)
+	return info
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"log"
+	"os"
 	"time"
 
 	"github.com/ilyakaznacheev/cleanenv"
@@ -12,19 +13,55 @@ type Config struct {
 	Env      string `yaml:"env" env:"ENV" env-default:"local"`
 	LogLevel string `yaml:"log_evel" env:"LOG_LEVEL" env-default:"info"`
 	Port     int    `yaml:"port" env:"PORT" env-required:"true"`
+	HTTPPort int    `yaml:"http_port" env:"HTTP_PORT" env-default:"8080"`
+
+	// Serve HTTP API on PORT together with gRPC, HTTP_PORT is not used then
+	HTTPMultiplex bool `yaml:"http_multiplex" env:"HTTP_MULTIPLEX" env-default:"false"`
+
+	// Token for admin RPCs (x-admin-token metadata). Admin RPCs are disabled if empty
+	AdminToken string `yaml:"admin_token" env:"ADMIN_TOKEN"`
+
+	// Clients of /oauth/introspect endpoint, "id:secret,id2:secret2". Endpoint rejects every request if empty
+	IntrospectionClients map[string]string `yaml:"introspection_clients" env:"INTROSPECTION_CLIENTS"`
 
 	PGConfig     PostgresConfig          `yaml:"postgres"`
 	TokensConfig TokensConfig            `yaml:"tokens"`
 	EVConfig     EmailVerificationConfig `yaml:"email_verification"`
+	Outbox       OutboxConfig            `yaml:"outbox"`
+	MFA          MFAConfig               `yaml:"mfa"`
+	WebAuthn     WebAuthnConfig          `yaml:"webauthn"`
+	Cookies      CookieConfig            `yaml:"cookies"`
+	CSRF         CSRFConfig              `yaml:"csrf"`
+	CORS         CORSConfig              `yaml:"cors"`
 
-	OauthGithub GithubAuth `yaml:"github_auth"`
+	OauthGithub      GithubAuth     `yaml:"github_auth"`
+	OAuthRedirectURL string         `yaml:"oauth_redirect_url" env:"OAUTH_REDIRECT_URL" env-default:"http://localhost:8080/oauth/callback"`
+	OAuthStateTTL    time.Duration  `yaml:"oauth_state_ttl" env:"OAUTH_STATE_TTL" env-default:"10m"`
+	OIDCProviders    []OIDCProvider `yaml:"oidc_providers"`
 }
 
 type TokensConfig struct {
 	AccessTTL  time.Duration `yaml:"access_ttl" env:"TOKENS_ACCESS_TTL" env-default:"15m"`
 	RefreshTTL time.Duration `yaml:"refresh_ttl" env:"TOKENS_REFRESH_TTL" env-default:"240h"`
 	RedisAddr  string        `yaml:"redis_addr" env:"REDIS_ADDR" env-required:"true"`
-	Secret     string        `yaml:"secret" env:"TOKENS_SECRET" env-required:"true"`
+
+	// iss and aud claims of access tokens, both are checked on validation
+	Issuer   string `yaml:"issuer" env:"TOKENS_ISSUER" env-default:"sync-auth"`
+	Audience string `yaml:"audience" env:"TOKENS_AUDIENCE" env-default:"sync"`
+
+	// Access tokens are signed with private key from SigningKeyFile (RS256, ES256 or EdDSA).
+	// If it is not set, tokens are signed with Secret (HS256)
+	SigningKeyFile string `yaml:"signing_key_file" env:"TOKENS_SIGNING_KEY_FILE"`
+	Secret         string `yaml:"secret" env:"TOKENS_SECRET"`
+
+	// Retired keys, used only to verify tokens issued before rotation
+	VerificationKeyFiles []string `yaml:"verification_key_files" env:"TOKENS_VERIFICATION_KEY_FILES" env-separator:","`
+	PreviousSecrets      []string `yaml:"previous_secrets" env:"TOKENS_PREVIOUS_SECRETS" env-separator:","`
+
+	// RotateSigningKey admin RPC loads the new key from this file, rotation is disabled if empty
+	RotationKeyFile string `yaml:"rotation_key_file" env:"TOKENS_ROTATION_KEY_FILE"`
+	// Rotated keys are stored in Postgres, every instance reloads them with this interval
+	KeySyncInterval time.Duration `yaml:"key_sync_interval" env:"TOKENS_KEY_SYNC_INTERVAL" env-default:"30s"`
 }
 
 type PostgresConfig struct {
@@ -37,10 +74,123 @@ type PostgresConfig struct {
 }
 
 type EmailVerificationConfig struct {
-	CodeTTL     time.Duration `yaml:"email_code_ttl" env:"EMAIL_CODE_TTL" env-default:"120s"`
-	AppEmail    string        `yaml:"app_email" env:"APP_EMAIL" env-required:"true"`
-	AppPassword string        `yaml:"app_password" env:"APP_PASSWORD" env-required:"true"`
-	AppSmtpHost string        `yaml:"app_smtp_host" env:"APP_SMTP_HOST" env-required:"true"`
+	CodeTTL time.Duration `yaml:"email_code_ttl" env:"EMAIL_CODE_TTL" env-default:"120s"`
+
+	// Limits of codes per user: pause between sends, sends per 24 hours and wrong guesses per code
+	CodeResendCooldown time.Duration `yaml:"email_code_resend_cooldown" env:"EMAIL_CODE_RESEND_COOLDOWN" env-default:"60s"`
+	CodeDailyLimit     int           `yaml:"email_code_daily_limit" env:"EMAIL_CODE_DAILY_LIMIT" env-default:"10"`
+	CodeMaxAttempts    int           `yaml:"email_code_max_attempts" env:"EMAIL_CODE_MAX_ATTEMPTS" env-default:"5"`
+
+	// Reset link is PasswordResetURL with token query parameter
+	PasswordResetTTL time.Duration `yaml:"password_reset_ttl" env:"PASSWORD_RESET_TTL" env-default:"30m"`
+	PasswordResetURL string        `yaml:"password_reset_url" env:"PASSWORD_RESET_URL" env-default:"http://localhost:8080/reset-password"`
+
+	// Passwordless login link is MagicLinkURL with token query parameter. Links are signed with
+	// MagicLinkSecret, random secret is generated on start if it is empty (links die on restart)
+	MagicLinkTTL    time.Duration `yaml:"magic_link_ttl" env:"MAGIC_LINK_TTL" env-default:"15m"`
+	MagicLinkURL    string        `yaml:"magic_link_url" env:"MAGIC_LINK_URL" env-default:"http://localhost:8080/magic-login"`
+	MagicLinkSecret string        `yaml:"magic_link_secret" env:"MAGIC_LINK_SECRET"`
+
+	// Sender address, also SMTP username unless AppSmtpUsername is set
+	AppEmail string `yaml:"app_email" env:"APP_EMAIL" env-required:"true"`
+
+	// Directory with email templates, one subdirectory per locale. Built in templates are used if empty
+	TemplatesDir  string `yaml:"templates_dir" env:"EMAIL_TEMPLATES_DIR"`
+	DefaultLocale string `yaml:"default_locale" env:"EMAIL_DEFAULT_LOCALE" env-default:"en"`
+
+	// Mailer is smtp or file (messages are written to MailOutboxDir instead of sending)
+	Mailer        string `yaml:"mailer" env:"MAILER" env-default:"smtp"`
+	MailOutboxDir string `yaml:"mail_outbox_dir" env:"MAIL_OUTBOX_DIR" env-default:"outbox"`
+
+	AppPassword     string `yaml:"app_password" env:"APP_PASSWORD"`
+	AppSmtpHost     string `yaml:"app_smtp_host" env:"APP_SMTP_HOST"`
+	AppSmtpPort     int    `yaml:"app_smtp_port" env:"APP_SMTP_PORT" env-default:"587"`
+	AppSmtpUsername string `yaml:"app_smtp_username" env:"APP_SMTP_USERNAME"`
+	// none, starttls or tls (implicit TLS, usually port 465)
+	AppSmtpSecurity string `yaml:"app_smtp_security" env:"APP_SMTP_SECURITY" env-default:"starttls"`
+	// none, plain, login or cram-md5
+	AppSmtpAuth    string        `yaml:"app_smtp_auth" env:"APP_SMTP_AUTH" env-default:"plain"`
+	AppSmtpTimeout time.Duration `yaml:"app_smtp_timeout" env:"APP_SMTP_TIMEOUT" env-default:"10s"`
+}
+
+// Delivery of emails from the outbox
+type OutboxConfig struct {
+	PollInterval time.Duration `yaml:"poll_interval" env:"OUTBOX_POLL_INTERVAL" env-default:"5s"`
+	BatchSize    int           `yaml:"batch_size" env:"OUTBOX_BATCH_SIZE" env-default:"20"`
+	MaxAttempts  int           `yaml:"max_attempts" env:"OUTBOX_MAX_ATTEMPTS" env-default:"10"`
+	BaseBackoff  time.Duration `yaml:"base_backoff" env:"OUTBOX_BASE_BACKOFF" env-default:"30s"`
+	MaxBackoff   time.Duration `yaml:"max_backoff" env:"OUTBOX_MAX_BACKOFF" env-default:"1h"`
+	SendTimeout  time.Duration `yaml:"send_timeout" env:"OUTBOX_SEND_TIMEOUT" env-default:"30s"`
+	// Sent and dead messages are deleted after Retention, checked every PurgeInterval
+	Retention     time.Duration `yaml:"retention" env:"OUTBOX_RETENTION" env-default:"168h"`
+	PurgeInterval time.Duration `yaml:"purge_interval" env:"OUTBOX_PURGE_INTERVAL" env-default:"1h"`
+}
+
+// Two-factor authentication with TOTP
+type MFAConfig struct {
+	// Issuer is shown in authenticator apps next to the account email
+	Issuer        string        `yaml:"issuer" env:"MFA_ISSUER" env-default:"sync-auth"`
+	ChallengeTTL  time.Duration `yaml:"challenge_ttl" env:"MFA_CHALLENGE_TTL" env-default:"5m"`
+	MaxAttempts   int           `yaml:"max_attempts" env:"MFA_MAX_ATTEMPTS" env-default:"5"`
+	RecoveryCodes int           `yaml:"recovery_codes" env:"MFA_RECOVERY_CODES" env-default:"10"`
+}
+
+// Passkeys. RPID is the domain of the site, origins are the exact origins
+// of pages which call navigator.credentials
+type WebAuthnConfig struct {
+	RPID    string        `yaml:"rp_id" env:"WEBAUTHN_RP_ID" env-default:"localhost"`
+	RPName  string        `yaml:"rp_name" env:"WEBAUTHN_RP_NAME" env-default:"sync-auth"`
+	Origins []string      `yaml:"origins" env:"WEBAUTHN_ORIGINS" env-separator:"," env-default:"http://localhost:8080"`
+	Timeout time.Duration `yaml:"timeout" env:"WEBAUTHN_TIMEOUT" env-default:"5m"`
+	// none, indirect or direct
+	Attestation string `yaml:"attestation" env:"WEBAUTHN_ATTESTATION" env-default:"none"`
+}
+
+// Cookies with tokens, set on login over HTTP API. They expire with the tokens
+type CookieConfig struct {
+	AccessName  string `yaml:"access_name" env:"COOKIE_ACCESS_NAME" env-default:"access_token"`
+	RefreshName string `yaml:"refresh_name" env:"COOKIE_REFRESH_NAME" env-default:"refresh_token"`
+	// host-only cookies if empty
+	Domain string `yaml:"domain" env:"COOKIE_DOMAIN"`
+	Path   string `yaml:"path" env:"COOKIE_PATH" env-default:"/"`
+	Secure bool   `yaml:"secure" env:"COOKIE_SECURE" env-default:"true"`
+	// lax, strict or none, none requires secure cookies
+	SameSite string `yaml:"same_site" env:"COOKIE_SAME_SITE" env-default:"lax"`
+}
+
+// CSRF protection of HTTP API. Tokens are signed with Secret, random secret
+// is generated on start if it is empty (tokens die on restart)
+type CSRFConfig struct {
+	Enabled    bool   `yaml:"enabled" env:"CSRF_ENABLED" env-default:"true"`
+	Secret     string `yaml:"secret" env:"CSRF_SECRET"`
+	CookieName string `yaml:"cookie_name" env:"CSRF_COOKIE_NAME" env-default:"csrf_token"`
+	HeaderName string `yaml:"header_name" env:"CSRF_HEADER_NAME" env-default:"X-CSRF-Token"`
+	// origins of frontends on other hosts, the service's own origin is always trusted
+	TrustedOrigins []string `yaml:"trusted_origins" env:"CSRF_TRUSTED_ORIGINS" env-separator:","`
+}
+
+// CORS of HTTP API. Cross-origin requests are blocked if no origins are allowed
+type CORSConfig struct {
+	AllowedOrigins []string `yaml:"allowed_origins" env:"CORS_ALLOWED_ORIGINS" env-separator:","`
+	AllowedMethods []string `yaml:"allowed_methods" env:"CORS_ALLOWED_METHODS" env-separator:"," env-default:"GET,POST"`
+	// CSRF header is allowed as well if CSRF protection is enabled
+	AllowedHeaders   []string      `yaml:"allowed_headers" env:"CORS_ALLOWED_HEADERS" env-separator:"," env-default:"Content-Type"`
+	ExposedHeaders   []string      `yaml:"exposed_headers" env:"CORS_EXPOSED_HEADERS" env-separator:","`
+	AllowCredentials bool          `yaml:"allow_credentials" env:"CORS_ALLOW_CREDENTIALS" env-default:"true"`
+	MaxAge           time.Duration `yaml:"max_age" env:"CORS_MAX_AGE" env-default:"10m"`
+	// Overrides for paths, path ending with / matches every path under it.
+	// Empty fields are taken from above
+	Routes []CORSRoute `yaml:"routes"`
+}
+
+type CORSRoute struct {
+	Path             string         `yaml:"path"`
+	AllowedOrigins   []string       `yaml:"allowed_origins"`
+	AllowedMethods   []string       `yaml:"allowed_methods"`
+	AllowedHeaders   []string       `yaml:"allowed_headers"`
+	ExposedHeaders   []string       `yaml:"exposed_headers"`
+	AllowCredentials *bool          `yaml:"allow_credentials"`
+	MaxAge           *time.Duration `yaml:"max_age"`
 }
 
 func Load() Config {
@@ -51,6 +201,15 @@ func Load() Config {
 		log.Fatal("Error loading .env file")
 	}
 
+	// yaml file is optional, environment variables override its values
+	if path := os.Getenv("CONFIG_PATH"); path != "" {
+		if err := cleanenv.ReadConfig(path, &config); err != nil {
+			log.Fatalf("couldn't read config file: %v", err)
+		}
+
+		return config
+	}
+
 	if err := cleanenv.ReadEnv(&config); err != nil {
 		log.Fatal("couldn't bind settings to config")
 	}
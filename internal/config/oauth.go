@@ -5,13 +5,6 @@ import (
 	"golang.org/x/oauth2/github"
 )
 
-type ClientInfoManager interface {
-	GetClientID() string
-	GetClientSecret() string
-	Client() string
-	Endpoint() oauth2.Endpoint
-}
-
 type GithubAuth struct {
 	ClientID     string `yaml:"github_client_id" env:"GITHUB_CLIENT_ID" env-required:"true"`
 	ClientSecret string `yaml:"github_client_secret" env:"GITHUB_CLIENT_SECRET" env-required:"true"`
@@ -32,3 +25,14 @@ func (g *GithubAuth) GetClientSecret() string {
 func (g *GithubAuth) Endpoint() oauth2.Endpoint {
 	return github.Endpoint
 }
+
+// OIDCProvider is any OpenID Connect identity provider.
+// Providers are configured only in yaml file (CONFIG_PATH)
+type OIDCProvider struct {
+	// name used as provider in ExchangeCodeForToken
+	Name         string   `yaml:"name"`
+	IssuerURL    string   `yaml:"issuer_url"`
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	Scopes       []string `yaml:"scopes"`
+}
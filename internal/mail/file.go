@@ -0,0 +1,45 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// FileMailer drops every message as .eml file into directory instead of
+// sending it, it is meant for local development
+type FileMailer struct {
+	dir  string
+	from string
+}
+
+func NewFileMailer(dir, from string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &FileMailer{dir: dir, from: from}, nil
+}
+
+func (m *FileMailer) Send(_ context.Context, msg Message) error {
+	const f = "mail.FileMailer.Send"
+
+	data, err := msg.Bytes(m.from)
+	if err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	// temp file name is unique, so concurrent sends don't overwrite each other
+	file, err := os.CreateTemp(m.dir, time.Now().UTC().Format("20060102T150405.000000000")+"-*.eml")
+	if err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(data); err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	return nil
+}
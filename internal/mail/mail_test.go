@@ -0,0 +1,241 @@
+package mail
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	netmail "net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// smtpSession is what fake server has received
+type smtpSession struct {
+	auth []string
+	from string
+	to   string
+	data string
+}
+
+// startSMTPServer serves one SMTP session without TLS
+func startSMTPServer(t *testing.T) (int, <-chan smtpSession) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	done := make(chan smtpSession, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var s smtpSession
+		tp := textproto.NewConn(conn)
+		_ = tp.PrintfLine("220 localhost ESMTP")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+
+			cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+			switch cmd {
+			case "EHLO":
+				_ = tp.PrintfLine("250-localhost")
+				_ = tp.PrintfLine("250 AUTH PLAIN LOGIN")
+			case "AUTH":
+				if strings.HasPrefix(line, "AUTH LOGIN") {
+					for _, prompt := range []string{"Username:", "Password:"} {
+						_ = tp.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte(prompt)))
+						answer, _ := tp.ReadLine()
+						decoded, _ := base64.StdEncoding.DecodeString(answer)
+						s.auth = append(s.auth, string(decoded))
+					}
+				} else {
+					decoded, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(line, "AUTH PLAIN "))
+					s.auth = strings.Split(string(decoded), "\x00")
+				}
+				_ = tp.PrintfLine("235 ok")
+			case "MAIL":
+				s.from = line
+				_ = tp.PrintfLine("250 ok")
+			case "RCPT":
+				s.to = line
+				_ = tp.PrintfLine("250 ok")
+			case "DATA":
+				_ = tp.PrintfLine("354 go ahead")
+				data, _ := tp.ReadDotBytes()
+				s.data = string(data)
+				_ = tp.PrintfLine("250 ok")
+			case "QUIT":
+				_ = tp.PrintfLine("221 bye")
+				done <- s
+				return
+			default:
+				_ = tp.PrintfLine("502 not implemented")
+			}
+		}
+	}()
+
+	return ln.Addr().(*net.TCPAddr).Port, done
+}
+
+func TestSMTPMailer_Send(t *testing.T) {
+	tests := []struct {
+		auth string
+		want []string
+	}{
+		{auth: AuthPlain, want: []string{"", "app@example.com", "secret"}},
+		{auth: AuthLogin, want: []string{"app@example.com", "secret"}},
+		{auth: AuthNone, want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.auth, func(t *testing.T) {
+			port, done := startSMTPServer(t)
+
+			mailer, err := NewSMTPMailer(SMTPConfig{
+				Host:     "127.0.0.1",
+				Port:     port,
+				Username: "app@example.com",
+				Password: "secret",
+				From:     "app@example.com",
+				Security: SecurityNone,
+				Auth:     tt.auth,
+			})
+			require.NoError(t, err)
+
+			err = mailer.Send(context.Background(), Message{To: "user@example.com", Subject: "Hello", Text: "Your code is 123456"})
+			require.NoError(t, err)
+
+			s := <-done
+			assert.Equal(t, tt.want, s.auth)
+			assert.Equal(t, "MAIL FROM:<app@example.com>", s.from)
+			assert.Equal(t, "RCPT TO:<user@example.com>", s.to)
+			assert.Contains(t, s.data, "Subject: Hello")
+			assert.Contains(t, s.data, "Your code is 123456")
+		})
+	}
+}
+
+func TestSMTPMailer_StartTLSNotSupported(t *testing.T) {
+	port, _ := startSMTPServer(t)
+
+	mailer, err := NewSMTPMailer(SMTPConfig{Host: "127.0.0.1", Port: port, Security: SecurityStartTLS, Auth: AuthNone})
+	require.NoError(t, err)
+
+	err = mailer.Send(context.Background(), Message{To: "user@example.com"})
+	assert.ErrorIs(t, err, ErrStartTLSNotSupported)
+}
+
+func TestNewSMTPMailer_InvalidConfig(t *testing.T) {
+	_, err := NewSMTPMailer(SMTPConfig{Security: "ssl", Auth: AuthPlain})
+	assert.ErrorIs(t, err, ErrUnknownSecurity)
+
+	_, err = NewSMTPMailer(SMTPConfig{Security: SecurityTLS, Auth: "xoauth2"})
+	assert.ErrorIs(t, err, ErrUnknownAuth)
+}
+
+func TestFileMailer_Send(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "outbox")
+	mailer, err := NewFileMailer(dir, "app@example.com")
+	require.NoError(t, err)
+
+	require.NoError(t, mailer.Send(context.Background(), Message{To: "a@example.com", Subject: "First", Text: "one"}))
+	require.NoError(t, mailer.Send(context.Background(), Message{To: "b@example.com", Subject: "Second", Text: "two"}))
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+
+	data, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+	msg, err := textproto.NewReader(bufio.NewReader(strings.NewReader(string(data)))).ReadMIMEHeader()
+	require.NoError(t, err)
+	assert.Equal(t, "app@example.com", msg.Get("From"))
+	assert.Equal(t, "a@example.com", msg.Get("To"))
+}
+
+func TestMemoryMailer(t *testing.T) {
+	mailer := NewMemoryMailer()
+
+	_, ok := mailer.Last()
+	assert.False(t, ok)
+
+	require.NoError(t, mailer.Send(context.Background(), Message{To: "a@example.com"}))
+	last, ok := mailer.Last()
+	assert.True(t, ok)
+	assert.Equal(t, "a@example.com", last.To)
+
+	failure := errors.New("smtp is down")
+	mailer.FailWith(failure)
+	assert.ErrorIs(t, mailer.Send(context.Background(), Message{To: "b@example.com"}), failure)
+	assert.Len(t, mailer.Messages(), 1)
+
+	mailer.Reset()
+	assert.Empty(t, mailer.Messages())
+}
+
+func TestMessage_Bytes(t *testing.T) {
+	data, err := Message{To: "a@example.com", Subject: "Код", Text: "line1\nline2"}.Bytes("app@example.com")
+	require.NoError(t, err)
+
+	s := string(data)
+	assert.Contains(t, s, "Subject: =?utf-8?q?")
+	assert.Contains(t, s, "Message-ID: <")
+	assert.Contains(t, s, "@example.com>")
+	assert.Contains(t, s, "line1\r\nline2")
+}
+
+func TestMessage_BytesMultipart(t *testing.T) {
+	data, err := Message{To: "a@example.com", Subject: "Hi", Text: "plain", HTML: "<p>html</p>"}.Bytes("app@example.com")
+	require.NoError(t, err)
+
+	msg, err := netmail.ReadMessage(bytes.NewReader(data))
+	require.NoError(t, err)
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/alternative", mediaType)
+
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	var types, bodies []string
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+
+		// multipart reader decodes quoted-printable
+		body, err := io.ReadAll(part)
+		require.NoError(t, err)
+		types = append(types, part.Header.Get("Content-Type"))
+		bodies = append(bodies, string(body))
+	}
+	assert.Equal(t, []string{"text/plain; charset=utf-8", "text/html; charset=utf-8"}, types)
+	assert.Equal(t, []string{"plain", "<p>html</p>"}, bodies)
+}
+
+func TestIsPermanent(t *testing.T) {
+	assert.True(t, IsPermanent(fmt.Errorf("send: %w", &textproto.Error{Code: 550, Msg: "mailbox unavailable"})))
+	assert.False(t, IsPermanent(&textproto.Error{Code: 421, Msg: "try again later"}))
+	assert.False(t, IsPermanent(errors.New("connection refused")))
+}
@@ -0,0 +1,216 @@
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+// Connection security
+const (
+	SecurityNone     = "none"
+	SecurityStartTLS = "starttls"
+	SecurityTLS      = "tls"
+)
+
+// Auth mechanisms
+const (
+	AuthNone    = "none"
+	AuthPlain   = "plain"
+	AuthLogin   = "login"
+	AuthCRAMMD5 = "cram-md5"
+)
+
+var (
+	ErrUnknownSecurity      = errors.New("unknown smtp security")
+	ErrUnknownAuth          = errors.New("unknown smtp auth mechanism")
+	ErrStartTLSNotSupported = errors.New("smtp server doesn't support STARTTLS")
+)
+
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+
+	// Security is one of none, starttls or tls (implicit TLS, usually port 465)
+	Security string
+	// Auth is one of none, plain, login or cram-md5
+	Auth    string
+	Timeout time.Duration
+}
+
+type SMTPMailer struct {
+	cfg SMTPConfig
+}
+
+func NewSMTPMailer(cfg SMTPConfig) (*SMTPMailer, error) {
+	switch cfg.Security {
+	case SecurityNone, SecurityStartTLS, SecurityTLS:
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownSecurity, cfg.Security)
+	}
+
+	switch cfg.Auth {
+	case AuthNone, AuthPlain, AuthLogin, AuthCRAMMD5:
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownAuth, cfg.Auth)
+	}
+
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+
+	return &SMTPMailer{cfg: cfg}, nil
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	const f = "mail.SMTPMailer.Send"
+
+	data, err := msg.Bytes(m.cfg.From)
+	if err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	client, err := m.dial(ctx)
+	if err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+	defer client.Close()
+
+	if m.cfg.Security == SecurityStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("%s:%w", f, ErrStartTLSNotSupported)
+		}
+		if err := client.StartTLS(m.tlsConfig()); err != nil {
+			return fmt.Errorf("%s:%w", f, err)
+		}
+	}
+
+	if auth := m.auth(); auth != nil {
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("%s:%w", f, err)
+		}
+	}
+
+	if err := client.Mail(m.cfg.From); err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+	if err := client.Rcpt(msg.To); err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	if err := client.Quit(); err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	return nil
+}
+
+func (m *SMTPMailer) dial(ctx context.Context) (*smtp.Client, error) {
+	addr := net.JoinHostPort(m.cfg.Host, strconv.Itoa(m.cfg.Port))
+
+	// whole conversation has to fit into timeout
+	deadline := time.Now().Add(m.cfg.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	if m.cfg.Security == SecurityTLS {
+		dialer := &tls.Dialer{NetDialer: &net.Dialer{Deadline: deadline}, Config: m.tlsConfig()}
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	} else {
+		dialer := &net.Dialer{Deadline: deadline}
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	client, err := smtp.NewClient(conn, m.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return client, nil
+}
+
+func (m *SMTPMailer) tlsConfig() *tls.Config {
+	return &tls.Config{ServerName: m.cfg.Host, MinVersion: tls.VersionTLS12}
+}
+
+func (m *SMTPMailer) auth() smtp.Auth {
+	switch m.cfg.Auth {
+	case AuthPlain:
+		return smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, m.cfg.Host)
+	case AuthLogin:
+		return &loginAuth{username: m.cfg.Username, password: m.cfg.Password, host: m.cfg.Host}
+	case AuthCRAMMD5:
+		return smtp.CRAMMD5Auth(m.cfg.Username, m.cfg.Password)
+	default:
+		return nil
+	}
+}
+
+// loginAuth implements LOGIN mechanism which net/smtp doesn't provide
+type loginAuth struct {
+	username, password, host string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	// same rule as smtp.PlainAuth, credentials are sent in clear text
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+
+	switch string(fromServer) {
+	case "Username:":
+		return []byte(a.username), nil
+	case "Password:":
+		return []byte(a.password), nil
+	default:
+		return nil, fmt.Errorf("unexpected server challenge %q", fromServer)
+	}
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
@@ -9,6 +9,9 @@ type User struct {
 	CreatedAt     time.Time
 	UpdatedAt     time.Time
 	EmailVerified bool
+	Roles         []string
+	// Preferred language of emails, BCP 47 tag
+	Locale string
 }
 
 type TokenPair struct {
@@ -16,12 +19,233 @@ type TokenPair struct {
 	RefreshToken string
 }
 
+type RefreshToken struct {
+	Token     string
+	UserID    int32
+	SessionID string
+}
+
+// AccessClaims are claims of validated access token
+type AccessClaims struct {
+	ID            string
+	UserID        int32
+	EmailVerified bool
+	Roles         []string
+	SessionID     string
+	Issuer        string
+	Audience      string
+	IssuedAt      time.Time
+	ExpiresAt     time.Time
+}
+
+type RefreshClaims struct {
+	UserID    int32
+	SessionID string
+	Issuer    string
+	Audience  string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// TokenIntrospection is a state of the token (RFC 7662)
+type TokenIntrospection struct {
+	Active    bool
+	UserID    int32
+	SessionID string
+	Scope     string
+	ClientID  string
+	Issuer    string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
 type VerifyEmailResp struct {
 	Status  string
 	CodeTTL time.Duration
+	// Time until the next code can be requested
+	ResendAfter time.Duration
+	// Codes left for today
+	SendsLeft int
 }
 
 type ConfirmCodeResp struct {
-	Success bool
-	Message string
+	Success      bool
+	Message      string
+	AttemptsLeft int
+}
+
+// CodeSendLimit is the state of limits on sending verification codes
+type CodeSendLimit struct {
+	RetryAfter time.Duration
+	SendsLeft  int
+}
+
+// JWK is a public JSON Web Key (RFC 7517) used to verify access tokens
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// StoredSigningKey is a rotated signing key shared by all instances
+type StoredSigningKey struct {
+	ID string
+	// PEM encoded private key
+	PrivateKey  []byte
+	ActivatedAt time.Time
+	// zero for the active key
+	RetiredUntil time.Time
+}
+
+type KeyRotation struct {
+	KeyID         string
+	Algorithm     string
+	PreviousKeyID string
+	RetiredUntil  time.Time
+}
+
+type SecurityEventType string
+
+const (
+	EventRefreshTokenReuse SecurityEventType = "refresh_token_reuse"
+	EventMFADisabled       SecurityEventType = "mfa_disabled"
+	EventRecoveryCodeUsed  SecurityEventType = "recovery_code_used"
+	EventPasskeySignCount  SecurityEventType = "passkey_sign_count_not_increased"
+)
+
+type SecurityEvent struct {
+	Type        SecurityEventType
+	UserID      int32
+	Fingerprint string
+	At          time.Time
+}
+
+// Session is a chain of rotated refresh tokens started by one login
+type Session struct {
+	ID          string
+	UserID      int32
+	Fingerprint string
+	IP          string
+	UserAgent   string
+	CreatedAt   time.Time
+	LastUsedAt  time.Time
+	Current     bool
+}
+
+// ExternalIdentity is a user account at external OAuth provider
+type ExternalIdentity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+// OAuthPurpose is what authorization at external provider is started for
+type OAuthPurpose string
+
+const (
+	OAuthLogin OAuthPurpose = "login"
+	OAuthLink  OAuthPurpose = "link"
+)
+
+// OAuthState is authorization request to external provider
+type OAuthState struct {
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	Purpose      OAuthPurpose
+	// UserID is the signed in user who links the identity, 0 for login
+	UserID int32
+}
+
+type OAuthRedirect struct {
+	URL       string
+	State     string
+	ExpiresAt time.Time
+}
+
+// Identity is external account linked to the user
+type Identity struct {
+	Provider string
+	Subject  string
+	Email    string
+	LinkedAt time.Time
+}
+
+// PasswordlessLogin is a pending login by code or magic link with the nonce
+type PasswordlessLogin struct {
+	Code  int32
+	Nonce string
+}
+
+// TOTP is authenticator app secret of the user, it is required on login only when enabled
+type TOTP struct {
+	Secret  string
+	Enabled bool
+}
+
+// TOTPEnrollment is shown to the user once to add the account to authenticator app
+type TOTPEnrollment struct {
+	Secret string
+	URI    string
+}
+
+// MFAChallenge is returned by login instead of tokens when second factor is required.
+// Empty Token means the user is already logged in
+type MFAChallenge struct {
+	Token     string
+	ExpiresIn time.Duration
+	// second factors the user can use: totp, recovery_code and passkey
+	Methods []string
+}
+
+// MFALogin is a login waiting for the second factor
+type MFALogin struct {
+	UserID      int32
+	Fingerprint string
+}
+
+// Passkey is WebAuthn credential of the user. PublicKey is COSE_Key
+type Passkey struct {
+	ID         []byte
+	UserID     int32
+	Name       string
+	PublicKey  []byte
+	SignCount  uint32
+	AAGUID     []byte
+	Transports []string
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+}
+
+// WebAuthnCeremony is a started passkey registration or login waiting for the authenticator response
+type WebAuthnCeremony struct {
+	// Challenge is the one sent to the authenticator, set by storage
+	Challenge string
+	Purpose   string
+	UserID    int32
+	// hash of MFA challenge token when passkey is used as the second factor
+	MFATokenHash string
+}
+
+// PendingEmail is a new email of the user waiting for confirmation
+type PendingEmail struct {
+	Email string
+	Code  int32
+}
+
+// OutboxEmail is a message waiting for delivery in the email outbox
+type OutboxEmail struct {
+	ID       int64
+	To       string
+	Subject  string
+	Text     string
+	HTML     string
+	Attempts int
 }
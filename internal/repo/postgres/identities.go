@@ -0,0 +1,143 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/kuromii5/sync-auth/internal/models"
+)
+
+// UserByIdentity returns user which has linked external account
+func (d *DB) UserByIdentity(ctx context.Context, provider, subject string) (models.User, error) {
+	const f = "postgres.UserByIdentity"
+
+	query := `SELECT u.id, u.email, u.pass_hash, u.created_at, u.updated_at, u.email_verified, u.roles, u.locale
+		FROM users u JOIN user_identities i ON i.user_id = u.id
+		WHERE i.provider = $1 AND i.subject = $2`
+
+	var user models.User
+	err := d.Pool.QueryRow(ctx, query, provider, subject).
+		Scan(&user.ID, &user.Email, &user.PasswordHash, &user.CreatedAt, &user.UpdatedAt, &user.EmailVerified, &user.Roles, &user.Locale)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.User{}, fmt.Errorf("%s:%w", f, ErrUserNotFound)
+		}
+
+		return models.User{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	return user, nil
+}
+
+// SaveUserWithIdentity creates user without password and links external account to it.
+// Email of the user is considered verified by the provider
+func (d *DB) SaveUserWithIdentity(ctx context.Context, identity models.ExternalIdentity) (int32, error) {
+	const f = "postgres.SaveUserWithIdentity"
+
+	tx, err := d.Pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("%s:%w", f, err)
+	}
+	defer tx.Rollback(ctx)
+
+	var userID int32
+	err = tx.QueryRow(ctx, "INSERT INTO users (email, email_verified) VALUES ($1, TRUE) RETURNING id", identity.Email).
+		Scan(&userID)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return 0, fmt.Errorf("%s:%w", f, ErrUserExists)
+		}
+
+		return 0, fmt.Errorf("%s:%w", f, err)
+	}
+
+	if err := linkIdentity(ctx, tx, userID, identity); err != nil {
+		return 0, fmt.Errorf("%s:%w", f, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("%s:%w", f, err)
+	}
+
+	return userID, nil
+}
+
+func (d *DB) LinkIdentity(ctx context.Context, userID int32, identity models.ExternalIdentity) error {
+	const f = "postgres.LinkIdentity"
+
+	if err := linkIdentity(ctx, d.Pool, userID, identity); err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	return nil
+}
+
+func (d *DB) UnlinkIdentity(ctx context.Context, userID int32, provider string) error {
+	const f = "postgres.UnlinkIdentity"
+
+	query := "DELETE FROM user_identities WHERE user_id = $1 AND provider = $2"
+
+	res, err := d.Pool.Exec(ctx, query, userID, provider)
+	if err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%s:%w", f, ErrIdentityNotFound)
+	}
+
+	return nil
+}
+
+func (d *DB) Identities(ctx context.Context, userID int32) ([]models.Identity, error) {
+	const f = "postgres.Identities"
+
+	query := "SELECT provider, subject, email, linked_at FROM user_identities WHERE user_id = $1 ORDER BY linked_at"
+
+	rows, err := d.Pool.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", f, err)
+	}
+	defer rows.Close()
+
+	var identities []models.Identity
+	for rows.Next() {
+		var identity models.Identity
+		if err := rows.Scan(&identity.Provider, &identity.Subject, &identity.Email, &identity.LinkedAt); err != nil {
+			return nil, fmt.Errorf("%s:%w", f, err)
+		}
+		identities = append(identities, identity)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s:%w", f, err)
+	}
+
+	return identities, nil
+}
+
+type execer interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+}
+
+func linkIdentity(ctx context.Context, db execer, userID int32, identity models.ExternalIdentity) error {
+	query := "INSERT INTO user_identities (user_id, provider, subject, email) VALUES ($1, $2, $3, $4)"
+
+	_, err := db.Exec(ctx, query, userID, identity.Provider, identity.Subject, identity.Email)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrIdentityExists
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
@@ -0,0 +1,102 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/kuromii5/sync-auth/internal/models"
+	"github.com/pashagolub/pgxmock"
+)
+
+var testIdentity = models.ExternalIdentity{
+	Provider:      "google",
+	Subject:       "subject",
+	Email:         "test@example.com",
+	EmailVerified: true,
+}
+
+func (s *PostgresTestSuite) TestUserByIdentity_Success() {
+	createdAt, _ := time.Parse("2006-01-02", "2023-10-12")
+	s.mockPool.ExpectQuery("SELECT (.+) FROM users u JOIN user_identities i").
+		WithArgs("google", "subject").
+		WillReturnRows(pgxmock.NewRows([]string{"id", "email", "pass_hash", "created_at", "updated_at", "email_verified", "roles", "locale"}).
+			AddRow(int32(1), "test@example.com", []byte(nil), createdAt, createdAt, true, []string{"user"}, ""))
+
+	got, err := s.db.UserByIdentity(context.Background(), "google", "subject")
+	s.NoError(err)
+	s.Equal(int32(1), got.ID)
+	s.True(got.EmailVerified)
+}
+
+func (s *PostgresTestSuite) TestUserByIdentity_NotFound() {
+	s.mockPool.ExpectQuery("SELECT (.+) FROM users u JOIN user_identities i").
+		WithArgs("google", "subject").
+		WillReturnError(pgx.ErrNoRows)
+
+	_, err := s.db.UserByIdentity(context.Background(), "google", "subject")
+	s.True(errors.Is(err, ErrUserNotFound), "ErrUserNotFound was expected")
+}
+
+func (s *PostgresTestSuite) TestSaveUserWithIdentity_Success() {
+	s.mockPool.ExpectBegin()
+	s.mockPool.ExpectQuery(regexp.QuoteMeta("INSERT INTO users (email, email_verified) VALUES ($1, TRUE) RETURNING id")).
+		WithArgs("test@example.com").
+		WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(int32(1)))
+	s.mockPool.ExpectExec("INSERT INTO user_identities").
+		WithArgs(int32(1), "google", "subject", "test@example.com").
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	s.mockPool.ExpectCommit()
+
+	got, err := s.db.SaveUserWithIdentity(context.Background(), testIdentity)
+	s.NoError(err)
+	s.Equal(int32(1), got)
+}
+
+func (s *PostgresTestSuite) TestSaveUserWithIdentity_UserExists() {
+	s.mockPool.ExpectBegin()
+	s.mockPool.ExpectQuery("INSERT INTO users").
+		WithArgs("test@example.com").
+		WillReturnError(&pgconn.PgError{Code: "23505"})
+	s.mockPool.ExpectRollback()
+
+	_, err := s.db.SaveUserWithIdentity(context.Background(), testIdentity)
+	s.True(errors.Is(err, ErrUserExists), "ErrUserExists was expected")
+}
+
+func (s *PostgresTestSuite) TestLinkIdentity_Exists() {
+	s.mockPool.ExpectExec("INSERT INTO user_identities").
+		WithArgs(int32(1), "google", "subject", "test@example.com").
+		WillReturnError(&pgconn.PgError{Code: "23505"})
+
+	err := s.db.LinkIdentity(context.Background(), 1, testIdentity)
+	s.True(errors.Is(err, ErrIdentityExists), "ErrIdentityExists was expected")
+}
+
+func (s *PostgresTestSuite) TestUnlinkIdentity_NotFound() {
+	s.mockPool.ExpectExec(regexp.QuoteMeta("DELETE FROM user_identities WHERE user_id = $1 AND provider = $2")).
+		WithArgs(int32(1), "google").
+		WillReturnResult(pgxmock.NewResult("DELETE", 0))
+
+	err := s.db.UnlinkIdentity(context.Background(), 1, "google")
+	s.True(errors.Is(err, ErrIdentityNotFound), "ErrIdentityNotFound was expected")
+}
+
+func (s *PostgresTestSuite) TestIdentities() {
+	linkedAt, _ := time.Parse("2006-01-02", "2023-10-12")
+	s.mockPool.ExpectQuery("SELECT provider, subject, email, linked_at FROM user_identities").
+		WithArgs(int32(1)).
+		WillReturnRows(pgxmock.NewRows([]string{"provider", "subject", "email", "linked_at"}).
+			AddRow("github", "42", "test@example.com", linkedAt).
+			AddRow("google", "subject", "test@example.com", linkedAt))
+
+	got, err := s.db.Identities(context.Background(), 1)
+	s.NoError(err)
+	s.Equal([]models.Identity{
+		{Provider: "github", Subject: "42", Email: "test@example.com", LinkedAt: linkedAt},
+		{Provider: "google", Subject: "subject", Email: "test@example.com", LinkedAt: linkedAt},
+	}, got)
+}
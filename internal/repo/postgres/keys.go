@@ -0,0 +1,74 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kuromii5/sync-auth/internal/models"
+)
+
+// SigningKeys returns the active key and retired keys which are not expired yet
+func (d *DB) SigningKeys(ctx context.Context) ([]models.StoredSigningKey, error) {
+	const f = "postgres.SigningKeys"
+
+	query := `SELECT kid, private_key, activated_at, retired_until FROM signing_keys
+		WHERE retired_until IS NULL OR retired_until > NOW() ORDER BY activated_at`
+
+	rows, err := d.Pool.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", f, err)
+	}
+	defer rows.Close()
+
+	var keys []models.StoredSigningKey
+	for rows.Next() {
+		var key models.StoredSigningKey
+		var retiredUntil *time.Time
+		if err := rows.Scan(&key.ID, &key.PrivateKey, &key.ActivatedAt, &retiredUntil); err != nil {
+			return nil, fmt.Errorf("%s:%w", f, err)
+		}
+		if retiredUntil != nil {
+			key.RetiredUntil = *retiredUntil
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s:%w", f, err)
+	}
+
+	return keys, nil
+}
+
+// SaveActiveSigningKey makes the key active. Previous active key is retired
+// for retireAfter, expired keys are deleted
+func (d *DB) SaveActiveSigningKey(ctx context.Context, key models.StoredSigningKey, retireAfter time.Duration) error {
+	const f = "postgres.SaveActiveSigningKey"
+
+	tx, err := d.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "DELETE FROM signing_keys WHERE retired_until <= NOW()"); err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	query := "UPDATE signing_keys SET retired_until = NOW() + $2 * INTERVAL '1 second' WHERE retired_until IS NULL AND kid <> $1"
+	if _, err := tx.Exec(ctx, query, key.ID, retireAfter.Seconds()); err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	query = `INSERT INTO signing_keys (kid, private_key) VALUES ($1, $2)
+		ON CONFLICT (kid) DO UPDATE SET private_key = EXCLUDED.private_key, activated_at = NOW(), retired_until = NULL`
+	if _, err := tx.Exec(ctx, query, key.ID, key.PrivateKey); err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,43 @@
+package postgres
+
+import (
+	"context"
+	"regexp"
+	"time"
+
+	"github.com/kuromii5/sync-auth/internal/models"
+	"github.com/pashagolub/pgxmock"
+)
+
+func (s *PostgresTestSuite) TestSigningKeys() {
+	activatedAt := time.Now().Add(-time.Hour)
+	retiredUntil := time.Now().Add(time.Minute)
+
+	s.mockPool.ExpectQuery("SELECT kid, private_key, activated_at, retired_until FROM signing_keys").
+		WillReturnRows(pgxmock.NewRows([]string{"kid", "private_key", "activated_at", "retired_until"}).
+			AddRow("old", []byte("old pem"), activatedAt, &retiredUntil).
+			AddRow("new", []byte("new pem"), activatedAt, (*time.Time)(nil)))
+
+	got, err := s.db.SigningKeys(context.Background())
+	s.NoError(err)
+	s.Equal([]models.StoredSigningKey{
+		{ID: "old", PrivateKey: []byte("old pem"), ActivatedAt: activatedAt, RetiredUntil: retiredUntil},
+		{ID: "new", PrivateKey: []byte("new pem"), ActivatedAt: activatedAt},
+	}, got)
+}
+
+func (s *PostgresTestSuite) TestSaveActiveSigningKey() {
+	s.mockPool.ExpectBegin()
+	s.mockPool.ExpectExec(regexp.QuoteMeta("DELETE FROM signing_keys WHERE retired_until <= NOW()")).
+		WillReturnResult(pgxmock.NewResult("DELETE", 0))
+	s.mockPool.ExpectExec("UPDATE signing_keys SET retired_until").
+		WithArgs("new", float64(900)).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	s.mockPool.ExpectExec("INSERT INTO signing_keys").
+		WithArgs("new", []byte("new pem")).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	s.mockPool.ExpectCommit()
+
+	err := s.db.SaveActiveSigningKey(context.Background(), models.StoredSigningKey{ID: "new", PrivateKey: []byte("new pem")}, 15*time.Minute)
+	s.NoError(err)
+}
@@ -0,0 +1,181 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/kuromii5/sync-auth/internal/models"
+)
+
+var (
+	ErrTOTPEnabled          = errors.New("totp is already enabled")
+	ErrTOTPNotFound         = errors.New("totp is not enrolled")
+	ErrRecoveryCodeNotFound = errors.New("recovery code not found or already used")
+)
+
+// SaveTOTPSecret starts enrollment with a new secret. Unconfirmed secret is
+// replaced, confirmed one can only be removed by DisableTOTP
+func (d *DB) SaveTOTPSecret(ctx context.Context, userID int32, secret string) error {
+	const f = "postgres.SaveTOTPSecret"
+
+	query := `INSERT INTO user_totp (user_id, secret) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, created_at = NOW()
+		WHERE user_totp.confirmed_at IS NULL`
+
+	res, err := d.Pool.Exec(ctx, query, userID, secret)
+	if err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%s:%w", f, ErrTOTPEnabled)
+	}
+
+	return nil
+}
+
+func (d *DB) TOTP(ctx context.Context, userID int32) (models.TOTP, error) {
+	const f = "postgres.TOTP"
+
+	query := "SELECT secret, confirmed_at IS NOT NULL FROM user_totp WHERE user_id = $1"
+
+	var totp models.TOTP
+	err := d.Pool.QueryRow(ctx, query, userID).Scan(&totp.Secret, &totp.Enabled)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.TOTP{}, fmt.Errorf("%s:%w", f, ErrTOTPNotFound)
+		}
+
+		return models.TOTP{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	return totp, nil
+}
+
+// EnableTOTP confirms enrollment and saves hashes of the first recovery codes
+func (d *DB) EnableTOTP(ctx context.Context, userID int32, recoveryCodeHashes []string) error {
+	const f = "postgres.EnableTOTP"
+
+	tx, err := d.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := "UPDATE user_totp SET confirmed_at = NOW() WHERE user_id = $1 AND confirmed_at IS NULL"
+
+	res, err := tx.Exec(ctx, query, userID)
+	if err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%s:%w", f, ErrTOTPEnabled)
+	}
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, recoveryCodeHashes); err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	return nil
+}
+
+// DisableTOTP removes the secret together with recovery codes
+func (d *DB) DisableTOTP(ctx context.Context, userID int32) error {
+	const f = "postgres.DisableTOTP"
+
+	tx, err := d.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+	defer tx.Rollback(ctx)
+
+	res, err := tx.Exec(ctx, "DELETE FROM user_totp WHERE user_id = $1", userID)
+	if err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%s:%w", f, ErrTOTPNotFound)
+	}
+
+	if _, err := tx.Exec(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	return nil
+}
+
+// ReplaceRecoveryCodes invalidates all recovery codes of the user and saves new ones
+func (d *DB) ReplaceRecoveryCodes(ctx context.Context, userID int32, recoveryCodeHashes []string) error {
+	const f = "postgres.ReplaceRecoveryCodes"
+
+	tx, err := d.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, recoveryCodeHashes); err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	return nil
+}
+
+// HasRecoveryCode reports whether the code exists and is not used yet
+func (d *DB) HasRecoveryCode(ctx context.Context, userID int32, codeHash string) (bool, error) {
+	const f = "postgres.HasRecoveryCode"
+
+	query := "SELECT EXISTS (SELECT 1 FROM recovery_codes WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL)"
+
+	var exists bool
+	if err := d.Pool.QueryRow(ctx, query, userID, codeHash).Scan(&exists); err != nil {
+		return false, fmt.Errorf("%s:%w", f, err)
+	}
+
+	return exists, nil
+}
+
+// UseRecoveryCode marks the code as used, so it is accepted only once
+func (d *DB) UseRecoveryCode(ctx context.Context, userID int32, codeHash string) error {
+	const f = "postgres.UseRecoveryCode"
+
+	query := "UPDATE recovery_codes SET used_at = NOW() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL"
+
+	res, err := d.Pool.Exec(ctx, query, userID, codeHash)
+	if err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%s:%w", f, ErrRecoveryCodeNotFound)
+	}
+
+	return nil
+}
+
+func replaceRecoveryCodes(ctx context.Context, db execer, userID int32, hashes []string) error {
+	if _, err := db.Exec(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+
+	query := "INSERT INTO recovery_codes (user_id, code_hash) SELECT $1, UNNEST($2::VARCHAR[])"
+	if _, err := db.Exec(ctx, query, userID, hashes); err != nil {
+		return err
+	}
+
+	return nil
+}
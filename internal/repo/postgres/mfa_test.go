@@ -0,0 +1,108 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"regexp"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/kuromii5/sync-auth/internal/models"
+	"github.com/pashagolub/pgxmock"
+)
+
+func (s *PostgresTestSuite) TestSaveTOTPSecret_Success() {
+	s.mockPool.ExpectExec("INSERT INTO user_totp").
+		WithArgs(int32(1), "SECRET").
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+
+	s.NoError(s.db.SaveTOTPSecret(context.Background(), 1, "SECRET"))
+}
+
+func (s *PostgresTestSuite) TestSaveTOTPSecret_Enabled() {
+	s.mockPool.ExpectExec("INSERT INTO user_totp").
+		WithArgs(int32(1), "SECRET").
+		WillReturnResult(pgxmock.NewResult("INSERT", 0))
+
+	err := s.db.SaveTOTPSecret(context.Background(), 1, "SECRET")
+	s.True(errors.Is(err, ErrTOTPEnabled), "ErrTOTPEnabled was expected")
+}
+
+func (s *PostgresTestSuite) TestTOTP() {
+	s.mockPool.ExpectQuery("SELECT secret, confirmed_at IS NOT NULL FROM user_totp").
+		WithArgs(int32(1)).
+		WillReturnRows(pgxmock.NewRows([]string{"secret", "enabled"}).AddRow("SECRET", true))
+
+	got, err := s.db.TOTP(context.Background(), 1)
+	s.NoError(err)
+	s.Equal(models.TOTP{Secret: "SECRET", Enabled: true}, got)
+}
+
+func (s *PostgresTestSuite) TestTOTP_NotFound() {
+	s.mockPool.ExpectQuery("SELECT (.+) FROM user_totp").
+		WithArgs(int32(1)).
+		WillReturnError(pgx.ErrNoRows)
+
+	_, err := s.db.TOTP(context.Background(), 1)
+	s.True(errors.Is(err, ErrTOTPNotFound), "ErrTOTPNotFound was expected")
+}
+
+func (s *PostgresTestSuite) TestEnableTOTP_Success() {
+	hashes := []string{"hash1", "hash2"}
+
+	s.mockPool.ExpectBegin()
+	s.mockPool.ExpectExec(regexp.QuoteMeta("UPDATE user_totp SET confirmed_at = NOW() WHERE user_id = $1 AND confirmed_at IS NULL")).
+		WithArgs(int32(1)).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	s.mockPool.ExpectExec(regexp.QuoteMeta("DELETE FROM recovery_codes WHERE user_id = $1")).
+		WithArgs(int32(1)).
+		WillReturnResult(pgxmock.NewResult("DELETE", 0))
+	s.mockPool.ExpectExec("INSERT INTO recovery_codes").
+		WithArgs(int32(1), hashes).
+		WillReturnResult(pgxmock.NewResult("INSERT", 2))
+	s.mockPool.ExpectCommit()
+
+	s.NoError(s.db.EnableTOTP(context.Background(), 1, hashes))
+}
+
+func (s *PostgresTestSuite) TestEnableTOTP_AlreadyEnabled() {
+	s.mockPool.ExpectBegin()
+	s.mockPool.ExpectExec("UPDATE user_totp").
+		WithArgs(int32(1)).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 0))
+	s.mockPool.ExpectRollback()
+
+	err := s.db.EnableTOTP(context.Background(), 1, []string{"hash"})
+	s.True(errors.Is(err, ErrTOTPEnabled), "ErrTOTPEnabled was expected")
+}
+
+func (s *PostgresTestSuite) TestDisableTOTP() {
+	s.mockPool.ExpectBegin()
+	s.mockPool.ExpectExec(regexp.QuoteMeta("DELETE FROM user_totp WHERE user_id = $1")).
+		WithArgs(int32(1)).
+		WillReturnResult(pgxmock.NewResult("DELETE", 1))
+	s.mockPool.ExpectExec(regexp.QuoteMeta("DELETE FROM recovery_codes WHERE user_id = $1")).
+		WithArgs(int32(1)).
+		WillReturnResult(pgxmock.NewResult("DELETE", 10))
+	s.mockPool.ExpectCommit()
+
+	s.NoError(s.db.DisableTOTP(context.Background(), 1))
+}
+
+func (s *PostgresTestSuite) TestHasRecoveryCode() {
+	s.mockPool.ExpectQuery("SELECT EXISTS").
+		WithArgs(int32(1), "hash").
+		WillReturnRows(pgxmock.NewRows([]string{"exists"}).AddRow(true))
+
+	exists, err := s.db.HasRecoveryCode(context.Background(), 1, "hash")
+	s.NoError(err)
+	s.True(exists)
+}
+
+func (s *PostgresTestSuite) TestUseRecoveryCode_Used() {
+	s.mockPool.ExpectExec("UPDATE recovery_codes SET used_at = NOW()").
+		WithArgs(int32(1), "hash").
+		WillReturnResult(pgxmock.NewResult("UPDATE", 0))
+
+	err := s.db.UseRecoveryCode(context.Background(), 1, "hash")
+	s.True(errors.Is(err, ErrRecoveryCodeNotFound), "ErrRecoveryCodeNotFound was expected")
+}
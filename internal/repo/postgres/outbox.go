@@ -0,0 +1,111 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kuromii5/sync-auth/internal/mail"
+	"github.com/kuromii5/sync-auth/internal/models"
+)
+
+// EnqueueEmail saves message to the outbox, it is sent by outbox worker
+func (d *DB) EnqueueEmail(ctx context.Context, msg mail.Message) error {
+	const f = "postgres.EnqueueEmail"
+
+	query := "INSERT INTO email_outbox (recipient, subject, text_body, html_body) VALUES ($1, $2, $3, $4)"
+
+	if _, err := d.Pool.Exec(ctx, query, msg.To, msg.Subject, msg.Text, msg.HTML); err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	return nil
+}
+
+// ClaimEmails returns up to limit pending messages which are due and hides them
+// from other workers for lease. Message comes back if the worker dies before
+// reporting the result
+func (d *DB) ClaimEmails(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxEmail, error) {
+	const f = "postgres.ClaimEmails"
+
+	query := `UPDATE email_outbox SET attempts = attempts + 1, next_attempt_at = NOW() + $2 * INTERVAL '1 second'
+		WHERE id IN (
+			SELECT id FROM email_outbox WHERE status = 'pending' AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at LIMIT $1 FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, recipient, subject, text_body, html_body, attempts`
+
+	rows, err := d.Pool.Query(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", f, err)
+	}
+	defer rows.Close()
+
+	var emails []models.OutboxEmail
+	for rows.Next() {
+		var email models.OutboxEmail
+		if err := rows.Scan(&email.ID, &email.To, &email.Subject, &email.Text, &email.HTML, &email.Attempts); err != nil {
+			return nil, fmt.Errorf("%s:%w", f, err)
+		}
+		emails = append(emails, email)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s:%w", f, err)
+	}
+
+	return emails, nil
+}
+
+// MarkEmailSent finishes delivery of message. Bodies are cleared since they
+// have one-time codes and links which must not be kept
+func (d *DB) MarkEmailSent(ctx context.Context, id int64) error {
+	const f = "postgres.MarkEmailSent"
+
+	query := "UPDATE email_outbox SET status = 'sent', sent_at = NOW(), last_error = '', text_body = '', html_body = '' WHERE id = $1"
+
+	if _, err := d.Pool.Exec(ctx, query, id); err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	return nil
+}
+
+// RetryEmail schedules the next attempt of failed message after delay
+func (d *DB) RetryEmail(ctx context.Context, id int64, delay time.Duration, lastErr string) error {
+	const f = "postgres.RetryEmail"
+
+	query := "UPDATE email_outbox SET next_attempt_at = NOW() + $2 * INTERVAL '1 second', last_error = $3 WHERE id = $1"
+
+	if _, err := d.Pool.Exec(ctx, query, id, delay.Seconds(), lastErr); err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	return nil
+}
+
+// DeadLetterEmail stops delivery of message which can't be sent, bodies are cleared like for sent message
+func (d *DB) DeadLetterEmail(ctx context.Context, id int64, lastErr string) error {
+	const f = "postgres.DeadLetterEmail"
+
+	query := "UPDATE email_outbox SET status = 'dead', last_error = $2, text_body = '', html_body = '' WHERE id = $1"
+
+	if _, err := d.Pool.Exec(ctx, query, id, lastErr); err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	return nil
+}
+
+// PurgeEmails deletes sent and dead messages created before given time and returns their number
+func (d *DB) PurgeEmails(ctx context.Context, before time.Time) (int64, error) {
+	const f = "postgres.PurgeEmails"
+
+	query := "DELETE FROM email_outbox WHERE status IN ('sent', 'dead') AND created_at < $1"
+
+	tag, err := d.Pool.Exec(ctx, query, before)
+	if err != nil {
+		return 0, fmt.Errorf("%s:%w", f, err)
+	}
+
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,83 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/kuromii5/sync-auth/internal/mail"
+	"github.com/kuromii5/sync-auth/internal/models"
+	"github.com/pashagolub/pgxmock"
+)
+
+func (s *PostgresTestSuite) TestEnqueueEmail() {
+	s.mockPool.ExpectExec("INSERT INTO email_outbox").
+		WithArgs("test@example.com", "Subject", "text", "<p>html</p>").
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+
+	err := s.db.EnqueueEmail(context.Background(), mail.Message{
+		To:      "test@example.com",
+		Subject: "Subject",
+		Text:    "text",
+		HTML:    "<p>html</p>",
+	})
+	s.NoError(err)
+}
+
+func (s *PostgresTestSuite) TestClaimEmails() {
+	s.mockPool.ExpectQuery("UPDATE email_outbox SET attempts = attempts \\+ 1(.+)FOR UPDATE SKIP LOCKED").
+		WithArgs(10, float64(60)).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "recipient", "subject", "text_body", "html_body", "attempts"}).
+			AddRow(int64(1), "test@example.com", "Subject", "text", "", 2))
+
+	got, err := s.db.ClaimEmails(context.Background(), 10, time.Minute)
+	s.NoError(err)
+	s.Equal([]models.OutboxEmail{{ID: 1, To: "test@example.com", Subject: "Subject", Text: "text", Attempts: 2}}, got)
+}
+
+func (s *PostgresTestSuite) TestClaimEmails_Error() {
+	s.mockPool.ExpectQuery("UPDATE email_outbox").
+		WithArgs(10, float64(60)).
+		WillReturnError(errors.New("connection lost"))
+
+	_, err := s.db.ClaimEmails(context.Background(), 10, time.Minute)
+	s.Error(err)
+}
+
+func (s *PostgresTestSuite) TestMarkEmailSent() {
+	s.mockPool.ExpectExec("UPDATE email_outbox SET status = 'sent'(.+)text_body = '', html_body = ''").
+		WithArgs(int64(1)).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+
+	err := s.db.MarkEmailSent(context.Background(), 1)
+	s.NoError(err)
+}
+
+func (s *PostgresTestSuite) TestRetryEmail() {
+	s.mockPool.ExpectExec("UPDATE email_outbox SET next_attempt_at").
+		WithArgs(int64(1), float64(30), "421 try again later").
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+
+	err := s.db.RetryEmail(context.Background(), 1, 30*time.Second, "421 try again later")
+	s.NoError(err)
+}
+
+func (s *PostgresTestSuite) TestDeadLetterEmail() {
+	s.mockPool.ExpectExec("UPDATE email_outbox SET status = 'dead', last_error = \\$2, text_body = '', html_body = ''").
+		WithArgs(int64(1), "550 mailbox unavailable").
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+
+	err := s.db.DeadLetterEmail(context.Background(), 1, "550 mailbox unavailable")
+	s.NoError(err)
+}
+
+func (s *PostgresTestSuite) TestPurgeEmails() {
+	before := time.Now().Add(-time.Hour)
+	s.mockPool.ExpectExec("DELETE FROM email_outbox WHERE status IN \\('sent', 'dead'\\)").
+		WithArgs(before).
+		WillReturnResult(pgxmock.NewResult("DELETE", 3))
+
+	purged, err := s.db.PurgeEmails(context.Background(), before)
+	s.NoError(err)
+	s.Equal(int64(3), purged)
+}
@@ -0,0 +1,129 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/kuromii5/sync-auth/internal/models"
+)
+
+var (
+	ErrPasskeyExists   = errors.New("passkey is already registered")
+	ErrPasskeyNotFound = errors.New("passkey not found")
+	ErrStaleSignCount  = errors.New("sign count is not greater than the stored one")
+)
+
+const passkeyColumns = "id, user_id, name, public_key, sign_count, aaguid, transports, created_at, last_used_at"
+
+func (d *DB) SavePasskey(ctx context.Context, passkey models.Passkey) error {
+	const f = "postgres.SavePasskey"
+
+	query := `INSERT INTO passkeys (id, user_id, name, public_key, sign_count, aaguid, transports)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+	_, err := d.Pool.Exec(ctx, query, passkey.ID, passkey.UserID, passkey.Name, passkey.PublicKey,
+		int64(passkey.SignCount), passkey.AAGUID, passkey.Transports)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return fmt.Errorf("%s:%w", f, ErrPasskeyExists)
+		}
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	return nil
+}
+
+func (d *DB) Passkeys(ctx context.Context, userID int32) ([]models.Passkey, error) {
+	const f = "postgres.Passkeys"
+
+	query := "SELECT " + passkeyColumns + " FROM passkeys WHERE user_id = $1 ORDER BY created_at"
+
+	rows, err := d.Pool.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", f, err)
+	}
+	defer rows.Close()
+
+	var passkeys []models.Passkey
+	for rows.Next() {
+		passkey, err := scanPasskey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%w", f, err)
+		}
+		passkeys = append(passkeys, passkey)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s:%w", f, err)
+	}
+
+	return passkeys, nil
+}
+
+func (d *DB) PasskeyByID(ctx context.Context, id []byte) (models.Passkey, error) {
+	const f = "postgres.PasskeyByID"
+
+	query := "SELECT " + passkeyColumns + " FROM passkeys WHERE id = $1"
+
+	passkey, err := scanPasskey(d.Pool.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.Passkey{}, fmt.Errorf("%s:%w", f, ErrPasskeyNotFound)
+		}
+
+		return models.Passkey{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	return passkey, nil
+}
+
+// UpdatePasskeySignCount saves counter of successful login. Counter must grow unless
+// the authenticator doesn't support it, concurrent logins with the same counter fail
+func (d *DB) UpdatePasskeySignCount(ctx context.Context, id []byte, signCount uint32) error {
+	const f = "postgres.UpdatePasskeySignCount"
+
+	query := `UPDATE passkeys SET sign_count = $2, last_used_at = NOW()
+		WHERE id = $1 AND (sign_count < $2 OR (sign_count = 0 AND $2 = 0))`
+
+	res, err := d.Pool.Exec(ctx, query, id, int64(signCount))
+	if err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%s:%w", f, ErrStaleSignCount)
+	}
+
+	return nil
+}
+
+func (d *DB) DeletePasskey(ctx context.Context, userID int32, id []byte) error {
+	const f = "postgres.DeletePasskey"
+
+	res, err := d.Pool.Exec(ctx, "DELETE FROM passkeys WHERE user_id = $1 AND id = $2", userID, id)
+	if err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%s:%w", f, ErrPasskeyNotFound)
+	}
+
+	return nil
+}
+
+func scanPasskey(row pgx.Row) (models.Passkey, error) {
+	var passkey models.Passkey
+	var signCount int64
+
+	err := row.Scan(&passkey.ID, &passkey.UserID, &passkey.Name, &passkey.PublicKey, &signCount,
+		&passkey.AAGUID, &passkey.Transports, &passkey.CreatedAt, &passkey.LastUsedAt)
+	if err != nil {
+		return models.Passkey{}, err
+	}
+	passkey.SignCount = uint32(signCount)
+
+	return passkey, nil
+}
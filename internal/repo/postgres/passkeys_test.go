@@ -0,0 +1,76 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/kuromii5/sync-auth/internal/models"
+	"github.com/pashagolub/pgxmock"
+)
+
+var testPasskey = models.Passkey{
+	ID:         []byte("credential"),
+	UserID:     1,
+	Name:       "laptop",
+	PublicKey:  []byte("cose key"),
+	SignCount:  3,
+	AAGUID:     []byte("0123456789abcdef"),
+	Transports: []string{"internal"},
+}
+
+var passkeyRows = []string{"id", "user_id", "name", "public_key", "sign_count", "aaguid", "transports", "created_at", "last_used_at"}
+
+func (s *PostgresTestSuite) TestSavePasskey_Exists() {
+	s.mockPool.ExpectExec("INSERT INTO passkeys").
+		WithArgs(testPasskey.ID, int32(1), "laptop", testPasskey.PublicKey, int64(3), testPasskey.AAGUID, testPasskey.Transports).
+		WillReturnError(&pgconn.PgError{Code: "23505"})
+
+	err := s.db.SavePasskey(context.Background(), testPasskey)
+	s.True(errors.Is(err, ErrPasskeyExists), "ErrPasskeyExists was expected")
+}
+
+func (s *PostgresTestSuite) TestPasskeyByID() {
+	createdAt, _ := time.Parse("2006-01-02", "2023-10-12")
+	s.mockPool.ExpectQuery("SELECT (.+) FROM passkeys WHERE id = ").
+		WithArgs(testPasskey.ID).
+		WillReturnRows(pgxmock.NewRows(passkeyRows).
+			AddRow(testPasskey.ID, int32(1), "laptop", testPasskey.PublicKey, int64(3), testPasskey.AAGUID, testPasskey.Transports, createdAt, createdAt))
+
+	got, err := s.db.PasskeyByID(context.Background(), testPasskey.ID)
+	s.NoError(err)
+
+	want := testPasskey
+	want.CreatedAt, want.LastUsedAt = createdAt, createdAt
+	s.Equal(want, got)
+}
+
+func (s *PostgresTestSuite) TestPasskeyByID_NotFound() {
+	s.mockPool.ExpectQuery("SELECT (.+) FROM passkeys").
+		WithArgs(testPasskey.ID).
+		WillReturnError(pgx.ErrNoRows)
+
+	_, err := s.db.PasskeyByID(context.Background(), testPasskey.ID)
+	s.True(errors.Is(err, ErrPasskeyNotFound), "ErrPasskeyNotFound was expected")
+}
+
+func (s *PostgresTestSuite) TestUpdatePasskeySignCount_Stale() {
+	s.mockPool.ExpectExec(regexp.QuoteMeta("UPDATE passkeys SET sign_count = $2, last_used_at = NOW()")).
+		WithArgs(testPasskey.ID, int64(3)).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 0))
+
+	err := s.db.UpdatePasskeySignCount(context.Background(), testPasskey.ID, 3)
+	s.True(errors.Is(err, ErrStaleSignCount), "ErrStaleSignCount was expected")
+}
+
+func (s *PostgresTestSuite) TestDeletePasskey_NotFound() {
+	s.mockPool.ExpectExec(regexp.QuoteMeta("DELETE FROM passkeys WHERE user_id = $1 AND id = $2")).
+		WithArgs(int32(1), testPasskey.ID).
+		WillReturnResult(pgxmock.NewResult("DELETE", 0))
+
+	err := s.db.DeletePasskey(context.Background(), 1, testPasskey.ID)
+	s.True(errors.Is(err, ErrPasskeyNotFound), "ErrPasskeyNotFound was expected")
+}
@@ -15,8 +15,10 @@ import (
 )
 
 var (
-	ErrUserExists   = errors.New("user already exists")
-	ErrUserNotFound = errors.New("user not found")
+	ErrUserExists       = errors.New("user already exists")
+	ErrUserNotFound     = errors.New("user not found")
+	ErrIdentityExists   = errors.New("identity is already linked")
+	ErrIdentityNotFound = errors.New("identity not found")
 )
 
 func PGConnectionStr(config config.PostgresConfig) string {
@@ -33,7 +35,9 @@ func PGConnectionStr(config config.PostgresConfig) string {
 
 type PoolDB interface {
 	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
 	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+	Begin(ctx context.Context) (pgx.Tx, error)
 }
 
 type DB struct {
@@ -59,13 +63,13 @@ func NewDB(config config.PostgresConfig) *DB {
 	return &DB{Pool: pool}
 }
 
-func (d *DB) SaveUser(ctx context.Context, email string, passwordHash []byte) (int32, error) {
+func (d *DB) SaveUser(ctx context.Context, email string, passwordHash []byte, locale string) (int32, error) {
 	const f = "postgres.SaveUser"
 
-	query := "INSERT INTO users (email, pass_hash) VALUES ($1, $2) RETURNING id"
+	query := "INSERT INTO users (email, pass_hash, locale) VALUES ($1, $2, $3) RETURNING id"
 
 	var userID int32
-	err := d.Pool.QueryRow(ctx, query, email, passwordHash).Scan(&userID)
+	err := d.Pool.QueryRow(ctx, query, email, passwordHash, locale).Scan(&userID)
 	if err != nil {
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
@@ -81,11 +85,11 @@ func (d *DB) SaveUser(ctx context.Context, email string, passwordHash []byte) (i
 func (d *DB) UserByEmail(ctx context.Context, email string) (models.User, error) {
 	const f = "postgres.UserByEmail"
 
-	query := "SELECT id, email, pass_hash, created_at, updated_at, email_verified FROM users WHERE email = $1"
+	query := "SELECT id, email, pass_hash, created_at, updated_at, email_verified, roles, locale FROM users WHERE email = $1"
 
 	var user models.User
 	err := d.Pool.QueryRow(ctx, query, email).
-		Scan(&user.ID, &user.Email, &user.PasswordHash, &user.CreatedAt, &user.UpdatedAt, &user.EmailVerified)
+		Scan(&user.ID, &user.Email, &user.PasswordHash, &user.CreatedAt, &user.UpdatedAt, &user.EmailVerified, &user.Roles, &user.Locale)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return models.User{}, fmt.Errorf("%s:%w", f, ErrUserNotFound)
@@ -100,11 +104,11 @@ func (d *DB) UserByEmail(ctx context.Context, email string) (models.User, error)
 func (d *DB) UserByID(ctx context.Context, userID int32) (models.User, error) {
 	const f = "postgres.UserByID"
 
-	query := "SELECT id, email, pass_hash, created_at, updated_at, email_verified FROM users WHERE id = $1"
+	query := "SELECT id, email, pass_hash, created_at, updated_at, email_verified, roles, locale FROM users WHERE id = $1"
 
 	var user models.User
 	err := d.Pool.QueryRow(ctx, query, userID).
-		Scan(&user.ID, &user.Email, &user.PasswordHash, &user.CreatedAt, &user.UpdatedAt, &user.EmailVerified)
+		Scan(&user.ID, &user.Email, &user.PasswordHash, &user.CreatedAt, &user.UpdatedAt, &user.EmailVerified, &user.Roles, &user.Locale)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return models.User{}, fmt.Errorf("%s:%w", f, ErrUserNotFound)
@@ -133,3 +137,42 @@ func (d *DB) VerifyUser(ctx context.Context, userID int32) error {
 
 	return nil
 }
+
+func (d *DB) UpdatePassword(ctx context.Context, userID int32, passwordHash []byte) error {
+	const f = "postgres.UpdatePassword"
+
+	query := "UPDATE users SET pass_hash = $2, updated_at = NOW() WHERE id = $1"
+
+	res, err := d.Pool.Exec(ctx, query, userID, passwordHash)
+	if err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%s:%w", f, ErrUserNotFound)
+	}
+
+	return nil
+}
+
+// UpdateEmail sets new confirmed email of the user
+func (d *DB) UpdateEmail(ctx context.Context, userID int32, email string) error {
+	const f = "postgres.UpdateEmail"
+
+	query := "UPDATE users SET email = $2, email_verified = TRUE, updated_at = NOW() WHERE id = $1"
+
+	res, err := d.Pool.Exec(ctx, query, userID, email)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return fmt.Errorf("%s:%w", f, ErrUserExists)
+		}
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%s:%w", f, ErrUserNotFound)
+	}
+
+	return nil
+}
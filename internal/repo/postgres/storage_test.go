@@ -40,20 +40,20 @@ func (s *PostgresTestSuite) TearDownTest() {
 
 func (s *PostgresTestSuite) TestSaveUser_Success() {
 	s.mockPool.ExpectQuery("INSERT INTO users").
-		WithArgs("test@example.com", []byte("hashed_password")).
+		WithArgs("test@example.com", []byte("hashed_password"), "en").
 		WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(int32(1)))
 
-	got, err := s.db.SaveUser(context.Background(), "test@example.com", []byte("hashed_password"))
+	got, err := s.db.SaveUser(context.Background(), "test@example.com", []byte("hashed_password"), "en")
 	s.NoError(err)
 	s.Equal(int32(1), got)
 }
 
 func (s *PostgresTestSuite) TestSaveUser_UserExists() {
 	s.mockPool.ExpectQuery("INSERT INTO users").
-		WithArgs("test@example.com", []byte("hashed_password")).
+		WithArgs("test@example.com", []byte("hashed_password"), "en").
 		WillReturnError(&pgconn.PgError{Code: "23505"})
 
-	_, err := s.db.SaveUser(context.Background(), "test@example.com", []byte("hashed_password"))
+	_, err := s.db.SaveUser(context.Background(), "test@example.com", []byte("hashed_password"), "en")
 	s.Error(err)
 	s.True(errors.Is(err, ErrUserExists), "ErrUserExists was expected")
 }
@@ -61,10 +61,10 @@ func (s *PostgresTestSuite) TestSaveUser_UserExists() {
 func (s *PostgresTestSuite) TestUserByEmail_Success() {
 	createdAt, _ := time.Parse("2006-01-02", "2023-10-12")
 	updatedAt, _ := time.Parse("2006-01-02", "2023-10-12")
-	s.mockPool.ExpectQuery(regexp.QuoteMeta("SELECT id, email, pass_hash, created_at, updated_at, email_verified FROM users WHERE email = $1")).
+	s.mockPool.ExpectQuery(regexp.QuoteMeta("SELECT id, email, pass_hash, created_at, updated_at, email_verified, roles, locale FROM users WHERE email = $1")).
 		WithArgs("test@example.com").
-		WillReturnRows(pgxmock.NewRows([]string{"id", "email", "pass_hash", "created_at", "updated_at", "email_verified"}).
-			AddRow(int32(1), "test@example.com", []byte("hashed_password"), createdAt, updatedAt, false))
+		WillReturnRows(pgxmock.NewRows([]string{"id", "email", "pass_hash", "created_at", "updated_at", "email_verified", "roles", "locale"}).
+			AddRow(int32(1), "test@example.com", []byte("hashed_password"), createdAt, updatedAt, false, []string{"user"}, "en"))
 
 	got, err := s.db.UserByEmail(context.Background(), "test@example.com")
 	s.NoError(err)
@@ -76,12 +76,14 @@ func (s *PostgresTestSuite) TestUserByEmail_Success() {
 		CreatedAt:     createdAt,
 		UpdatedAt:     updatedAt,
 		EmailVerified: false,
+		Roles:         []string{"user"},
+		Locale:        "en",
 	}
 	s.Equal(expectedUser, got)
 }
 
 func (s *PostgresTestSuite) TestUserByEmail_NotFound() {
-	s.mockPool.ExpectQuery(regexp.QuoteMeta("SELECT id, email, pass_hash, created_at, updated_at, email_verified FROM users WHERE email = $1")).
+	s.mockPool.ExpectQuery(regexp.QuoteMeta("SELECT id, email, pass_hash, created_at, updated_at, email_verified, roles, locale FROM users WHERE email = $1")).
 		WithArgs("test1@example.com").
 		WillReturnError(pgx.ErrNoRows)
 
@@ -93,10 +95,10 @@ func (s *PostgresTestSuite) TestUserByEmail_NotFound() {
 func (s *PostgresTestSuite) TestUserByID_Success() {
 	createdAt, _ := time.Parse("2006-01-02", "2023-10-12")
 	updatedAt, _ := time.Parse("2006-01-02", "2023-10-12")
-	s.mockPool.ExpectQuery(regexp.QuoteMeta("SELECT id, email, pass_hash, created_at, updated_at, email_verified FROM users WHERE id = $1")).
+	s.mockPool.ExpectQuery(regexp.QuoteMeta("SELECT id, email, pass_hash, created_at, updated_at, email_verified, roles, locale FROM users WHERE id = $1")).
 		WithArgs(int32(1)).
-		WillReturnRows(pgxmock.NewRows([]string{"id", "email", "pass_hash", "created_at", "updated_at", "email_verified"}).
-			AddRow(int32(1), "test@example.com", []byte("hashed_password"), createdAt, updatedAt, false))
+		WillReturnRows(pgxmock.NewRows([]string{"id", "email", "pass_hash", "created_at", "updated_at", "email_verified", "roles", "locale"}).
+			AddRow(int32(1), "test@example.com", []byte("hashed_password"), createdAt, updatedAt, false, []string{"user"}, "en"))
 
 	got, err := s.db.UserByID(context.Background(), int32(1))
 	s.NoError(err)
@@ -108,12 +110,14 @@ func (s *PostgresTestSuite) TestUserByID_Success() {
 		CreatedAt:     createdAt,
 		UpdatedAt:     updatedAt,
 		EmailVerified: false,
+		Roles:         []string{"user"},
+		Locale:        "en",
 	}
 	s.Equal(expectedUser, got)
 }
 
 func (s *PostgresTestSuite) TestUserByID_NotFound() {
-	s.mockPool.ExpectQuery(regexp.QuoteMeta("SELECT id, email, pass_hash, created_at, updated_at, email_verified FROM users WHERE id = $1")).
+	s.mockPool.ExpectQuery(regexp.QuoteMeta("SELECT id, email, pass_hash, created_at, updated_at, email_verified, roles, locale FROM users WHERE id = $1")).
 		WithArgs(int32(1)).
 		WillReturnError(pgx.ErrNoRows)
 
@@ -150,6 +154,42 @@ func (s *PostgresTestSuite) TestVerifyUser_Error() {
 	s.Error(err)
 }
 
+func (s *PostgresTestSuite) TestUpdatePassword_Success() {
+	s.mockPool.ExpectExec(regexp.QuoteMeta("UPDATE users SET pass_hash = $2, updated_at = NOW() WHERE id = $1")).
+		WithArgs(int32(1), []byte("new_hash")).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+
+	err := s.db.UpdatePassword(context.Background(), int32(1), []byte("new_hash"))
+	s.NoError(err)
+}
+
+func (s *PostgresTestSuite) TestUpdatePassword_NotFound() {
+	s.mockPool.ExpectExec(regexp.QuoteMeta("UPDATE users SET pass_hash = $2, updated_at = NOW() WHERE id = $1")).
+		WithArgs(int32(999), []byte("new_hash")).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 0))
+
+	err := s.db.UpdatePassword(context.Background(), int32(999), []byte("new_hash"))
+	s.True(errors.Is(err, ErrUserNotFound))
+}
+
+func (s *PostgresTestSuite) TestUpdateEmail_Success() {
+	s.mockPool.ExpectExec(regexp.QuoteMeta("UPDATE users SET email = $2, email_verified = TRUE, updated_at = NOW() WHERE id = $1")).
+		WithArgs(int32(1), "new@example.com").
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+
+	err := s.db.UpdateEmail(context.Background(), int32(1), "new@example.com")
+	s.NoError(err)
+}
+
+func (s *PostgresTestSuite) TestUpdateEmail_Taken() {
+	s.mockPool.ExpectExec(regexp.QuoteMeta("UPDATE users SET email = $2, email_verified = TRUE, updated_at = NOW() WHERE id = $1")).
+		WithArgs(int32(1), "taken@example.com").
+		WillReturnError(&pgconn.PgError{Code: "23505"})
+
+	err := s.db.UpdateEmail(context.Background(), int32(1), "taken@example.com")
+	s.True(errors.Is(err, ErrUserExists))
+}
+
 // RUN TESTS
 
 func TestPostgresTestSuite(t *testing.T) {
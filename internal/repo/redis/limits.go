@@ -0,0 +1,117 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kuromii5/sync-auth/internal/models"
+	"github.com/redis/go-redis/v9"
+)
+
+const codeSendWindow = 24 * time.Hour
+
+// codeSendScript checks resend cooldown and daily limit of codes and counts the send.
+// Returns {status, ttl in milliseconds, number of sends in the window}, status is
+// 0 if send is allowed, 1 on cooldown and 2 if the limit is reached
+//
+// KEYS[1] - cooldown key, KEYS[2] - sends counter key
+// ARGV[1] - cooldown in milliseconds, ARGV[2] - daily limit, ARGV[3] - window in milliseconds
+var codeSendScript = redis.NewScript(`
+local cooldown = redis.call("PTTL", KEYS[1])
+if cooldown > 0 then
+	return {1, cooldown, 0}
+end
+
+local sends = tonumber(redis.call("GET", KEYS[2]) or "0")
+if sends >= tonumber(ARGV[2]) then
+	return {2, redis.call("PTTL", KEYS[2]), sends}
+end
+
+sends = redis.call("INCR", KEYS[2])
+if sends == 1 then
+	redis.call("PEXPIRE", KEYS[2], ARGV[3])
+end
+redis.call("SET", KEYS[1], 1, "PX", ARGV[1])
+
+return {0, tonumber(ARGV[1]), sends}
+`)
+
+// AllowCodeSend counts sending of a code to the user. Every flow has own cooldown and limit.
+// It returns ErrCodeCooldown or ErrCodeDailyLimit if the code can't be sent now, RetryAfter tells when it can
+func (s *Storage) AllowCodeSend(ctx context.Context, userID int32, flow string, cooldown time.Duration, dailyLimit int) (models.CodeSendLimit, error) {
+	const f = "redis.AllowCodeSend"
+
+	keys := []string{
+		fmt.Sprintf("%d:%s_cooldown", userID, flow),
+		fmt.Sprintf("%d:%s_sends", userID, flow),
+	}
+	res, err := codeSendScript.Run(ctx, s.client, keys, cooldown.Milliseconds(), dailyLimit, codeSendWindow.Milliseconds()).Int64Slice()
+	if err != nil {
+		return models.CodeSendLimit{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	limit := models.CodeSendLimit{
+		RetryAfter: time.Duration(res[1]) * time.Millisecond,
+		SendsLeft:  max(dailyLimit-int(res[2]), 0),
+	}
+	switch res[0] {
+	case 1:
+		return limit, fmt.Errorf("%s:%w", f, ErrCodeCooldown)
+	case 2:
+		return limit, fmt.Errorf("%s:%w", f, ErrCodeDailyLimit)
+	}
+
+	return limit, nil
+}
+
+// FailCodeAttempt counts wrong verification code and returns number of failed attempts
+func (s *Storage) FailCodeAttempt(ctx context.Context, userID int32, expires time.Duration) (int, error) {
+	const f = "redis.FailCodeAttempt"
+
+	failed, err := s.incrAttempts(ctx, fmt.Sprintf("%d:code_attempts", userID), expires)
+	if err != nil {
+		return 0, fmt.Errorf("%s:%w", f, err)
+	}
+
+	return failed, nil
+}
+
+// FailPendingEmailAttempt counts wrong email change code and returns number of failed attempts
+func (s *Storage) FailPendingEmailAttempt(ctx context.Context, userID int32, expires time.Duration) (int, error) {
+	const f = "redis.FailPendingEmailAttempt"
+
+	failed, err := s.incrAttempts(ctx, fmt.Sprintf("%d:pending_email_attempts", userID), expires)
+	if err != nil {
+		return 0, fmt.Errorf("%s:%w", f, err)
+	}
+
+	return failed, nil
+}
+
+// FailPasswordlessAttempt counts wrong login code and returns number of failed attempts
+func (s *Storage) FailPasswordlessAttempt(ctx context.Context, userID int32, expires time.Duration) (int, error) {
+	const f = "redis.FailPasswordlessAttempt"
+
+	failed, err := s.incrAttempts(ctx, fmt.Sprintf("%d:passwordless_attempts", userID), expires)
+	if err != nil {
+		return 0, fmt.Errorf("%s:%w", f, err)
+	}
+
+	return failed, nil
+}
+
+func (s *Storage) incrAttempts(ctx context.Context, key string, expires time.Duration) (int, error) {
+	var incr *redis.IntCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, key)
+		pipe.Expire(ctx, key, expires)
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int(incr.Val()), nil
+}
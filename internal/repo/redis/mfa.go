@@ -0,0 +1,115 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/kuromii5/sync-auth/internal/models"
+	"github.com/redis/go-redis/v9"
+)
+
+// SetMFALogin saves login waiting for the second factor under hash of challenge token
+func (s *Storage) SetMFALogin(ctx context.Context, tokenHash string, login models.MFALogin, expires time.Duration) error {
+	const f = "redis.SetMFALogin"
+
+	key := fmt.Sprintf("mfa:%s", tokenHash)
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, "user_id", login.UserID, "fingerprint", login.Fingerprint)
+		pipe.Expire(ctx, key, expires)
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) MFALogin(ctx context.Context, tokenHash string) (models.MFALogin, error) {
+	const f = "redis.MFALogin"
+
+	fields, err := s.client.HGetAll(ctx, fmt.Sprintf("mfa:%s", tokenHash)).Result()
+	if err != nil {
+		return models.MFALogin{}, fmt.Errorf("%s:%w", f, err)
+	}
+	if len(fields) == 0 {
+		return models.MFALogin{}, fmt.Errorf("%s:%w", f, ErrMFANotFound)
+	}
+
+	userID, err := strconv.ParseInt(fields["user_id"], 10, 32)
+	if err != nil {
+		return models.MFALogin{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	return models.MFALogin{
+		UserID:      int32(userID),
+		Fingerprint: fields["fingerprint"],
+	}, nil
+}
+
+// DeleteMFALogin removes the challenge, it returns ErrMFANotFound if there
+// was none, so concurrent requests can't complete the same login twice
+func (s *Storage) DeleteMFALogin(ctx context.Context, tokenHash string) error {
+	const f = "redis.DeleteMFALogin"
+
+	var deleted *redis.IntCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		deleted = pipe.Del(ctx, fmt.Sprintf("mfa:%s", tokenHash))
+		pipe.Del(ctx, fmt.Sprintf("mfa:%s:attempts", tokenHash))
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+	if deleted.Val() == 0 {
+		return fmt.Errorf("%s:%w", f, ErrMFANotFound)
+	}
+
+	return nil
+}
+
+// FailMFAAttempt counts wrong second factor codes entered for the challenge
+func (s *Storage) FailMFAAttempt(ctx context.Context, tokenHash string, expires time.Duration) (int, error) {
+	const f = "redis.FailMFAAttempt"
+
+	failed, err := s.incrAttempts(ctx, fmt.Sprintf("mfa:%s:attempts", tokenHash), expires)
+	if err != nil {
+		return 0, fmt.Errorf("%s:%w", f, err)
+	}
+
+	return failed, nil
+}
+
+// Saves the step if it is greater than the last accepted one (RFC 6238 5.2).
+// Returns 1 if the step is saved and 0 if it is not newer
+//
+// KEYS[1] - last accepted step key
+// ARGV[1] - step, ARGV[2] - ttl in milliseconds
+var totpStepScript = redis.NewScript(`
+local last = tonumber(redis.call("GET", KEYS[1]))
+if last and tonumber(ARGV[1]) <= last then
+	return 0
+end
+
+redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+
+return 1
+`)
+
+// UseTOTPStep remembers the last time step whose code was accepted. It returns false
+// if the step is not newer than the last one, so neither the same code nor an older
+// code from the skew window can be used after it
+func (s *Storage) UseTOTPStep(ctx context.Context, userID int32, step uint64, expires time.Duration) (bool, error) {
+	const f = "redis.UseTOTPStep"
+
+	res, err := totpStepScript.Run(ctx, s.client, []string{fmt.Sprintf("%d:totp_step", userID)}, step, expires.Milliseconds()).Int64()
+	if err != nil {
+		return false, fmt.Errorf("%s:%w", f, err)
+	}
+
+	return res == 1, nil
+}
@@ -9,14 +9,75 @@ import (
 	"strings"
 	"time"
 
+	"github.com/kuromii5/sync-auth/internal/models"
 	"github.com/redis/go-redis/v9"
 )
 
 var (
-	ErrTokenNotFound = errors.New("refresh token for user not found")
-	ErrCodeNotFound  = errors.New("verification code not found")
+	ErrTokenNotFound    = errors.New("refresh token for user not found")
+	ErrTokenReused      = errors.New("refresh token was already rotated")
+	ErrSessionNotFound  = errors.New("session not found")
+	ErrCodeNotFound     = errors.New("verification code not found")
+	ErrStateNotFound    = errors.New("oauth state not found")
+	ErrResetNotFound    = errors.New("password reset token not found")
+	ErrPendingNotFound  = errors.New("pending email not found")
+	ErrLoginNotFound    = errors.New("passwordless login not found")
+	ErrMFANotFound      = errors.New("mfa challenge not found")
+	ErrCeremonyNotFound = errors.New("webauthn ceremony not found")
+	ErrCodeCooldown     = errors.New("code was sent recently")
+	ErrCodeDailyLimit   = errors.New("daily limit of codes is reached")
 )
 
+// rotateScript atomically replaces refresh token with a new one of the same family.
+// If the old token was already rotated, the whole family is revoked.
+//
+// KEYS[1] - old token key, KEYS[2] - rotated marker of old token, KEYS[3] - new token key
+// ARGV[1] - ttl in milliseconds, ARGV[2:] - field/value pairs to set on new token
+var rotateScript = redis.NewScript(`
+local old, marker, new = KEYS[1], KEYS[2], KEYS[3]
+local ttl = tonumber(ARGV[1])
+
+local data = redis.call('HGETALL', old)
+if #data == 0 then
+	local reused = redis.call('HMGET', marker, 'user_id', 'family')
+	if not reused[1] then
+		return {'not_found'}
+	end
+
+	local set = reused[1] .. ':tokens'
+	for _, key in ipairs(redis.call('SMEMBERS', set)) do
+		if redis.call('HGET', key, 'family') == reused[2] then
+			redis.call('DEL', key)
+			redis.call('SREM', set, key)
+		end
+	end
+
+	return {'reused', reused[1], reused[2]}
+end
+
+local fields = {}
+for i = 1, #data, 2 do
+	fields[data[i]] = data[i + 1]
+end
+
+redis.call('DEL', old)
+redis.call('HSET', marker, 'user_id', fields['user_id'], 'family', fields['family'])
+redis.call('PEXPIRE', marker, ttl)
+
+redis.call('HSET', new, unpack(data))
+for i = 2, #ARGV, 2 do
+	redis.call('HSET', new, ARGV[i], ARGV[i + 1])
+end
+redis.call('PEXPIRE', new, ttl)
+
+local set = fields['user_id'] .. ':tokens'
+redis.call('SREM', set, old)
+redis.call('SADD', set, new)
+redis.call('PEXPIRE', set, ttl)
+
+return {'rotated', fields['user_id'], fields['family']}
+`)
+
 type Storage struct {
 	client *redis.Client
 }
@@ -35,16 +96,28 @@ func NewStorage(addr string) *Storage {
 	return &Storage{client: rdb}
 }
 
-func (s *Storage) SetRefreshToken(ctx context.Context, userID int32, fingerprint, token string, expires time.Duration) error {
+func (s *Storage) SetRefreshToken(ctx context.Context, token string, session models.Session, expires time.Duration) error {
 	const f = "redis.SetRefreshToken"
 
-	key := fmt.Sprintf("%s:%s", token, fingerprint)
-	if err := s.client.Set(ctx, key, userID, expires).Err(); err != nil {
+	key := fmt.Sprintf("%s:%s", token, session.Fingerprint)
+	fields := []any{
+		"user_id", session.UserID,
+		"family", session.ID,
+		"fingerprint", session.Fingerprint,
+		"ip", session.IP,
+		"user_agent", session.UserAgent,
+		"created_at", session.CreatedAt.Unix(),
+		"last_used_at", session.LastUsedAt.Unix(),
+	}
+	if err := s.client.HSet(ctx, key, fields...).Err(); err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+	if err := s.client.Expire(ctx, key, expires).Err(); err != nil {
 		return fmt.Errorf("%s:%w", f, err)
 	}
 
 	// Add the token to the user's set of tokens
-	userTokensKey := fmt.Sprintf("%d:tokens", userID)
+	userTokensKey := fmt.Sprintf("%d:tokens", session.UserID)
 	if err := s.client.SAdd(ctx, userTokensKey, key).Err(); err != nil {
 		return fmt.Errorf("%s: failed to add token to user set: %w", f, err)
 	}
@@ -61,7 +134,7 @@ func (s *Storage) UserID(ctx context.Context, token, fingerprint string) (string
 	const f = "redis.UserID"
 
 	key := fmt.Sprintf("%s:%s", token, fingerprint)
-	userIdStr, err := s.client.Get(ctx, key).Result()
+	userIdStr, err := s.client.HGet(ctx, key, "user_id").Result()
 	if err != nil {
 		if err == redis.Nil {
 			return "", fmt.Errorf("%s:%w", f, ErrTokenNotFound)
@@ -73,6 +146,153 @@ func (s *Storage) UserID(ctx context.Context, token, fingerprint string) (string
 	return userIdStr, nil
 }
 
+// RefreshTokenSession returns session of the refresh token and time when the token expires
+func (s *Storage) RefreshTokenSession(ctx context.Context, token, fingerprint string) (models.Session, time.Time, error) {
+	const f = "redis.RefreshTokenSession"
+
+	key := fmt.Sprintf("%s:%s", token, fingerprint)
+	pipe := s.client.Pipeline()
+	fieldsCmd := pipe.HGetAll(ctx, key)
+	ttlCmd := pipe.PTTL(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return models.Session{}, time.Time{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	fields := fieldsCmd.Val()
+	if len(fields) == 0 {
+		return models.Session{}, time.Time{}, fmt.Errorf("%s:%w", f, ErrTokenNotFound)
+	}
+
+	userID, err := strconv.ParseInt(fields["user_id"], 10, 32)
+	if err != nil {
+		return models.Session{}, time.Time{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	var expiresAt time.Time
+	if ttl := ttlCmd.Val(); ttl > 0 {
+		expiresAt = time.Now().Add(ttl)
+	}
+
+	return sessionFromHash(int32(userID), fields), expiresAt, nil
+}
+
+// RotateRefreshToken replaces old refresh token with new one and returns
+// owner and family of the token. Last usage info of the session is updated.
+// Returns ErrTokenReused if old token was already rotated, in this case
+// every token of the family is deleted
+func (s *Storage) RotateRefreshToken(ctx context.Context, oldToken, newToken, fingerprint string, usage models.Session, expires time.Duration) (string, string, error) {
+	const f = "redis.RotateRefreshToken"
+
+	oldKey := fmt.Sprintf("%s:%s", oldToken, fingerprint)
+	keys := []string{
+		oldKey,
+		fmt.Sprintf("rotated:%s", oldKey),
+		fmt.Sprintf("%s:%s", newToken, fingerprint),
+	}
+	args := []any{
+		expires.Milliseconds(),
+		"ip", usage.IP,
+		"user_agent", usage.UserAgent,
+		"last_used_at", usage.LastUsedAt.Unix(),
+	}
+
+	res, err := rotateScript.Run(ctx, s.client, keys, args...).StringSlice()
+	if err != nil {
+		return "", "", fmt.Errorf("%s:%w", f, err)
+	}
+
+	switch res[0] {
+	case "rotated":
+		return res[1], res[2], nil
+	case "reused":
+		return res[1], res[2], fmt.Errorf("%s:%w", f, ErrTokenReused)
+	default:
+		return "", "", fmt.Errorf("%s:%w", f, ErrTokenNotFound)
+	}
+}
+
+// Sessions returns active sessions of the user. Expired tokens are removed from user's set
+func (s *Storage) Sessions(ctx context.Context, userID int32) ([]models.Session, error) {
+	const f = "redis.Sessions"
+
+	userTokensKey := fmt.Sprintf("%d:tokens", userID)
+	tokens, err := s.client.SMembers(ctx, userTokensKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to get tokens for user: %w", f, err)
+	}
+
+	sessions := make([]models.Session, 0, len(tokens))
+	for _, token := range tokens {
+		fields, err := s.client.HGetAll(ctx, token).Result()
+		if err != nil {
+			return nil, fmt.Errorf("%s: failed to get token: %w", f, err)
+		}
+
+		// token expired
+		if len(fields) == 0 {
+			if err := s.client.SRem(ctx, userTokensKey, token).Err(); err != nil {
+				return nil, fmt.Errorf("%s: failed to remove token from user set: %w", f, err)
+			}
+			continue
+		}
+
+		sessions = append(sessions, sessionFromHash(userID, fields))
+	}
+
+	return sessions, nil
+}
+
+// DeleteSession removes refresh token of the session
+func (s *Storage) DeleteSession(ctx context.Context, userID int32, sessionID string) error {
+	const f = "redis.DeleteSession"
+
+	userTokensKey := fmt.Sprintf("%d:tokens", userID)
+	tokens, err := s.client.SMembers(ctx, userTokensKey).Result()
+	if err != nil {
+		return fmt.Errorf("%s: failed to get tokens for user: %w", f, err)
+	}
+
+	deleted := false
+	for _, token := range tokens {
+		family, err := s.client.HGet(ctx, token, "family").Result()
+		if err != nil && err != redis.Nil {
+			return fmt.Errorf("%s: failed to get token: %w", f, err)
+		}
+		if family != sessionID {
+			continue
+		}
+
+		if err := s.client.Del(ctx, token).Err(); err != nil {
+			return fmt.Errorf("%s: failed to delete token: %w", f, err)
+		}
+		if err := s.client.SRem(ctx, userTokensKey, token).Err(); err != nil {
+			return fmt.Errorf("%s: failed to remove token from user set: %w", f, err)
+		}
+		deleted = true
+	}
+
+	if !deleted {
+		return fmt.Errorf("%s:%w", f, ErrSessionNotFound)
+	}
+
+	return nil
+}
+
+func sessionFromHash(userID int32, fields map[string]string) models.Session {
+	createdAt, _ := strconv.ParseInt(fields["created_at"], 10, 64)
+	lastUsedAt, _ := strconv.ParseInt(fields["last_used_at"], 10, 64)
+
+	return models.Session{
+		ID:          fields["family"],
+		UserID:      userID,
+		Fingerprint: fields["fingerprint"],
+		IP:          fields["ip"],
+		UserAgent:   fields["user_agent"],
+		CreatedAt:   time.Unix(createdAt, 0),
+		LastUsedAt:  time.Unix(lastUsedAt, 0),
+	}
+}
+
 func (s *Storage) DeleteRefreshToken(ctx context.Context, userID int32, fingerprint string) error {
 	const f = "redis.DeleteRefreshToken"
 
@@ -100,12 +320,123 @@ func (s *Storage) DeleteRefreshToken(ctx context.Context, userID int32, fingerpr
 	return nil
 }
 
+// DeleteAllRefreshTokens removes every refresh token of the user
+func (s *Storage) DeleteAllRefreshTokens(ctx context.Context, userID int32) error {
+	const f = "redis.DeleteAllRefreshTokens"
+
+	userTokensKey := fmt.Sprintf("%d:tokens", userID)
+	tokens, err := s.client.SMembers(ctx, userTokensKey).Result()
+	if err != nil {
+		return fmt.Errorf("%s: failed to get tokens for user: %w", f, err)
+	}
+
+	keys := append(tokens, userTokensKey)
+	if err := s.client.Del(ctx, keys...).Err(); err != nil {
+		return fmt.Errorf("%s: failed to delete tokens: %w", f, err)
+	}
+
+	return nil
+}
+
+// DenyAccessToken puts access token id to the denylist until the token expires
+func (s *Storage) DenyAccessToken(ctx context.Context, jti string, expires time.Duration) error {
+	const f = "redis.DenyAccessToken"
+
+	key := fmt.Sprintf("denylist:%s", jti)
+	if err := s.client.Set(ctx, key, 1, expires).Err(); err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) IsAccessTokenDenied(ctx context.Context, jti string) (bool, error) {
+	const f = "redis.IsAccessTokenDenied"
+
+	key := fmt.Sprintf("denylist:%s", jti)
+	n, err := s.client.Exists(ctx, key).Result()
+	if err != nil {
+		return false, fmt.Errorf("%s:%w", f, err)
+	}
+
+	return n > 0, nil
+}
+
+// DenySession rejects access tokens of the deleted session until they expire
+func (s *Storage) DenySession(ctx context.Context, sessionID string, expires time.Duration) error {
+	const f = "redis.DenySession"
+
+	key := fmt.Sprintf("denylist:session:%s", sessionID)
+	if err := s.client.Set(ctx, key, 1, expires).Err(); err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) IsSessionDenied(ctx context.Context, sessionID string) (bool, error) {
+	const f = "redis.IsSessionDenied"
+
+	key := fmt.Sprintf("denylist:session:%s", sessionID)
+	n, err := s.client.Exists(ctx, key).Result()
+	if err != nil {
+		return false, fmt.Errorf("%s:%w", f, err)
+	}
+
+	return n > 0, nil
+}
+
+// watermarks below are in seconds, in nanoseconds it would be 1970
+const secondsWatermarkLimit = 1e12
+
+// SetRevokedBefore stores per-user watermark in nanoseconds: access tokens
+// issued before it are rejected. Watermark lives as long as access token
+func (s *Storage) SetRevokedBefore(ctx context.Context, userID int32, before time.Time, expires time.Duration) error {
+	const f = "redis.SetRevokedBefore"
+
+	key := fmt.Sprintf("%d:revoked_before", userID)
+	if err := s.client.Set(ctx, key, before.UnixNano(), expires).Err(); err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	return nil
+}
+
+// RevokedBefore returns watermark for user, zero time if tokens were not revoked
+func (s *Storage) RevokedBefore(ctx context.Context, userID int32) (time.Time, error) {
+	const f = "redis.RevokedBefore"
+
+	key := fmt.Sprintf("%d:revoked_before", userID)
+	unix, err := s.client.Get(ctx, key).Int64()
+	if err != nil {
+		if err == redis.Nil {
+			return time.Time{}, nil
+		}
+
+		return time.Time{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	// watermarks stored in seconds by previous versions
+	if unix < secondsWatermarkLimit {
+		return time.Unix(unix, 0), nil
+	}
+
+	return time.Unix(0, unix), nil
+}
+
 func (s *Storage) SetCode(ctx context.Context, code, userID int32, expires time.Duration) error {
 	const f = "redis.SetCode"
 
 	key := fmt.Sprintf("%d:code", userID)
 
-	if err := s.client.Set(ctx, key, code, expires).Err(); err != nil {
+	// new code gets new attempts
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, key, code, expires)
+		pipe.Del(ctx, fmt.Sprintf("%d:code_attempts", userID))
+
+		return nil
+	})
+	if err != nil {
 		return fmt.Errorf("%s: failed to set code: %w", f, err)
 	}
 
@@ -139,9 +470,214 @@ func (s *Storage) DeleteCode(ctx context.Context, userID int32) error {
 
 	key := fmt.Sprintf("%d:code", userID)
 
-	if err := s.client.Del(ctx, key).Err(); err != nil {
+	if err := s.client.Del(ctx, key, fmt.Sprintf("%d:code_attempts", userID)).Err(); err != nil {
 		return fmt.Errorf("%s: failed to delete code: %w", f, err)
 	}
 
 	return nil
 }
+
+func (s *Storage) SetOAuthState(ctx context.Context, state string, request models.OAuthState, expires time.Duration) error {
+	const f = "redis.SetOAuthState"
+
+	key := fmt.Sprintf("oauth_state:%s", state)
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key,
+			"provider", request.Provider,
+			"nonce", request.Nonce,
+			"code_verifier", request.CodeVerifier,
+			"purpose", string(request.Purpose),
+			"user_id", request.UserID,
+		)
+		pipe.Expire(ctx, key, expires)
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	return nil
+}
+
+// ConsumeOAuthState returns state and deletes it, so every state is used only once
+func (s *Storage) ConsumeOAuthState(ctx context.Context, state string) (models.OAuthState, error) {
+	const f = "redis.ConsumeOAuthState"
+
+	key := fmt.Sprintf("oauth_state:%s", state)
+	var fieldsCmd *redis.MapStringStringCmd
+	var delCmd *redis.IntCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		fieldsCmd = pipe.HGetAll(ctx, key)
+		delCmd = pipe.Del(ctx, key)
+
+		return nil
+	})
+	if err != nil {
+		return models.OAuthState{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	fields := fieldsCmd.Val()
+	if len(fields) == 0 || delCmd.Val() == 0 {
+		return models.OAuthState{}, fmt.Errorf("%s:%w", f, ErrStateNotFound)
+	}
+
+	userID, _ := strconv.ParseInt(fields["user_id"], 10, 32)
+
+	return models.OAuthState{
+		Provider:     fields["provider"],
+		Nonce:        fields["nonce"],
+		CodeVerifier: fields["code_verifier"],
+		Purpose:      models.OAuthPurpose(fields["purpose"]),
+		UserID:       int32(userID),
+	}, nil
+}
+
+func (s *Storage) SetPasswordResetToken(ctx context.Context, tokenHash string, userID int32, expires time.Duration) error {
+	const f = "redis.SetPasswordResetToken"
+
+	key := fmt.Sprintf("password_reset:%s", tokenHash)
+	if err := s.client.Set(ctx, key, userID, expires).Err(); err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	return nil
+}
+
+// ConsumePasswordResetToken returns owner of the token and deletes it
+func (s *Storage) ConsumePasswordResetToken(ctx context.Context, tokenHash string) (int32, error) {
+	const f = "redis.ConsumePasswordResetToken"
+
+	key := fmt.Sprintf("password_reset:%s", tokenHash)
+	userIDStr, err := s.client.GetDel(ctx, key).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return 0, fmt.Errorf("%s:%w", f, ErrResetNotFound)
+		}
+
+		return 0, fmt.Errorf("%s:%w", f, err)
+	}
+
+	userID, err := strconv.ParseInt(userIDStr, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%s:%w", f, err)
+	}
+
+	return int32(userID), nil
+}
+
+func (s *Storage) SetPendingEmail(ctx context.Context, userID int32, pending models.PendingEmail, expires time.Duration) error {
+	const f = "redis.SetPendingEmail"
+
+	key := fmt.Sprintf("%d:pending_email", userID)
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, "email", pending.Email, "code", pending.Code)
+		pipe.Expire(ctx, key, expires)
+		pipe.Del(ctx, fmt.Sprintf("%d:pending_email_attempts", userID))
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) PendingEmail(ctx context.Context, userID int32) (models.PendingEmail, error) {
+	const f = "redis.PendingEmail"
+
+	key := fmt.Sprintf("%d:pending_email", userID)
+	fields, err := s.client.HGetAll(ctx, key).Result()
+	if err != nil {
+		return models.PendingEmail{}, fmt.Errorf("%s:%w", f, err)
+	}
+	if len(fields) == 0 {
+		return models.PendingEmail{}, fmt.Errorf("%s:%w", f, ErrPendingNotFound)
+	}
+
+	code, err := strconv.ParseInt(fields["code"], 10, 32)
+	if err != nil {
+		return models.PendingEmail{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	return models.PendingEmail{
+		Email: fields["email"],
+		Code:  int32(code),
+	}, nil
+}
+
+func (s *Storage) DeletePendingEmail(ctx context.Context, userID int32) error {
+	const f = "redis.DeletePendingEmail"
+
+	key := fmt.Sprintf("%d:pending_email", userID)
+	if err := s.client.Del(ctx, key, fmt.Sprintf("%d:pending_email_attempts", userID)).Err(); err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) SetPasswordlessLogin(ctx context.Context, userID int32, login models.PasswordlessLogin, expires time.Duration) error {
+	const f = "redis.SetPasswordlessLogin"
+
+	key := fmt.Sprintf("%d:passwordless", userID)
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, "code", login.Code, "nonce", login.Nonce)
+		pipe.Expire(ctx, key, expires)
+		pipe.Del(ctx, fmt.Sprintf("%d:passwordless_attempts", userID))
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) PasswordlessLogin(ctx context.Context, userID int32) (models.PasswordlessLogin, error) {
+	const f = "redis.PasswordlessLogin"
+
+	key := fmt.Sprintf("%d:passwordless", userID)
+	fields, err := s.client.HGetAll(ctx, key).Result()
+	if err != nil {
+		return models.PasswordlessLogin{}, fmt.Errorf("%s:%w", f, err)
+	}
+	if len(fields) == 0 {
+		return models.PasswordlessLogin{}, fmt.Errorf("%s:%w", f, ErrLoginNotFound)
+	}
+
+	code, err := strconv.ParseInt(fields["code"], 10, 32)
+	if err != nil {
+		return models.PasswordlessLogin{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	return models.PasswordlessLogin{
+		Code:  int32(code),
+		Nonce: fields["nonce"],
+	}, nil
+}
+
+// DeletePasswordlessLogin removes pending login, it returns ErrLoginNotFound if there
+// was none, so concurrent requests can't complete the same login twice
+func (s *Storage) DeletePasswordlessLogin(ctx context.Context, userID int32) error {
+	const f = "redis.DeletePasswordlessLogin"
+
+	key := fmt.Sprintf("%d:passwordless", userID)
+	var deleted *redis.IntCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		deleted = pipe.Del(ctx, key)
+		pipe.Del(ctx, fmt.Sprintf("%d:passwordless_attempts", userID))
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+	if deleted.Val() == 0 {
+		return fmt.Errorf("%s:%w", f, ErrLoginNotFound)
+	}
+
+	return nil
+}
@@ -0,0 +1,420 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/kuromii5/sync-auth/internal/models"
+	"github.com/stretchr/testify/suite"
+)
+
+// SUITE
+
+type RedisTestSuite struct {
+	suite.Suite
+	server  *miniredis.Miniredis
+	storage *Storage
+}
+
+func (s *RedisTestSuite) SetupTest() {
+	s.server = miniredis.RunT(s.T())
+	s.storage = NewStorage(s.server.Addr())
+}
+func (s *RedisTestSuite) TearDownTest() {
+	s.storage.client.Close()
+	s.server.Close()
+}
+
+func session(userID int32, fingerprint, family string) models.Session {
+	return models.Session{
+		ID:          family,
+		UserID:      userID,
+		Fingerprint: fingerprint,
+		IP:          "127.0.0.1",
+		UserAgent:   "test",
+		CreatedAt:   time.Now(),
+		LastUsedAt:  time.Now(),
+	}
+}
+
+// ACTUAL TESTS
+
+func (s *RedisTestSuite) TestRotateRefreshToken_Success() {
+	ctx := context.Background()
+	s.Require().NoError(s.storage.SetRefreshToken(ctx, "old", session(1, "fp", "family"), time.Hour))
+
+	userID, family, err := s.storage.RotateRefreshToken(ctx, "old", "new", "fp", models.Session{LastUsedAt: time.Now()}, time.Hour)
+	s.NoError(err)
+	s.Equal("1", userID)
+	s.Equal("family", family)
+
+	_, err = s.storage.UserID(ctx, "old", "fp")
+	s.True(errors.Is(err, ErrTokenNotFound), "ErrTokenNotFound was expected")
+
+	got, err := s.storage.UserID(ctx, "new", "fp")
+	s.NoError(err)
+	s.Equal("1", got)
+
+	members, err := s.server.SMembers("1:tokens")
+	s.NoError(err)
+	s.Equal([]string{"new:fp"}, members)
+}
+
+func (s *RedisTestSuite) TestRotateRefreshToken_ReuseRevokesFamily() {
+	ctx := context.Background()
+	s.Require().NoError(s.storage.SetRefreshToken(ctx, "old", session(1, "fp", "family"), time.Hour))
+	s.Require().NoError(s.storage.SetRefreshToken(ctx, "other", session(1, "other_fp", "other_family"), time.Hour))
+
+	_, _, err := s.storage.RotateRefreshToken(ctx, "old", "new", "fp", models.Session{LastUsedAt: time.Now()}, time.Hour)
+	s.Require().NoError(err)
+
+	userID, family, err := s.storage.RotateRefreshToken(ctx, "old", "newer", "fp", models.Session{LastUsedAt: time.Now()}, time.Hour)
+	s.True(errors.Is(err, ErrTokenReused), "ErrTokenReused was expected")
+	s.Equal("1", userID)
+	s.Equal("family", family)
+
+	// token issued by rotation is revoked
+	_, err = s.storage.UserID(ctx, "new", "fp")
+	s.True(errors.Is(err, ErrTokenNotFound), "ErrTokenNotFound was expected")
+
+	// other sessions are untouched
+	_, err = s.storage.UserID(ctx, "other", "other_fp")
+	s.NoError(err)
+}
+
+func (s *RedisTestSuite) TestRotateRefreshToken_NotFound() {
+	_, _, err := s.storage.RotateRefreshToken(context.Background(), "unknown", "new", "fp", models.Session{LastUsedAt: time.Now()}, time.Hour)
+	s.True(errors.Is(err, ErrTokenNotFound), "ErrTokenNotFound was expected")
+}
+
+func (s *RedisTestSuite) TestRefreshTokenSession() {
+	ctx := context.Background()
+	s.Require().NoError(s.storage.SetRefreshToken(ctx, "token", session(3, "fp", "family"), time.Hour))
+
+	found, expiresAt, err := s.storage.RefreshTokenSession(ctx, "token", "fp")
+	s.Require().NoError(err)
+	s.Equal(int32(3), found.UserID)
+	s.Equal("family", found.ID)
+	s.WithinDuration(time.Now().Add(time.Hour), expiresAt, time.Second)
+
+	_, _, err = s.storage.RefreshTokenSession(ctx, "token", "other")
+	s.ErrorIs(err, ErrTokenNotFound)
+}
+
+func (s *RedisTestSuite) TestConsumeOAuthState() {
+	ctx := context.Background()
+	request := models.OAuthState{Provider: "github", Nonce: "nonce", CodeVerifier: "verifier", Purpose: models.OAuthLink, UserID: 7}
+	s.Require().NoError(s.storage.SetOAuthState(ctx, "state", request, time.Minute))
+	s.Require().NoError(s.storage.SetOAuthState(ctx, "expired", request, time.Minute))
+
+	found, err := s.storage.ConsumeOAuthState(ctx, "state")
+	s.NoError(err)
+	s.Equal(request, found)
+
+	// state can be used only once
+	_, err = s.storage.ConsumeOAuthState(ctx, "state")
+	s.ErrorIs(err, ErrStateNotFound)
+
+	s.server.FastForward(2 * time.Minute)
+	_, err = s.storage.ConsumeOAuthState(ctx, "expired")
+	s.ErrorIs(err, ErrStateNotFound)
+}
+
+func (s *RedisTestSuite) TestConsumePasswordResetToken() {
+	ctx := context.Background()
+	s.Require().NoError(s.storage.SetPasswordResetToken(ctx, "hash", 5, time.Minute))
+
+	userID, err := s.storage.ConsumePasswordResetToken(ctx, "hash")
+	s.NoError(err)
+	s.Equal(int32(5), userID)
+
+	// token is single-use
+	_, err = s.storage.ConsumePasswordResetToken(ctx, "hash")
+	s.ErrorIs(err, ErrResetNotFound)
+
+	s.Require().NoError(s.storage.SetPasswordResetToken(ctx, "expired", 5, time.Minute))
+	s.server.FastForward(2 * time.Minute)
+	_, err = s.storage.ConsumePasswordResetToken(ctx, "expired")
+	s.ErrorIs(err, ErrResetNotFound)
+}
+
+func (s *RedisTestSuite) TestPendingEmail() {
+	ctx := context.Background()
+	pending := models.PendingEmail{Email: "new@example.com", Code: 123456}
+	s.Require().NoError(s.storage.SetPendingEmail(ctx, 1, pending, time.Minute))
+
+	got, err := s.storage.PendingEmail(ctx, 1)
+	s.NoError(err)
+	s.Equal(pending, got)
+
+	s.Require().NoError(s.storage.DeletePendingEmail(ctx, 1))
+	_, err = s.storage.PendingEmail(ctx, 1)
+	s.ErrorIs(err, ErrPendingNotFound)
+
+	s.Require().NoError(s.storage.SetPendingEmail(ctx, 2, pending, time.Minute))
+	s.server.FastForward(2 * time.Minute)
+	_, err = s.storage.PendingEmail(ctx, 2)
+	s.ErrorIs(err, ErrPendingNotFound)
+}
+
+func (s *RedisTestSuite) TestAllowCodeSend() {
+	ctx := context.Background()
+
+	limit, err := s.storage.AllowCodeSend(ctx, 1, "verification", time.Minute, 2)
+	s.NoError(err)
+	s.Equal(models.CodeSendLimit{RetryAfter: time.Minute, SendsLeft: 1}, limit)
+
+	// cooldown
+	s.server.FastForward(20 * time.Second)
+	limit, err = s.storage.AllowCodeSend(ctx, 1, "verification", time.Minute, 2)
+	s.ErrorIs(err, ErrCodeCooldown)
+	s.Equal(40*time.Second, limit.RetryAfter)
+
+	s.server.FastForward(time.Minute)
+	limit, err = s.storage.AllowCodeSend(ctx, 1, "verification", time.Minute, 2)
+	s.NoError(err)
+	s.Equal(0, limit.SendsLeft)
+
+	// daily limit
+	s.server.FastForward(time.Minute)
+	limit, err = s.storage.AllowCodeSend(ctx, 1, "verification", time.Minute, 2)
+	s.ErrorIs(err, ErrCodeDailyLimit)
+	s.Greater(limit.RetryAfter, 23*time.Hour)
+
+	// other flows have own limits
+	_, err = s.storage.AllowCodeSend(ctx, 1, "passwordless", time.Minute, 2)
+	s.NoError(err)
+
+	s.server.FastForward(24 * time.Hour)
+	_, err = s.storage.AllowCodeSend(ctx, 1, "verification", time.Minute, 2)
+	s.NoError(err)
+}
+
+func (s *RedisTestSuite) TestFailCodeAttempt() {
+	ctx := context.Background()
+	s.Require().NoError(s.storage.SetCode(ctx, 123456, 1, time.Minute))
+
+	for want := 1; want <= 3; want++ {
+		failed, err := s.storage.FailCodeAttempt(ctx, 1, time.Minute)
+		s.NoError(err)
+		s.Equal(want, failed)
+	}
+
+	// new code resets attempts
+	s.Require().NoError(s.storage.SetCode(ctx, 654321, 1, time.Minute))
+	failed, err := s.storage.FailCodeAttempt(ctx, 1, time.Minute)
+	s.NoError(err)
+	s.Equal(1, failed)
+
+	s.Require().NoError(s.storage.DeleteCode(ctx, 1))
+	s.False(s.server.Exists("1:code_attempts"))
+}
+
+func (s *RedisTestSuite) TestPasswordlessLogin() {
+	ctx := context.Background()
+	login := models.PasswordlessLogin{Code: 123456, Nonce: "nonce"}
+	s.Require().NoError(s.storage.SetPasswordlessLogin(ctx, 1, login, time.Minute))
+
+	got, err := s.storage.PasswordlessLogin(ctx, 1)
+	s.NoError(err)
+	s.Equal(login, got)
+
+	failed, err := s.storage.FailPasswordlessAttempt(ctx, 1, time.Minute)
+	s.NoError(err)
+	s.Equal(1, failed)
+
+	// login can be completed once
+	s.NoError(s.storage.DeletePasswordlessLogin(ctx, 1))
+	s.ErrorIs(s.storage.DeletePasswordlessLogin(ctx, 1), ErrLoginNotFound)
+	_, err = s.storage.PasswordlessLogin(ctx, 1)
+	s.ErrorIs(err, ErrLoginNotFound)
+	s.False(s.server.Exists("1:passwordless_attempts"))
+}
+
+func (s *RedisTestSuite) TestMFALogin() {
+	ctx := context.Background()
+	login := models.MFALogin{UserID: 1, Fingerprint: "fingerprint"}
+	s.Require().NoError(s.storage.SetMFALogin(ctx, "hash", login, time.Minute))
+
+	got, err := s.storage.MFALogin(ctx, "hash")
+	s.NoError(err)
+	s.Equal(login, got)
+
+	failed, err := s.storage.FailMFAAttempt(ctx, "hash", time.Minute)
+	s.NoError(err)
+	s.Equal(1, failed)
+
+	// challenge can be completed once
+	s.NoError(s.storage.DeleteMFALogin(ctx, "hash"))
+	s.ErrorIs(s.storage.DeleteMFALogin(ctx, "hash"), ErrMFANotFound)
+	_, err = s.storage.MFALogin(ctx, "hash")
+	s.ErrorIs(err, ErrMFANotFound)
+	s.False(s.server.Exists("mfa:hash:attempts"))
+}
+
+func (s *RedisTestSuite) TestUseTOTPStep() {
+	ctx := context.Background()
+
+	fresh, err := s.storage.UseTOTPStep(ctx, 1, 100, time.Minute)
+	s.NoError(err)
+	s.True(fresh)
+
+	fresh, err = s.storage.UseTOTPStep(ctx, 1, 100, time.Minute)
+	s.NoError(err)
+	s.False(fresh, "replayed step was accepted")
+
+	fresh, err = s.storage.UseTOTPStep(ctx, 1, 101, time.Minute)
+	s.NoError(err)
+	s.True(fresh)
+
+	fresh, err = s.storage.UseTOTPStep(ctx, 1, 100, time.Minute)
+	s.NoError(err)
+	s.False(fresh, "older step was accepted after newer one")
+
+	fresh, err = s.storage.UseTOTPStep(ctx, 2, 100, time.Minute)
+	s.NoError(err)
+	s.True(fresh, "steps of other users must not interfere")
+
+	// older steps are out of the skew window when the last one expires
+	s.server.FastForward(time.Minute)
+	fresh, err = s.storage.UseTOTPStep(ctx, 1, 99, time.Minute)
+	s.NoError(err)
+	s.True(fresh)
+}
+
+func (s *RedisTestSuite) TestWebAuthnCeremony() {
+	ctx := context.Background()
+	ceremony := models.WebAuthnCeremony{Purpose: "mfa", UserID: 1, MFATokenHash: "hash"}
+	s.Require().NoError(s.storage.SetWebAuthnCeremony(ctx, "challenge", ceremony, time.Minute))
+
+	got, err := s.storage.ConsumeWebAuthnCeremony(ctx, "challenge")
+	s.NoError(err)
+	ceremony.Challenge = "challenge"
+	s.Equal(ceremony, got)
+
+	// challenge can be used once
+	_, err = s.storage.ConsumeWebAuthnCeremony(ctx, "challenge")
+	s.ErrorIs(err, ErrCeremonyNotFound)
+}
+
+func (s *RedisTestSuite) TestDenyAccessToken() {
+	ctx := context.Background()
+	s.Require().NoError(s.storage.DenyAccessToken(ctx, "jti", time.Minute))
+
+	denied, err := s.storage.IsAccessTokenDenied(ctx, "jti")
+	s.NoError(err)
+	s.True(denied)
+
+	s.server.FastForward(2 * time.Minute)
+
+	denied, err = s.storage.IsAccessTokenDenied(ctx, "jti")
+	s.NoError(err)
+	s.False(denied)
+}
+
+func (s *RedisTestSuite) TestDenySession() {
+	ctx := context.Background()
+	s.Require().NoError(s.storage.DenySession(ctx, "sid", time.Minute))
+
+	denied, err := s.storage.IsSessionDenied(ctx, "sid")
+	s.NoError(err)
+	s.True(denied)
+
+	denied, err = s.storage.IsSessionDenied(ctx, "other")
+	s.NoError(err)
+	s.False(denied)
+
+	s.server.FastForward(2 * time.Minute)
+
+	denied, err = s.storage.IsSessionDenied(ctx, "sid")
+	s.NoError(err)
+	s.False(denied)
+}
+
+func (s *RedisTestSuite) TestRevokedBefore() {
+	ctx := context.Background()
+
+	got, err := s.storage.RevokedBefore(ctx, 1)
+	s.NoError(err)
+	s.True(got.IsZero())
+
+	now := time.Now()
+	s.Require().NoError(s.storage.SetRevokedBefore(ctx, 1, now, time.Minute))
+
+	got, err = s.storage.RevokedBefore(ctx, 1)
+	s.NoError(err)
+	s.True(now.Equal(got))
+
+	// watermark in seconds written by previous versions
+	s.Require().NoError(s.storage.client.Set(ctx, "2:revoked_before", now.Unix(), time.Minute).Err())
+	got, err = s.storage.RevokedBefore(ctx, 2)
+	s.NoError(err)
+	s.True(time.Unix(now.Unix(), 0).Equal(got))
+}
+
+func (s *RedisTestSuite) TestDeleteAllRefreshTokens() {
+	ctx := context.Background()
+	s.Require().NoError(s.storage.SetRefreshToken(ctx, "first", session(1, "fp1", "family1"), time.Hour))
+	s.Require().NoError(s.storage.SetRefreshToken(ctx, "second", session(1, "fp2", "family2"), time.Hour))
+
+	s.NoError(s.storage.DeleteAllRefreshTokens(ctx, 1))
+
+	_, err := s.storage.UserID(ctx, "first", "fp1")
+	s.True(errors.Is(err, ErrTokenNotFound), "ErrTokenNotFound was expected")
+	_, err = s.storage.UserID(ctx, "second", "fp2")
+	s.True(errors.Is(err, ErrTokenNotFound), "ErrTokenNotFound was expected")
+	s.False(s.server.Exists("1:tokens"))
+}
+
+func (s *RedisTestSuite) TestSessions() {
+	ctx := context.Background()
+	s.Require().NoError(s.storage.SetRefreshToken(ctx, "first", session(1, "fp1", "family1"), time.Hour))
+	s.Require().NoError(s.storage.SetRefreshToken(ctx, "second", session(1, "fp2", "family2"), time.Minute))
+
+	_, _, err := s.storage.RotateRefreshToken(ctx, "first", "rotated", "fp1", models.Session{IP: "10.0.0.1", UserAgent: "new agent", LastUsedAt: time.Now()}, time.Hour)
+	s.Require().NoError(err)
+
+	sessions, err := s.storage.Sessions(ctx, 1)
+	s.NoError(err)
+	s.Len(sessions, 2)
+
+	// expired token is skipped and removed from user set
+	s.server.FastForward(2 * time.Minute)
+
+	sessions, err = s.storage.Sessions(ctx, 1)
+	s.NoError(err)
+	s.Require().Len(sessions, 1)
+	s.Equal("family1", sessions[0].ID)
+	s.Equal("fp1", sessions[0].Fingerprint)
+	s.Equal("10.0.0.1", sessions[0].IP)
+	s.Equal("new agent", sessions[0].UserAgent)
+
+	members, err := s.server.SMembers("1:tokens")
+	s.NoError(err)
+	s.Equal([]string{"rotated:fp1"}, members)
+}
+
+func (s *RedisTestSuite) TestDeleteSession() {
+	ctx := context.Background()
+	s.Require().NoError(s.storage.SetRefreshToken(ctx, "first", session(1, "fp1", "family1"), time.Hour))
+	s.Require().NoError(s.storage.SetRefreshToken(ctx, "second", session(1, "fp2", "family2"), time.Hour))
+
+	s.NoError(s.storage.DeleteSession(ctx, 1, "family1"))
+
+	_, err := s.storage.UserID(ctx, "first", "fp1")
+	s.True(errors.Is(err, ErrTokenNotFound), "ErrTokenNotFound was expected")
+	_, err = s.storage.UserID(ctx, "second", "fp2")
+	s.NoError(err)
+
+	err = s.storage.DeleteSession(ctx, 1, "family1")
+	s.True(errors.Is(err, ErrSessionNotFound), "ErrSessionNotFound was expected")
+}
+
+// RUN TESTS
+
+func TestRedisTestSuite(t *testing.T) {
+	suite.Run(t, new(RedisTestSuite))
+}
@@ -0,0 +1,63 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/kuromii5/sync-auth/internal/models"
+	"github.com/redis/go-redis/v9"
+)
+
+// SetWebAuthnCeremony saves started ceremony under its challenge
+func (s *Storage) SetWebAuthnCeremony(ctx context.Context, challenge string, ceremony models.WebAuthnCeremony, expires time.Duration) error {
+	const f = "redis.SetWebAuthnCeremony"
+
+	key := fmt.Sprintf("webauthn:%s", challenge)
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, "challenge", challenge, "purpose", ceremony.Purpose, "user_id", ceremony.UserID, "mfa_token_hash", ceremony.MFATokenHash)
+		pipe.Expire(ctx, key, expires)
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	return nil
+}
+
+// ConsumeWebAuthnCeremony returns and deletes the ceremony, so every challenge is used once
+func (s *Storage) ConsumeWebAuthnCeremony(ctx context.Context, challenge string) (models.WebAuthnCeremony, error) {
+	const f = "redis.ConsumeWebAuthnCeremony"
+
+	key := fmt.Sprintf("webauthn:%s", challenge)
+	var get *redis.MapStringStringCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		get = pipe.HGetAll(ctx, key)
+		pipe.Del(ctx, key)
+
+		return nil
+	})
+	if err != nil {
+		return models.WebAuthnCeremony{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	fields := get.Val()
+	if len(fields) == 0 {
+		return models.WebAuthnCeremony{}, fmt.Errorf("%s:%w", f, ErrCeremonyNotFound)
+	}
+
+	userID, err := strconv.ParseInt(fields["user_id"], 10, 32)
+	if err != nil {
+		return models.WebAuthnCeremony{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	return models.WebAuthnCeremony{
+		Challenge:    fields["challenge"],
+		Purpose:      fields["purpose"],
+		UserID:       int32(userID),
+		MFATokenHash: fields["mfa_token_hash"],
+	}, nil
+}
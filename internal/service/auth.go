@@ -6,17 +6,16 @@ import (
 	"fmt"
 	"log/slog"
 
+	"github.com/kuromii5/sync-auth/internal/models"
 	"github.com/kuromii5/sync-auth/internal/repo/postgres"
 	"github.com/kuromii5/sync-auth/pkg/hasher"
 	le "github.com/kuromii5/sync-auth/pkg/logger/l_err"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/metadata"
 )
 
 func (a *Auth) SignUp(ctx context.Context, email, password string) (int32, error) {
 	const f = "auth.Register"
 
-	log := a.log.With(slog.String("func", f))
+	log := a.logger(ctx).With(slog.String("func", f))
 	log.Info("registering new user")
 
 	hash, err := hasher.HashPassword(password)
@@ -26,10 +25,10 @@ func (a *Auth) SignUp(ctx context.Context, email, password string) (int32, error
 		return 0, fmt.Errorf("%s:%w", f, err)
 	}
 
-	id, err := a.userSaver.SaveUser(ctx, email, hash)
+	id, err := a.userSaver.SaveUser(ctx, email, hash, requestLocale(ctx))
 	if err != nil {
 		if errors.Is(err, postgres.ErrUserExists) {
-			a.log.Warn("user already exists", le.Err(err))
+			a.logger(ctx).Warn("user already exists", le.Err(err))
 
 			return 0, fmt.Errorf("%s:%w", f, ErrUserExists)
 		}
@@ -43,67 +42,66 @@ func (a *Auth) SignUp(ctx context.Context, email, password string) (int32, error
 	return id, nil
 }
 
-func (a *Auth) Login(ctx context.Context, email, password, fingerprint string) error {
+// Login checks password of the user. Session is started right away or, if two-factor
+// authentication is enabled, after VerifyMFA with the returned challenge
+func (a *Auth) Login(ctx context.Context, email, password, fingerprint string) (models.MFAChallenge, error) {
 	const f = "auth.Login"
 
-	log := a.log.With(slog.String("func", f))
+	log := a.logger(ctx).With(slog.String("func", f))
 	log.Info("trying to log in user")
 
 	user, err := a.userProvider.UserByEmail(ctx, email)
 	if err != nil {
 		if errors.Is(err, postgres.ErrUserNotFound) {
-			a.log.Warn("user not found", le.Err(err))
+			a.logger(ctx).Warn("user not found", le.Err(err))
 
-			return fmt.Errorf("%s:%w", f, ErrInvalidCreds)
+			return models.MFAChallenge{}, fmt.Errorf("%s:%w", f, ErrInvalidCreds)
 		}
-		a.log.Error("failed to get user", le.Err(err))
+		a.logger(ctx).Error("failed to get user", le.Err(err))
 
-		return fmt.Errorf("%s:%w", f, err)
+		return models.MFAChallenge{}, fmt.Errorf("%s:%w", f, err)
 	}
 
 	if err := hasher.CheckPassword(password, user.PasswordHash); err != nil {
-		a.log.Warn("invalid credentials", le.Err(err))
+		a.logger(ctx).Warn("invalid credentials", le.Err(err))
 
-		return fmt.Errorf("%s:%w", f, ErrInvalidCreds)
+		return models.MFAChallenge{}, fmt.Errorf("%s:%w", f, ErrInvalidCreds)
 	}
 
-	accessToken, err := a.accessTokenManager.NewAccessToken(ctx, user.ID)
+	challenge, err := a.login(ctx, user, fingerprint)
 	if err != nil {
-		a.log.Error("failed to generate jwt access token", le.Err(err))
+		a.logger(ctx).Error("failed to log in", le.Err(err))
 
-		return fmt.Errorf("%s:%w", f, err)
+		return models.MFAChallenge{}, fmt.Errorf("%s:%w", f, err)
 	}
 
-	refreshToken, err := a.refreshTokenManager.NewRefreshToken(ctx, user.ID, fingerprint)
-	if err != nil {
-		a.log.Error("failed to generate refresh token", le.Err(err))
+	if challenge.Token != "" {
+		log.Info("second factor is required", slog.Int("user_id", int(user.ID)))
 
-		return fmt.Errorf("%s:%w", f, err)
+		return challenge, nil
 	}
 
-	md := metadata.New(map[string]string{})
-	md.Append("Set-Cookie", fmt.Sprintf("access_token=%s", accessToken))
-	md.Append("Set-Cookie", fmt.Sprintf("refresh_token=%s", refreshToken))
-	grpc.SendHeader(ctx, md)
-	log.Debug("Generated metadata", slog.Any("md", md))
-
 	log.Info("user logged in successfully")
 
-	return nil
+	return models.MFAChallenge{}, nil
 }
 
 func (a *Auth) Logout(ctx context.Context, accessToken, fingerprint string) error {
 	const f = "service.Logout"
 
-	log := a.log.With(slog.String("func", f))
+	log := a.logger(ctx).With(slog.String("func", f))
 	log.Info("logging out user")
 
-	userID, err := a.accessTokenManager.ValidateAccessToken(ctx, accessToken)
+	// browser forgets tokens even if the session is already gone
+	a.clearAuthCookies(ctx)
+
+	claims, err := a.accessTokenManager.ValidateAccessToken(ctx, accessToken)
 	if err != nil {
 		log.Warn("failed to validate access token", le.Err(err))
 
 		return fmt.Errorf("%s:%w", f, err)
 	}
+	userID := claims.UserID
 
 	if err = a.refreshTokenManager.Delete(ctx, userID, fingerprint); err != nil {
 		log.Error("internal error", le.Err(err))
@@ -111,6 +109,12 @@ func (a *Auth) Logout(ctx context.Context, accessToken, fingerprint string) erro
 		return fmt.Errorf("%s:%w", f, err)
 	}
 
+	if err = a.accessTokenManager.RevokeAccessToken(ctx, accessToken); err != nil {
+		log.Error("failed to revoke access token", le.Err(err))
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
 	log.Info("successfully logged out user", slog.Int("user_id", int(userID)))
 
 	return nil
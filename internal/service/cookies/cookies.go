@@ -0,0 +1,132 @@
+package cookies
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kuromii5/sync-auth/internal/models"
+)
+
+var (
+	ErrInvalidSameSite = errors.New("invalid SameSite, expected lax, strict or none")
+	ErrInsecureNone    = errors.New("SameSite=None cookies must be Secure")
+)
+
+type Config struct {
+	AccessName  string
+	RefreshName string
+	Domain      string
+	Path        string
+	Secure      bool
+	SameSite    string
+	// cookies expire together with the tokens
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+}
+
+// Policy builds Set-Cookie values with tokens and reads tokens from Cookie headers.
+// Cookies are always HttpOnly, so scripts on the page can't steal the tokens
+type Policy struct {
+	accessName  string
+	refreshName string
+	domain      string
+	path        string
+	secure      bool
+	sameSite    http.SameSite
+	accessTTL   time.Duration
+	refreshTTL  time.Duration
+}
+
+func NewPolicy(cfg Config) (*Policy, error) {
+	var sameSite http.SameSite
+	switch strings.ToLower(cfg.SameSite) {
+	case "lax":
+		sameSite = http.SameSiteLaxMode
+	case "strict":
+		sameSite = http.SameSiteStrictMode
+	case "none":
+		if !cfg.Secure {
+			return nil, ErrInsecureNone
+		}
+		sameSite = http.SameSiteNoneMode
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrInvalidSameSite, cfg.SameSite)
+	}
+
+	return &Policy{
+		accessName:  cfg.AccessName,
+		refreshName: cfg.RefreshName,
+		domain:      cfg.Domain,
+		path:        cfg.Path,
+		secure:      cfg.Secure,
+		sameSite:    sameSite,
+		accessTTL:   cfg.AccessTTL,
+		refreshTTL:  cfg.RefreshTTL,
+	}, nil
+}
+
+// AuthCookies returns Set-Cookie values with both tokens
+func (p *Policy) AuthCookies(tokens models.TokenPair) []string {
+	return []string{
+		p.cookie(p.accessName, tokens.AccessToken, p.maxAge(p.accessTTL)),
+		p.cookie(p.refreshName, tokens.RefreshToken, p.maxAge(p.refreshTTL)),
+	}
+}
+
+// ClearCookies returns Set-Cookie values which remove both tokens from the browser
+func (p *Policy) ClearCookies() []string {
+	return []string{
+		p.cookie(p.accessName, "", -1),
+		p.cookie(p.refreshName, "", -1),
+	}
+}
+
+// ScriptCookie returns Set-Cookie value of a session cookie which scripts
+// on the page can read, e.g. CSRF token
+func (p *Policy) ScriptCookie(name, value string) string {
+	cookie := p.base(name, value)
+	cookie.HttpOnly = false
+
+	return cookie.String()
+}
+
+// Tokens finds tokens in values of Cookie headers, missing tokens are empty
+func (p *Policy) Tokens(headers []string) (accessToken, refreshToken string) {
+	r := http.Request{Header: http.Header{"Cookie": headers}}
+
+	if cookie, err := r.Cookie(p.accessName); err == nil {
+		accessToken = cookie.Value
+	}
+	if cookie, err := r.Cookie(p.refreshName); err == nil {
+		refreshToken = cookie.Value
+	}
+
+	return accessToken, refreshToken
+}
+
+func (p *Policy) cookie(name, value string, maxAge int) string {
+	cookie := p.base(name, value)
+	cookie.MaxAge = maxAge
+
+	return cookie.String()
+}
+
+func (p *Policy) base(name, value string) http.Cookie {
+	return http.Cookie{
+		Name:     name,
+		Value:    value,
+		Domain:   p.domain,
+		Path:     p.path,
+		HttpOnly: true,
+		Secure:   p.secure,
+		SameSite: p.sameSite,
+	}
+}
+
+// maxAge is in whole seconds, rounded up
+func (p *Policy) maxAge(ttl time.Duration) int {
+	return int((ttl + time.Second - 1) / time.Second)
+}
@@ -0,0 +1,107 @@
+package cookies
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kuromii5/sync-auth/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testConfig() Config {
+	return Config{
+		AccessName:  "access_token",
+		RefreshName: "refresh_token",
+		Domain:      "example.com",
+		Path:        "/",
+		Secure:      true,
+		SameSite:    "lax",
+		AccessTTL:   15 * time.Minute,
+		RefreshTTL:  240 * time.Hour,
+	}
+}
+
+func TestNewPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		sameSite string
+		secure   bool
+		err      error
+	}{
+		{name: "lax", sameSite: "lax", secure: false},
+		{name: "strict", sameSite: "Strict", secure: true},
+		{name: "none", sameSite: "none", secure: true},
+		{name: "insecure none", sameSite: "none", secure: false, err: ErrInsecureNone},
+		{name: "unknown", sameSite: "default", secure: true, err: ErrInvalidSameSite},
+		{name: "empty", sameSite: "", secure: true, err: ErrInvalidSameSite},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.SameSite = tt.sameSite
+			cfg.Secure = tt.secure
+
+			_, err := NewPolicy(cfg)
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+}
+
+func TestAuthCookies(t *testing.T) {
+	p, err := NewPolicy(testConfig())
+	require.NoError(t, err)
+
+	cookies := p.AuthCookies(models.TokenPair{AccessToken: "access", RefreshToken: "refresh"})
+	assert.Equal(t, []string{
+		"access_token=access; Path=/; Domain=example.com; Max-Age=900; HttpOnly; Secure; SameSite=Lax",
+		"refresh_token=refresh; Path=/; Domain=example.com; Max-Age=864000; HttpOnly; Secure; SameSite=Lax",
+	}, cookies)
+}
+
+func TestClearCookies(t *testing.T) {
+	cfg := testConfig()
+	cfg.Domain = ""
+	cfg.SameSite = "strict"
+	p, err := NewPolicy(cfg)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"access_token=; Path=/; Max-Age=0; HttpOnly; Secure; SameSite=Strict",
+		"refresh_token=; Path=/; Max-Age=0; HttpOnly; Secure; SameSite=Strict",
+	}, p.ClearCookies())
+}
+
+func TestScriptCookie(t *testing.T) {
+	p, err := NewPolicy(testConfig())
+	require.NoError(t, err)
+
+	assert.Equal(t, "csrf_token=abc; Path=/; Domain=example.com; Secure; SameSite=Lax", p.ScriptCookie("csrf_token", "abc"))
+}
+
+func TestTokens(t *testing.T) {
+	p, err := NewPolicy(testConfig())
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		headers []string
+		access  string
+		refresh string
+	}{
+		{name: "both", headers: []string{"theme=dark; access_token=a.b.c; refresh_token=r1"}, access: "a.b.c", refresh: "r1"},
+		{name: "several headers", headers: []string{"access_token=a.b.c", "refresh_token=r1"}, access: "a.b.c", refresh: "r1"},
+		{name: "access only", headers: []string{"access_token=a.b.c"}, access: "a.b.c"},
+		{name: "none", headers: []string{"theme=dark"}},
+		{name: "empty", headers: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			access, refresh := p.Tokens(tt.headers)
+			assert.Equal(t, tt.access, access)
+			assert.Equal(t, tt.refresh, refresh)
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/kuromii5/sync-auth/internal/models"
+	"github.com/kuromii5/sync-auth/internal/repo/postgres"
+	"github.com/kuromii5/sync-auth/internal/repo/redis"
+	le "github.com/kuromii5/sync-auth/pkg/logger/l_err"
+)
+
+// RequestEmailChange sends confirmation code to the new address and notifies
+// the current one. Email is changed only after ConfirmEmailChange
+func (a *Auth) RequestEmailChange(ctx context.Context, accessToken, newEmail string) (models.VerifyEmailResp, error) {
+	const f = "service.RequestEmailChange"
+
+	log := a.logger(ctx).With(slog.String("func", f))
+	log.Info("requesting email change")
+
+	claims, err := a.authenticate(ctx, accessToken)
+	if err != nil {
+		return models.VerifyEmailResp{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	user, err := a.userProvider.UserByID(ctx, claims.UserID)
+	if err != nil {
+		log.Error("failed to get user", le.Err(err))
+
+		return models.VerifyEmailResp{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	if err := a.checkEmailAvailable(ctx, newEmail); err != nil {
+		log.Warn("new email is not available", le.Err(err))
+
+		return models.VerifyEmailResp{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	limit, err := a.allowCodeSend(ctx, user.ID, emailChangeCodeFlow)
+	if err != nil {
+		log.Warn("code send is limited", le.Err(err))
+
+		return models.VerifyEmailResp{ResendAfter: limit.RetryAfter, SendsLeft: limit.SendsLeft}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	code, err := verificationCode()
+	if err != nil {
+		log.Error("failed to generate code", le.Err(err))
+
+		return models.VerifyEmailResp{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	pending := models.PendingEmail{Email: newEmail, Code: code}
+	if err := a.pendingEmails.SetPendingEmail(ctx, user.ID, pending, a.VerificationManager.CodeTTL); err != nil {
+		log.Error("failed to save pending email", le.Err(err))
+
+		return models.VerifyEmailResp{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	if err := a.VerificationManager.SendEmailChangeCode(ctx, newEmail, locale(ctx, user), code); err != nil {
+		log.Error("failed to send code to new email", le.Err(err))
+
+		return models.VerifyEmailResp{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	// the change is not applied yet, so the owner can still react
+	if err := a.VerificationManager.SendEmailChangeNotice(ctx, user.Email, locale(ctx, user), newEmail); err != nil {
+		log.Error("failed to notify current email", le.Err(err))
+	}
+
+	log.Info("email change code was sent", slog.Int("user_id", int(user.ID)))
+
+	return models.VerifyEmailResp{
+		Status:      "code sent",
+		CodeTTL:     a.VerificationManager.CodeTTL,
+		ResendAfter: limit.RetryAfter,
+		SendsLeft:   limit.SendsLeft,
+	}, nil
+}
+
+// ConfirmEmailChange swaps email of the user to the pending one if the code is correct
+func (a *Auth) ConfirmEmailChange(ctx context.Context, accessToken string, code int32) (models.ConfirmCodeResp, error) {
+	const f = "service.ConfirmEmailChange"
+
+	log := a.logger(ctx).With(slog.String("func", f))
+	log.Info("confirming email change")
+
+	claims, err := a.authenticate(ctx, accessToken)
+	if err != nil {
+		return models.ConfirmCodeResp{}, fmt.Errorf("%s:%w", f, err)
+	}
+	userID := claims.UserID
+
+	pending, err := a.pendingEmails.PendingEmail(ctx, userID)
+	if err != nil {
+		if errors.Is(err, redis.ErrPendingNotFound) {
+			log.Warn("pending email expired", le.Err(err))
+
+			return models.ConfirmCodeResp{
+				Success: false,
+				Message: "Code expired",
+			}, nil
+		}
+		log.Error("failed to get pending email", le.Err(err))
+
+		return models.ConfirmCodeResp{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	if pending.Code != code {
+		log.Warn("user entered incorrect code")
+
+		failed, err := a.pendingEmails.FailPendingEmailAttempt(ctx, userID, a.VerificationManager.CodeTTL)
+		if err != nil {
+			log.Error("failed to count code attempt", le.Err(err))
+
+			return models.ConfirmCodeResp{}, fmt.Errorf("%s:%w", f, err)
+		}
+
+		attemptsLeft := a.VerificationManager.CodeLimits.MaxAttempts - failed
+		if attemptsLeft <= 0 {
+			if err := a.pendingEmails.DeletePendingEmail(ctx, userID); err != nil {
+				log.Error("failed to delete pending email", le.Err(err))
+
+				return models.ConfirmCodeResp{}, fmt.Errorf("%s:%w", f, err)
+			}
+
+			return models.ConfirmCodeResp{
+				Success: false,
+				Message: "Too many attempts, request a new code",
+			}, nil
+		}
+
+		return models.ConfirmCodeResp{
+			Success:      false,
+			Message:      "Incorrect code",
+			AttemptsLeft: attemptsLeft,
+		}, nil
+	}
+
+	// unique index on email guards against the race with sign up
+	if err := a.userSaver.UpdateEmail(ctx, userID, pending.Email); err != nil {
+		if errors.Is(err, postgres.ErrUserExists) {
+			log.Warn("email was taken before confirmation", le.Err(err))
+
+			return models.ConfirmCodeResp{}, fmt.Errorf("%s:%w", f, ErrUserExists)
+		}
+		log.Error("failed to update email", le.Err(err))
+
+		return models.ConfirmCodeResp{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	if err := a.pendingEmails.DeletePendingEmail(ctx, userID); err != nil {
+		log.Error("failed to delete pending email", le.Err(err))
+	}
+
+	log.Info("email was changed successfully", slog.Int("user_id", int(userID)))
+
+	return models.ConfirmCodeResp{
+		Success: true,
+		Message: "Email changed",
+	}, nil
+}
+
+func (a *Auth) checkEmailAvailable(ctx context.Context, email string) error {
+	_, err := a.userProvider.UserByEmail(ctx, email)
+	if err == nil {
+		return ErrUserExists
+	}
+	if errors.Is(err, postgres.ErrUserNotFound) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,26 @@
+package events
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/kuromii5/sync-auth/internal/models"
+)
+
+// LogEmitter writes security events to the service log
+type LogEmitter struct {
+	log *slog.Logger
+}
+
+func NewLogEmitter(log *slog.Logger) *LogEmitter {
+	return &LogEmitter{log: log}
+}
+
+func (e *LogEmitter) Emit(ctx context.Context, event models.SecurityEvent) {
+	e.log.LogAttrs(ctx, slog.LevelWarn, "security event",
+		slog.String("event", string(event.Type)),
+		slog.Int("user_id", int(event.UserID)),
+		slog.String("fingerprint", event.Fingerprint),
+		slog.Time("at", event.At),
+	)
+}
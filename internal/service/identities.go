@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/kuromii5/sync-auth/internal/models"
+	le "github.com/kuromii5/sync-auth/pkg/logger/l_err"
+)
+
+// LinkIdentityURL returns url of the provider's login page for linking
+// external account. The state is valid only for LinkIdentity of the same user
+func (a *Auth) LinkIdentityURL(ctx context.Context, accessToken, provider string) (models.OAuthRedirect, error) {
+	const f = "service.LinkIdentityURL"
+
+	log := a.logger(ctx).With(slog.String("func", f))
+	log.Info("generating oauth link url", slog.String("provider", provider))
+
+	claims, err := a.authenticate(ctx, accessToken)
+	if err != nil {
+		return models.OAuthRedirect{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	redirect, err := a.oAuthManager.AuthURL(ctx, provider, models.OAuthLink, claims.UserID)
+	if err != nil {
+		log.Error("failed to generate authorization url", le.Err(err))
+
+		return models.OAuthRedirect{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	return redirect, nil
+}
+
+// LinkIdentity links external account to the signed in user. Code and state
+// are received from the provider after redirect to LinkIdentityURL url
+func (a *Auth) LinkIdentity(ctx context.Context, accessToken, provider, code, state string) (models.Identity, error) {
+	const f = "service.LinkIdentity"
+
+	log := a.logger(ctx).With(slog.String("func", f))
+	log.Info("linking external identity", slog.String("provider", provider))
+
+	claims, err := a.authenticate(ctx, accessToken)
+	if err != nil {
+		return models.Identity{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	identity, err := a.oAuthManager.Exchange(ctx, provider, code, state, models.OAuthLink, claims.UserID)
+	if err != nil {
+		log.Error("failed to get user identity from provider", le.Err(err))
+
+		return models.Identity{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	if err := a.identityManager.LinkIdentity(ctx, claims.UserID, identity); err != nil {
+		log.Warn("failed to link identity", le.Err(err))
+
+		return models.Identity{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	log.Info("identity linked successfully", slog.Int("user_id", int(claims.UserID)))
+
+	return models.Identity{
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+		LinkedAt: time.Now(),
+	}, nil
+}
+
+// UnlinkIdentity removes linked external account. The last identity of
+// the user without password can't be removed
+func (a *Auth) UnlinkIdentity(ctx context.Context, accessToken, provider string) error {
+	const f = "service.UnlinkIdentity"
+
+	log := a.logger(ctx).With(slog.String("func", f))
+	log.Info("unlinking external identity", slog.String("provider", provider))
+
+	claims, err := a.authenticate(ctx, accessToken)
+	if err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	user, err := a.userProvider.UserByID(ctx, claims.UserID)
+	if err != nil {
+		log.Error("failed to get user", le.Err(err))
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	if len(user.PasswordHash) == 0 {
+		identities, err := a.identityManager.Identities(ctx, user.ID)
+		if err != nil {
+			log.Error("failed to get identities", le.Err(err))
+
+			return fmt.Errorf("%s:%w", f, err)
+		}
+
+		if len(identities) <= 1 {
+			log.Warn("attempt to unlink the only login method", slog.Int("user_id", int(user.ID)))
+
+			return fmt.Errorf("%s:%w", f, ErrLastLoginMethod)
+		}
+	}
+
+	if err := a.identityManager.UnlinkIdentity(ctx, user.ID, provider); err != nil {
+		log.Warn("failed to unlink identity", le.Err(err))
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	log.Info("identity unlinked successfully", slog.Int("user_id", int(user.ID)))
+
+	return nil
+}
+
+func (a *Auth) ListIdentities(ctx context.Context, accessToken string) ([]models.Identity, error) {
+	const f = "service.ListIdentities"
+
+	log := a.logger(ctx).With(slog.String("func", f))
+	log.Info("listing linked identities")
+
+	claims, err := a.authenticate(ctx, accessToken)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", f, err)
+	}
+
+	identities, err := a.identityManager.Identities(ctx, claims.UserID)
+	if err != nil {
+		log.Error("failed to get identities", le.Err(err))
+
+		return nil, fmt.Errorf("%s:%w", f, err)
+	}
+
+	return identities, nil
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/kuromii5/sync-auth/internal/models"
+	le "github.com/kuromii5/sync-auth/pkg/logger/l_err"
+)
+
+const (
+	TokenTypeHintAccess  = "access_token"
+	TokenTypeHintRefresh = "refresh_token"
+)
+
+// IntrospectToken returns state of access or refresh token (RFC 7662),
+// refresh tokens are checked only with fingerprint
+func (a *Auth) IntrospectToken(ctx context.Context, token, tokenTypeHint, fingerprint string) models.TokenIntrospection {
+	const f = "service.IntrospectToken"
+
+	log := a.logger(ctx).With(slog.String("func", f))
+	log.Info("introspecting token")
+
+	lookups := []func() (models.TokenIntrospection, error){
+		func() (models.TokenIntrospection, error) { return a.introspectAccessToken(ctx, token) },
+		func() (models.TokenIntrospection, error) { return a.introspectRefreshToken(ctx, token, fingerprint) },
+	}
+	// hint only changes the order of lookups
+	if tokenTypeHint == TokenTypeHintRefresh {
+		lookups[0], lookups[1] = lookups[1], lookups[0]
+	}
+
+	for _, lookup := range lookups {
+		introspection, err := lookup()
+		if err == nil {
+			log.Info("token is active", slog.Int("user_id", int(introspection.UserID)))
+
+			return introspection
+		}
+		log.Debug("token lookup failed", le.Err(err))
+	}
+
+	log.Info("token is not active")
+
+	return models.TokenIntrospection{Active: false}
+}
+
+func (a *Auth) introspectAccessToken(ctx context.Context, token string) (models.TokenIntrospection, error) {
+	claims, err := a.accessTokenManager.ValidateAccessToken(ctx, token)
+	if err != nil {
+		return models.TokenIntrospection{}, err
+	}
+
+	return models.TokenIntrospection{
+		Active:    true,
+		UserID:    claims.UserID,
+		SessionID: claims.SessionID,
+		Scope:     strings.Join(claims.Roles, " "),
+		ClientID:  claims.Audience,
+		Issuer:    claims.Issuer,
+		IssuedAt:  claims.IssuedAt,
+		ExpiresAt: claims.ExpiresAt,
+	}, nil
+}
+
+func (a *Auth) introspectRefreshToken(ctx context.Context, token, fingerprint string) (models.TokenIntrospection, error) {
+	if fingerprint == "" {
+		return models.TokenIntrospection{}, ErrInvalidCreds
+	}
+
+	claims, err := a.refreshTokenManager.RefreshTokenClaims(ctx, token, fingerprint)
+	if err != nil {
+		return models.TokenIntrospection{}, err
+	}
+
+	// refresh token grants the roles user has at the moment
+	user, err := a.userProvider.UserByID(ctx, claims.UserID)
+	if err != nil {
+		return models.TokenIntrospection{}, err
+	}
+
+	return models.TokenIntrospection{
+		Active:    true,
+		UserID:    claims.UserID,
+		SessionID: claims.SessionID,
+		Scope:     strings.Join(user.Roles, " "),
+		ClientID:  claims.Audience,
+		Issuer:    claims.Issuer,
+		IssuedAt:  claims.IssuedAt,
+		ExpiresAt: claims.ExpiresAt,
+	}, nil
+}
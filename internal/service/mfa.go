@@ -0,0 +1,439 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/kuromii5/sync-auth/internal/models"
+	"github.com/kuromii5/sync-auth/internal/repo/postgres"
+	"github.com/kuromii5/sync-auth/internal/repo/redis"
+	"github.com/kuromii5/sync-auth/internal/service/mfa"
+	le "github.com/kuromii5/sync-auth/pkg/logger/l_err"
+)
+
+// EnrollTOTP generates a new secret for authenticator app. It is not required
+// on login until the user proves it works with ConfirmTOTP
+func (a *Auth) EnrollTOTP(ctx context.Context, accessToken string) (models.TOTPEnrollment, error) {
+	const f = "service.EnrollTOTP"
+
+	log := a.logger(ctx).With(slog.String("func", f))
+	log.Info("enrolling totp")
+
+	claims, err := a.authenticate(ctx, accessToken)
+	if err != nil {
+		return models.TOTPEnrollment{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	user, err := a.userProvider.UserByID(ctx, claims.UserID)
+	if err != nil {
+		log.Error("failed to get user", le.Err(err))
+
+		return models.TOTPEnrollment{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	enrollment, err := a.MFAManager.GenerateTOTP(user.Email)
+	if err != nil {
+		log.Error("failed to generate totp secret", le.Err(err))
+
+		return models.TOTPEnrollment{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	if err := a.totps.SaveTOTPSecret(ctx, user.ID, enrollment.Secret); err != nil {
+		if errors.Is(err, postgres.ErrTOTPEnabled) {
+			log.Warn("totp is already enabled", le.Err(err))
+
+			return models.TOTPEnrollment{}, fmt.Errorf("%s:%w", f, ErrMFAEnabled)
+		}
+		log.Error("failed to save totp secret", le.Err(err))
+
+		return models.TOTPEnrollment{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	log.Info("totp enrollment started", slog.Int("user_id", int(user.ID)))
+
+	return enrollment, nil
+}
+
+// ConfirmTOTP enables TOTP if the code is correct and returns recovery codes,
+// they are shown only once
+func (a *Auth) ConfirmTOTP(ctx context.Context, accessToken, code string) ([]string, error) {
+	const f = "service.ConfirmTOTP"
+
+	log := a.logger(ctx).With(slog.String("func", f))
+	log.Info("confirming totp")
+
+	claims, err := a.authenticate(ctx, accessToken)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", f, err)
+	}
+	userID := claims.UserID
+
+	totp, err := a.totps.TOTP(ctx, userID)
+	if err != nil {
+		if errors.Is(err, postgres.ErrTOTPNotFound) {
+			log.Warn("totp is not enrolled", le.Err(err))
+
+			return nil, fmt.Errorf("%s:%w", f, ErrMFANotEnabled)
+		}
+		log.Error("failed to get totp", le.Err(err))
+
+		return nil, fmt.Errorf("%s:%w", f, err)
+	}
+	if totp.Enabled {
+		log.Warn("totp is already enabled")
+
+		return nil, fmt.Errorf("%s:%w", f, ErrMFAEnabled)
+	}
+
+	if err := a.checkTOTP(ctx, userID, totp.Secret, code); err != nil {
+		log.Warn("totp code is not accepted", le.Err(err))
+
+		return nil, fmt.Errorf("%s:%w", f, err)
+	}
+
+	codes, hashes, err := a.MFAManager.GenerateRecoveryCodes()
+	if err != nil {
+		log.Error("failed to generate recovery codes", le.Err(err))
+
+		return nil, fmt.Errorf("%s:%w", f, err)
+	}
+
+	if err := a.totps.EnableTOTP(ctx, userID, hashes); err != nil {
+		if errors.Is(err, postgres.ErrTOTPEnabled) {
+			log.Warn("totp was enabled concurrently", le.Err(err))
+
+			return nil, fmt.Errorf("%s:%w", f, ErrMFAEnabled)
+		}
+		log.Error("failed to enable totp", le.Err(err))
+
+		return nil, fmt.Errorf("%s:%w", f, err)
+	}
+
+	log.Info("totp enabled", slog.Int("user_id", int(userID)))
+
+	return codes, nil
+}
+
+// DisableTOTP turns two-factor authentication off, it requires TOTP or recovery code
+func (a *Auth) DisableTOTP(ctx context.Context, accessToken, code string) error {
+	const f = "service.DisableTOTP"
+
+	log := a.logger(ctx).With(slog.String("func", f))
+	log.Info("disabling totp")
+
+	claims, err := a.authenticate(ctx, accessToken)
+	if err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+	userID := claims.UserID
+
+	totp, err := a.enabledTOTP(ctx, userID)
+	if err != nil {
+		log.Warn("failed to get enabled totp", le.Err(err))
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	if err := a.checkSecondFactor(ctx, userID, totp.Secret, code); err != nil {
+		log.Warn("second factor is not accepted", le.Err(err))
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	if err := a.totps.DisableTOTP(ctx, userID); err != nil {
+		log.Error("failed to disable totp", le.Err(err))
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	a.securityEvents.Emit(ctx, models.SecurityEvent{
+		Type:   models.EventMFADisabled,
+		UserID: userID,
+		At:     time.Now(),
+	})
+
+	log.Info("totp disabled", slog.Int("user_id", int(userID)))
+
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes, it requires TOTP code
+func (a *Auth) RegenerateRecoveryCodes(ctx context.Context, accessToken, code string) ([]string, error) {
+	const f = "service.RegenerateRecoveryCodes"
+
+	log := a.logger(ctx).With(slog.String("func", f))
+	log.Info("regenerating recovery codes")
+
+	claims, err := a.authenticate(ctx, accessToken)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", f, err)
+	}
+	userID := claims.UserID
+
+	totp, err := a.enabledTOTP(ctx, userID)
+	if err != nil {
+		log.Warn("failed to get enabled totp", le.Err(err))
+
+		return nil, fmt.Errorf("%s:%w", f, err)
+	}
+
+	if err := a.checkTOTP(ctx, userID, totp.Secret, code); err != nil {
+		log.Warn("totp code is not accepted", le.Err(err))
+
+		return nil, fmt.Errorf("%s:%w", f, err)
+	}
+
+	codes, hashes, err := a.MFAManager.GenerateRecoveryCodes()
+	if err != nil {
+		log.Error("failed to generate recovery codes", le.Err(err))
+
+		return nil, fmt.Errorf("%s:%w", f, err)
+	}
+
+	if err := a.totps.ReplaceRecoveryCodes(ctx, userID, hashes); err != nil {
+		log.Error("failed to save recovery codes", le.Err(err))
+
+		return nil, fmt.Errorf("%s:%w", f, err)
+	}
+
+	log.Info("recovery codes regenerated", slog.Int("user_id", int(userID)))
+
+	return codes, nil
+}
+
+// VerifyMFA finishes login started with MFA challenge using TOTP or recovery code
+func (a *Auth) VerifyMFA(ctx context.Context, mfaToken, code string) error {
+	const f = "service.VerifyMFA"
+
+	log := a.logger(ctx).With(slog.String("func", f))
+	log.Info("verifying second factor")
+
+	tokenHash := hashToken(mfaToken)
+
+	login, err := a.mfaLogins.MFALogin(ctx, tokenHash)
+	if err != nil {
+		if errors.Is(err, redis.ErrMFANotFound) {
+			log.Warn("mfa challenge expired or doesn't exist", le.Err(err))
+
+			return fmt.Errorf("%s:%w", f, ErrInvalidMFAToken)
+		}
+		log.Error("failed to get mfa challenge", le.Err(err))
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	// TOTP may be disabled while the challenge is pending, then login starts over
+	totp, err := a.enabledTOTP(ctx, login.UserID)
+	if err != nil {
+		log.Warn("failed to get enabled totp", le.Err(err))
+
+		if errors.Is(err, ErrMFANotEnabled) {
+			return fmt.Errorf("%s:%w", f, ErrInvalidMFAToken)
+		}
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	// wrong code only counts an attempt, the challenge stays
+	valid, err := a.validSecondFactor(ctx, login.UserID, totp.Secret, code)
+	if err != nil {
+		log.Error("failed to check second factor", le.Err(err))
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
+	if !valid {
+		log.Warn("second factor is not accepted")
+
+		if err := a.failMFAAttempt(ctx, tokenHash); err != nil {
+			log.Error("failed to count mfa attempt", le.Err(err))
+
+			return fmt.Errorf("%s:%w", f, err)
+		}
+
+		return fmt.Errorf("%s:%w", f, ErrInvalidMFACode)
+	}
+
+	// only one of concurrent requests deletes the challenge, so the code
+	// is used up only by the request which completes the login
+	if err := a.mfaLogins.DeleteMFALogin(ctx, tokenHash); err != nil {
+		if errors.Is(err, redis.ErrMFANotFound) {
+			log.Warn("mfa challenge was already completed", le.Err(err))
+
+			return fmt.Errorf("%s:%w", f, ErrInvalidMFAToken)
+		}
+		log.Error("failed to delete mfa challenge", le.Err(err))
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	// the code may be used by another request meanwhile, then login starts over
+	if err := a.checkSecondFactor(ctx, login.UserID, totp.Secret, code); err != nil {
+		if !errors.Is(err, ErrInvalidMFACode) {
+			log.Error("failed to use second factor", le.Err(err))
+
+			return fmt.Errorf("%s:%w", f, err)
+		}
+		log.Warn("second factor was already used", le.Err(err))
+
+		return fmt.Errorf("%s:%w", f, ErrInvalidMFACode)
+	}
+
+	user, err := a.userProvider.UserByID(ctx, login.UserID)
+	if err != nil {
+		log.Error("failed to get user", le.Err(err))
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	if err := a.startSession(ctx, user, login.Fingerprint); err != nil {
+		log.Error("failed to start session", le.Err(err))
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	log.Info("user logged in with second factor", slog.Int("user_id", int(user.ID)))
+
+	return nil
+}
+
+// login is called after the first factor is checked. If the user has TOTP
+// enabled or any passkey, MFA challenge is returned instead of starting a session
+func (a *Auth) login(ctx context.Context, user models.User, fingerprint string) (models.MFAChallenge, error) {
+	totp, err := a.totps.TOTP(ctx, user.ID)
+	if err != nil && !errors.Is(err, postgres.ErrTOTPNotFound) {
+		return models.MFAChallenge{}, err
+	}
+	passkeys, err := a.passkeys.Passkeys(ctx, user.ID)
+	if err != nil {
+		return models.MFAChallenge{}, err
+	}
+
+	var methods []string
+	if totp.Enabled {
+		methods = append(methods, "totp", "recovery_code")
+	}
+	if len(passkeys) > 0 {
+		methods = append(methods, "passkey")
+	}
+	if len(methods) == 0 {
+		return models.MFAChallenge{}, a.startSession(ctx, user, fingerprint)
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return models.MFAChallenge{}, err
+	}
+
+	login := models.MFALogin{UserID: user.ID, Fingerprint: fingerprint}
+	if err := a.mfaLogins.SetMFALogin(ctx, hashToken(token), login, a.MFAManager.ChallengeTTL); err != nil {
+		return models.MFAChallenge{}, err
+	}
+
+	return models.MFAChallenge{Token: token, ExpiresIn: a.MFAManager.ChallengeTTL, Methods: methods}, nil
+}
+
+// startSession sends tokens in cookies and alerts the user about login from a new device
+func (a *Auth) startSession(ctx context.Context, user models.User, fingerprint string) error {
+	newDevice := a.isNewDevice(ctx, user.ID, fingerprint)
+
+	tokens, err := a.issueTokens(ctx, user, fingerprint)
+	if err != nil {
+		return err
+	}
+	a.setAuthCookies(ctx, tokens)
+
+	if newDevice {
+		a.notifyNewDevice(ctx, user)
+	}
+
+	return nil
+}
+
+func (a *Auth) enabledTOTP(ctx context.Context, userID int32) (models.TOTP, error) {
+	totp, err := a.totps.TOTP(ctx, userID)
+	if err != nil {
+		if errors.Is(err, postgres.ErrTOTPNotFound) {
+			return models.TOTP{}, ErrMFANotEnabled
+		}
+
+		return models.TOTP{}, err
+	}
+	if !totp.Enabled {
+		return models.TOTP{}, ErrMFANotEnabled
+	}
+
+	return totp, nil
+}
+
+// validSecondFactor checks TOTP code or recovery code without using it up
+func (a *Auth) validSecondFactor(ctx context.Context, userID int32, secret, code string) (bool, error) {
+	if mfa.IsTOTPCode(code) {
+		_, ok := a.MFAManager.ValidateTOTP(secret, code, time.Now())
+
+		return ok, nil
+	}
+
+	return a.totps.HasRecoveryCode(ctx, userID, mfa.HashRecoveryCode(code))
+}
+
+// checkSecondFactor accepts TOTP code or unused recovery code
+func (a *Auth) checkSecondFactor(ctx context.Context, userID int32, secret, code string) error {
+	if mfa.IsTOTPCode(code) {
+		return a.checkTOTP(ctx, userID, secret, code)
+	}
+
+	if err := a.totps.UseRecoveryCode(ctx, userID, mfa.HashRecoveryCode(code)); err != nil {
+		if errors.Is(err, postgres.ErrRecoveryCodeNotFound) {
+			return ErrInvalidMFACode
+		}
+
+		return err
+	}
+
+	a.securityEvents.Emit(ctx, models.SecurityEvent{
+		Type:   models.EventRecoveryCodeUsed,
+		UserID: userID,
+		At:     time.Now(),
+	})
+
+	return nil
+}
+
+// checkTOTP accepts only codes newer than the last accepted one, replayed
+// or older code is treated as invalid
+func (a *Auth) checkTOTP(ctx context.Context, userID int32, secret, code string) error {
+	step, ok := a.MFAManager.ValidateTOTP(secret, code, time.Now())
+	if !ok {
+		return ErrInvalidMFACode
+	}
+
+	fresh, err := a.mfaLogins.UseTOTPStep(ctx, userID, step, a.MFAManager.UsedStepTTL())
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return ErrInvalidMFACode
+	}
+
+	return nil
+}
+
+// failMFAAttempt counts wrong code and cancels the challenge when attempts are over
+func (a *Auth) failMFAAttempt(ctx context.Context, tokenHash string) error {
+	failed, err := a.mfaLogins.FailMFAAttempt(ctx, tokenHash, a.MFAManager.ChallengeTTL)
+	if err != nil {
+		return err
+	}
+
+	if failed >= a.MFAManager.MaxAttempts {
+		err := a.mfaLogins.DeleteMFALogin(ctx, tokenHash)
+		if err != nil && !errors.Is(err, redis.ErrMFANotFound) {
+			return err
+		}
+	}
+
+	return nil
+}
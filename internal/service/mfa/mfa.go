@@ -0,0 +1,140 @@
+package mfa
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/kuromii5/sync-auth/internal/models"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+const (
+	// RFC 6238 defaults, supported by all authenticator apps
+	period = 30
+	digits = otp.DigitsSix
+
+	// codes of the previous and the next time step are accepted for clock drift
+	skew = 1
+
+	recoveryCodeBytes = 5
+)
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// MFAManager generates and checks TOTP codes and recovery codes
+type MFAManager struct {
+	issuer        string
+	ChallengeTTL  time.Duration
+	MaxAttempts   int
+	RecoveryCodes int
+}
+
+func NewMFAManager(issuer string, challengeTTL time.Duration, maxAttempts, recoveryCodes int) *MFAManager {
+	return &MFAManager{
+		issuer:        issuer,
+		ChallengeTTL:  challengeTTL,
+		MaxAttempts:   maxAttempts,
+		RecoveryCodes: recoveryCodes,
+	}
+}
+
+// GenerateTOTP creates new secret and otpauth:// URI for authenticator apps
+func (m *MFAManager) GenerateTOTP(account string) (models.TOTPEnrollment, error) {
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      m.issuer,
+		AccountName: account,
+		Period:      period,
+		Digits:      digits,
+		Algorithm:   otp.AlgorithmSHA1,
+	})
+	if err != nil {
+		return models.TOTPEnrollment{}, err
+	}
+
+	return models.TOTPEnrollment{
+		Secret: key.Secret(),
+		URI:    key.URL(),
+	}, nil
+}
+
+// ValidateTOTP checks code at time t and returns its time step. Caller must
+// remember the last accepted step for UsedStepTTL and reject steps at or below it
+func (m *MFAManager) ValidateTOTP(secret, code string, t time.Time) (uint64, bool) {
+	if !IsTOTPCode(code) {
+		return 0, false
+	}
+
+	current := uint64(t.Unix()) / period
+	for i := -skew; i <= skew; i++ {
+		step := current + uint64(i)
+
+		expected, err := totp.GenerateCodeCustom(secret, time.Unix(int64(step*period), 0), totp.ValidateOpts{
+			Period:    period,
+			Digits:    digits,
+			Algorithm: otp.AlgorithmSHA1,
+		})
+		if err != nil {
+			return 0, false
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// UsedStepTTL is the time during which code of a step is accepted
+func (m *MFAManager) UsedStepTTL() time.Duration {
+	return (2*skew + 1) * period * time.Second
+}
+
+// GenerateRecoveryCodes returns codes to show to the user and their hashes to store
+func (m *MFAManager) GenerateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, m.RecoveryCodes)
+	hashes := make([]string, 0, m.RecoveryCodes)
+
+	b := make([]byte, recoveryCodeBytes)
+	for range m.RecoveryCodes {
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+
+		code := strings.ToLower(recoveryEncoding.EncodeToString(b))
+		code = code[:len(code)/2] + "-" + code[len(code)/2:]
+
+		codes = append(codes, code)
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode normalizes the code, so case and separators typed by the user don't matter
+func HashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// IsTOTPCode tells TOTP codes apart from recovery codes
+func IsTOTPCode(code string) bool {
+	if len(code) != int(digits) {
+		return false
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
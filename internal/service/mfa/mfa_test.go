@@ -0,0 +1,81 @@
+package mfa
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// secret of RFC 6238 test vectors, "12345678901234567890" in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestGenerateTOTP(t *testing.T) {
+	m := NewMFAManager("sync-auth", time.Minute, 5, 10)
+
+	enrollment, err := m.GenerateTOTP("test@example.com")
+	require.NoError(t, err)
+	assert.NotEmpty(t, enrollment.Secret)
+
+	uri, err := url.Parse(enrollment.URI)
+	require.NoError(t, err)
+	assert.Equal(t, "otpauth", uri.Scheme)
+	assert.Equal(t, "totp", uri.Host)
+	assert.Equal(t, "/sync-auth:test@example.com", uri.Path)
+	assert.Equal(t, enrollment.Secret, uri.Query().Get("secret"))
+	assert.Equal(t, "sync-auth", uri.Query().Get("issuer"))
+	assert.Equal(t, "6", uri.Query().Get("digits"))
+	assert.Equal(t, "30", uri.Query().Get("period"))
+}
+
+func TestValidateTOTP(t *testing.T) {
+	m := NewMFAManager("sync-auth", time.Minute, 5, 10)
+	at := time.Unix(1111111109, 0)
+
+	step, ok := m.ValidateTOTP(rfcSecret, "081804", at)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(1111111109/30), step)
+
+	// code of the previous step is accepted for clock drift, its own step is returned
+	step, ok = m.ValidateTOTP(rfcSecret, "081804", at.Add(30*time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, uint64(1111111109/30), step)
+
+	for _, code := range []string{"", "081805", "81804", "0818040", "abcdef"} {
+		_, ok := m.ValidateTOTP(rfcSecret, code, at)
+		assert.False(t, ok, code)
+	}
+
+	_, ok = m.ValidateTOTP(rfcSecret, "081804", at.Add(2*time.Minute))
+	assert.False(t, ok, "expired code was accepted")
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	m := NewMFAManager("sync-auth", time.Minute, 5, 10)
+
+	codes, hashes, err := m.GenerateRecoveryCodes()
+	require.NoError(t, err)
+	require.Len(t, codes, 10)
+	require.Len(t, hashes, 10)
+
+	seen := map[string]bool{}
+	for i, code := range codes {
+		assert.Len(t, code, 9)
+		assert.False(t, IsTOTPCode(code))
+		assert.Equal(t, hashes[i], HashRecoveryCode(code))
+		assert.False(t, seen[code], "duplicate recovery code")
+		seen[code] = true
+	}
+}
+
+func TestHashRecoveryCode(t *testing.T) {
+	hash := HashRecoveryCode("abcde-fghij")
+
+	assert.Equal(t, hash, HashRecoveryCode("ABCDEFGHIJ"))
+	assert.Equal(t, hash, HashRecoveryCode(" abcde fghij "))
+	assert.NotEqual(t, hash, HashRecoveryCode("abcde-fghik"))
+	assert.False(t, strings.Contains(hash, "abcde"))
+}
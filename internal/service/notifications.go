@@ -0,0 +1,57 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/kuromii5/sync-auth/internal/client"
+	"github.com/kuromii5/sync-auth/internal/models"
+	le "github.com/kuromii5/sync-auth/pkg/logger/l_err"
+	"golang.org/x/text/language"
+)
+
+// locale returns language of emails for the user: saved one or language of the request
+func locale(ctx context.Context, user models.User) string {
+	if user.Locale != "" {
+		return user.Locale
+	}
+
+	return client.FromContext(ctx).Locale
+}
+
+// requestLocale returns the most preferred language of the request as BCP 47 tag
+func requestLocale(ctx context.Context) string {
+	tags, _, err := language.ParseAcceptLanguage(client.FromContext(ctx).Locale)
+	if err != nil || len(tags) == 0 {
+		return ""
+	}
+
+	return tags[0].String()
+}
+
+// isNewDevice reports whether user has sessions and none of them is on the device.
+// The first login of the user is not considered new device
+func (a *Auth) isNewDevice(ctx context.Context, userID int32, fingerprint string) bool {
+	sessions, err := a.sessionManager.Sessions(ctx, userID)
+	if err != nil {
+		a.logger(ctx).Warn("failed to get sessions", le.Err(err))
+
+		return false
+	}
+
+	for _, session := range sessions {
+		if session.Fingerprint == fingerprint {
+			return false
+		}
+	}
+
+	return len(sessions) > 0
+}
+
+// notifyNewDevice sends alert about sign in, failure doesn't break the login
+func (a *Auth) notifyNewDevice(ctx context.Context, user models.User) {
+	err := a.VerificationManager.SendNewDeviceAlert(ctx, user.Email, locale(ctx, user), client.FromContext(ctx), time.Now())
+	if err != nil {
+		a.logger(ctx).Warn("failed to send new device alert", le.Err(err))
+	}
+}
@@ -6,77 +6,126 @@ import (
 	"fmt"
 	"log/slog"
 
+	"github.com/kuromii5/sync-auth/internal/models"
 	"github.com/kuromii5/sync-auth/internal/repo/postgres"
 	le "github.com/kuromii5/sync-auth/pkg/logger/l_err"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/metadata"
 )
 
-func (a *Auth) ExchangeCodeForToken(ctx context.Context, code, provider, fingerprint string) error {
-	const f = "auth.ExchangeCodeForToken"
+// OAuthURL returns url of the provider's login page for the social login
+func (a *Auth) OAuthURL(ctx context.Context, provider string) (models.OAuthRedirect, error) {
+	const f = "auth.OAuthURL"
 
-	log := a.log.With(slog.String("func", f))
-	log.Info("exchanging code for tokens")
+	log := a.logger(ctx).With(slog.String("func", f))
+	log.Info("generating oauth authorization url", slog.String("provider", provider))
 
-	oauthConfig := a.oAuthManager.ConfigByProvider(provider)
-	if oauthConfig == nil {
-		log.Error("OAuth client not found", slog.String("provider", provider))
+	redirect, err := a.oAuthManager.AuthURL(ctx, provider, models.OAuthLogin, 0)
+	if err != nil {
+		log.Error("failed to generate authorization url", le.Err(err))
 
-		return fmt.Errorf("%s:%w", f, ErrInvalidOAuthClient)
+		return models.OAuthRedirect{}, fmt.Errorf("%s:%w", f, err)
 	}
 
-	tokens, err := oauthConfig.Exchange(ctx, code)
-	if err != nil {
-		log.Error("failed to exchange code for token", le.Err(err))
+	return redirect, nil
+}
 
-		return fmt.Errorf("%s:%s", f, err)
-	}
+func (a *Auth) ExchangeCodeForToken(ctx context.Context, code, provider, state, fingerprint string) (models.MFAChallenge, error) {
+	const f = "auth.ExchangeCodeForToken"
+
+	log := a.logger(ctx).With(slog.String("func", f))
+	log.Info("exchanging code for tokens")
 
-	email, err := a.oAuthManager.GetGithubEmail(ctx, tokens.AccessToken)
+	identity, err := a.oAuthManager.Exchange(ctx, provider, code, state, models.OAuthLogin, 0)
 	if err != nil {
-		log.Error("failed to get email from token", le.Err(err))
+		log.Error("failed to get user identity from provider", le.Err(err))
 
-		return fmt.Errorf("%s:%w", f, err)
+		return models.MFAChallenge{}, fmt.Errorf("%s:%w", f, err)
 	}
 
-	user, err := a.userProvider.UserByEmail(ctx, email)
+	user, err := a.identityManager.UserByIdentity(ctx, identity.Provider, identity.Subject)
 	switch {
 	case err == nil:
 	case errors.Is(err, postgres.ErrUserNotFound):
-		userID, saveErr := a.userSaver.SaveUser(ctx, email, nil)
-		if saveErr != nil {
-			log.Error("failed to save new user", le.Err(saveErr))
+		user, err = a.signUpWithIdentity(ctx, identity)
+		if err != nil {
+			log.Warn("failed to sign up user with external identity", le.Err(err))
 
-			return fmt.Errorf("%s:%w", f, saveErr)
+			return models.MFAChallenge{}, fmt.Errorf("%s:%w", f, err)
 		}
-		user.ID = userID
 	default:
-		log.Error("failed to get user", le.Err(err))
+		log.Error("failed to get user by identity", le.Err(err))
 
-		return fmt.Errorf("%s:%w", f, err)
+		return models.MFAChallenge{}, fmt.Errorf("%s:%w", f, err)
 	}
 
-	accessToken, err := a.accessTokenManager.NewAccessToken(ctx, user.ID)
+	challenge, err := a.login(ctx, user, fingerprint)
 	if err != nil {
-		log.Error("failed to generate access token", le.Err(err))
+		log.Error("failed to log in", le.Err(err))
+
+		return models.MFAChallenge{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	if challenge.Token != "" {
+		log.Info("second factor is required", slog.Int("user_id", int(user.ID)))
+
+		return challenge, nil
+	}
+
+	log.Info("user logged in via external service successfully")
 
-		return fmt.Errorf("%s:%w", f, err)
+	return models.MFAChallenge{}, nil
+}
+
+// legacyProvider is the only provider of social login before linked identities.
+// Its users were found by email and have no rows in user_identities
+const legacyProvider = "github"
+
+// signUpWithIdentity creates new user for external account. Existing account
+// with the same email is never taken over, its owner has to link the identity
+func (a *Auth) signUpWithIdentity(ctx context.Context, identity models.ExternalIdentity) (models.User, error) {
+	// unverified email may belong to anyone
+	if !identity.EmailVerified {
+		return models.User{}, ErrEmailNotVerified
+	}
+
+	user, err := a.userProvider.UserByEmail(ctx, identity.Email)
+	if err == nil {
+		return a.linkLegacyUser(ctx, user, identity)
+	}
+	if !errors.Is(err, postgres.ErrUserNotFound) {
+		return models.User{}, err
 	}
 
-	refreshToken, err := a.refreshTokenManager.NewRefreshToken(ctx, user.ID, fingerprint)
+	userID, err := a.identityManager.SaveUserWithIdentity(ctx, identity)
 	if err != nil {
-		log.Error("failed to generate refresh token", le.Err(err))
+		if errors.Is(err, postgres.ErrUserExists) {
+			return models.User{}, ErrIdentityNotLinked
+		}
+
+		return models.User{}, err
+	}
+
+	return a.userProvider.UserByID(ctx, userID)
+}
 
-		return fmt.Errorf("%s:%w", f, err)
+// linkLegacyUser links identity to the account created by social login before
+// linked identities. Such account has no password and no identities, and the
+// provider verified the same email, so it's signed in as before
+func (a *Auth) linkLegacyUser(ctx context.Context, user models.User, identity models.ExternalIdentity) (models.User, error) {
+	if identity.Provider != legacyProvider || len(user.PasswordHash) != 0 {
+		return models.User{}, ErrIdentityNotLinked
 	}
 
-	md := metadata.New(map[string]string{})
-	md.Append("Set-Cookie", fmt.Sprintf("access_token=%s", accessToken))
-	md.Append("Set-Cookie", fmt.Sprintf("refresh_token=%s", refreshToken))
-	grpc.SetHeader(ctx, md)
-	log.Debug("Generated metadata", slog.Any("md", md))
+	identities, err := a.identityManager.Identities(ctx, user.ID)
+	if err != nil {
+		return models.User{}, err
+	}
+	if len(identities) != 0 {
+		return models.User{}, ErrIdentityNotLinked
+	}
 
-	log.Info("user logged in via external service successfully")
+	if err := a.identityManager.LinkIdentity(ctx, user.ID, identity); err != nil {
+		return models.User{}, err
+	}
 
-	return nil
+	return user, nil
 }
@@ -4,57 +4,54 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log/slog"
 	"net/http"
+	"strconv"
 
+	"github.com/kuromii5/sync-auth/internal/models"
 	"golang.org/x/oauth2"
 )
 
-type OAuthManager struct {
-	log          *slog.Logger
-	OAuthClients map[string]*oauth2.Config
+// GithubProvider uses GitHub REST API, because GitHub doesn't support OpenID Connect for apps
+type GithubProvider struct {
+	config *oauth2.Config
 }
 
-func NewOAuthManager(log *slog.Logger, oauthClients map[string]*oauth2.Config) *OAuthManager {
-	return &OAuthManager{log: log, OAuthClients: oauthClients}
+func NewGithubProvider(config *oauth2.Config) *GithubProvider {
+	return &GithubProvider{config: config}
 }
 
-func (m *OAuthManager) ConfigByProvider(provider string) *oauth2.Config {
-	config, ok := m.OAuthClients[provider]
-	if !ok {
-		return nil
-	}
-
-	return config
+func (p *GithubProvider) Config() *oauth2.Config {
+	return p.config
 }
 
-func (m *OAuthManager) GetGithubEmail(ctx context.Context, accessToken string) (string, error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", "https://api.github.com/user/emails", nil)
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+func (p *GithubProvider) Identity(ctx context.Context, token *oauth2.Token, _ string) (models.ExternalIdentity, error) {
+	var user struct {
+		ID int64 `json:"id"`
+	}
+	if err := githubGet(ctx, token.AccessToken, "https://api.github.com/user", &user); err != nil {
+		return models.ExternalIdentity{}, err
 	}
 
-	req.Header.Set("Authorization", "Bearer "+accessToken)
-	req.Header.Set("Accept", "application/vnd.github.v3+json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	email, err := p.githubEmail(ctx, token.AccessToken)
 	if err != nil {
-		return "", fmt.Errorf("failed to perform request: %w", err)
+		return models.ExternalIdentity{}, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-	}
+	return models.ExternalIdentity{
+		Subject:       strconv.FormatInt(user.ID, 10),
+		Email:         email,
+		EmailVerified: true,
+	}, nil
+}
 
+func (p *GithubProvider) githubEmail(ctx context.Context, accessToken string) (string, error) {
 	var emails []struct {
 		Email    string `json:"email"`
 		Primary  bool   `json:"primary"`
 		Verified bool   `json:"verified"`
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&emails); err != nil {
-		return "", fmt.Errorf("failed to decode response: %w", err)
+	if err := githubGet(ctx, accessToken, "https://api.github.com/user/emails", &emails); err != nil {
+		return "", err
 	}
 
 	for _, email := range emails {
@@ -65,3 +62,30 @@ func (m *OAuthManager) GetGithubEmail(ctx context.Context, accessToken string) (
 
 	return "", fmt.Errorf("no primary verified email found")
 }
+
+func githubGet(ctx context.Context, accessToken, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to perform request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,152 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/kuromii5/sync-auth/internal/models"
+	le "github.com/kuromii5/sync-auth/pkg/logger/l_err"
+	"golang.org/x/oauth2"
+)
+
+var (
+	ErrUnknownProvider = errors.New("unknown oauth provider")
+	ErrStateMismatch   = errors.New("oauth state was issued for another provider")
+	ErrStateMisused    = errors.New("oauth state was issued for another purpose or user")
+)
+
+// Provider is an external identity provider used for social login
+type Provider interface {
+	Config() *oauth2.Config
+	// Identity returns user identity by the token received from the provider.
+	// Nonce is compared with the one in id_token if provider supports it
+	Identity(ctx context.Context, token *oauth2.Token, nonce string) (models.ExternalIdentity, error)
+}
+
+// StateStorage keeps authorization requests until the provider redirects user back.
+// ConsumeOAuthState must return the state only once
+type StateStorage interface {
+	SetOAuthState(ctx context.Context, state string, request models.OAuthState, expires time.Duration) error
+	ConsumeOAuthState(ctx context.Context, state string) (models.OAuthState, error)
+}
+
+type OAuthManager struct {
+	log          *slog.Logger
+	providers    map[string]Provider
+	stateStorage StateStorage
+	stateTTL     time.Duration
+}
+
+func NewOAuthManager(log *slog.Logger, providers map[string]Provider, stateStorage StateStorage, stateTTL time.Duration) *OAuthManager {
+	return &OAuthManager{
+		log:          log,
+		providers:    providers,
+		stateStorage: stateStorage,
+		stateTTL:     stateTTL,
+	}
+}
+
+// AuthURL starts authorization at the provider. State, nonce and PKCE
+// code verifier are kept in storage, only the state is given to the client.
+// State of the link flow is bound to the user, so it can't be completed by another account
+func (m *OAuthManager) AuthURL(ctx context.Context, provider string, purpose models.OAuthPurpose, userID int32) (models.OAuthRedirect, error) {
+	const f = "oauth.AuthURL"
+
+	p, ok := m.providers[provider]
+	if !ok {
+		return models.OAuthRedirect{}, fmt.Errorf("%s:%w", f, ErrUnknownProvider)
+	}
+
+	state, err := randomString()
+	if err != nil {
+		return models.OAuthRedirect{}, fmt.Errorf("%s:%w", f, err)
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return models.OAuthRedirect{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	request := models.OAuthState{
+		Provider:     provider,
+		Nonce:        nonce,
+		CodeVerifier: oauth2.GenerateVerifier(),
+		Purpose:      purpose,
+		UserID:       userID,
+	}
+	if err := m.stateStorage.SetOAuthState(ctx, state, request, m.stateTTL); err != nil {
+		return models.OAuthRedirect{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	url := p.Config().AuthCodeURL(state,
+		oauth2.S256ChallengeOption(request.CodeVerifier),
+		oauth2.SetAuthURLParam("nonce", nonce),
+	)
+
+	return models.OAuthRedirect{
+		URL:       url,
+		State:     state,
+		ExpiresAt: time.Now().Add(m.stateTTL),
+	}, nil
+}
+
+// Exchange consumes the state and exchanges authorization code for user identity.
+// State must be issued by AuthURL with the same purpose and user
+func (m *OAuthManager) Exchange(ctx context.Context, provider, code, state string, purpose models.OAuthPurpose, userID int32) (models.ExternalIdentity, error) {
+	const f = "oauth.Exchange"
+
+	log := m.log.With(slog.String("func", f))
+
+	p, ok := m.providers[provider]
+	if !ok {
+		return models.ExternalIdentity{}, fmt.Errorf("%s:%w", f, ErrUnknownProvider)
+	}
+
+	// state is deleted before the exchange, so it can't be replayed even if exchange fails
+	request, err := m.stateStorage.ConsumeOAuthState(ctx, state)
+	if err != nil {
+		log.Warn("invalid oauth state", le.Err(err))
+
+		return models.ExternalIdentity{}, fmt.Errorf("%s:%w", f, err)
+	}
+	if request.Provider != provider {
+		log.Warn("oauth state provider mismatch", slog.String("provider", provider), slog.String("expected", request.Provider))
+
+		return models.ExternalIdentity{}, fmt.Errorf("%s:%w", f, ErrStateMismatch)
+	}
+	if request.Purpose != purpose || request.UserID != userID {
+		log.Warn("oauth state purpose mismatch",
+			slog.String("purpose", string(purpose)),
+			slog.String("expected", string(request.Purpose)),
+			slog.Int("user_id", int(userID)),
+		)
+
+		return models.ExternalIdentity{}, fmt.Errorf("%s:%w", f, ErrStateMisused)
+	}
+
+	token, err := p.Config().Exchange(ctx, code, oauth2.VerifierOption(request.CodeVerifier))
+	if err != nil {
+		return models.ExternalIdentity{}, fmt.Errorf("%s: failed to exchange code: %w", f, err)
+	}
+
+	identity, err := p.Identity(ctx, token, request.Nonce)
+	if err != nil {
+		return models.ExternalIdentity{}, fmt.Errorf("%s:%w", f, err)
+	}
+	identity.Provider = provider
+
+	return identity, nil
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,142 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/kuromii5/sync-auth/internal/models"
+	offlog "github.com/kuromii5/sync-auth/pkg/logger/off"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+var errStateNotFound = errors.New("state not found")
+
+type memoryStateStorage struct {
+	mu     sync.Mutex
+	states map[string]models.OAuthState
+}
+
+func (m *memoryStateStorage) SetOAuthState(_ context.Context, state string, request models.OAuthState, _ time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.states[state] = request
+	return nil
+}
+
+func (m *memoryStateStorage) ConsumeOAuthState(_ context.Context, state string) (models.OAuthState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	request, ok := m.states[state]
+	if !ok {
+		return models.OAuthState{}, errStateNotFound
+	}
+	delete(m.states, state)
+
+	return request, nil
+}
+
+func TestOAuthManager_StateAndPKCE(t *testing.T) {
+	ctx := context.Background()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	issuer, mux := newTestIssuer(t, key)
+	provider, err := NewOIDCProvider(ctx, issuer.URL, testClientID, "secret", "http://localhost/callback", nil)
+	require.NoError(t, err)
+
+	manager := NewOAuthManager(offlog.New(), map[string]Provider{"test": provider}, &memoryStateStorage{states: map[string]models.OAuthState{}}, time.Minute)
+
+	redirect, err := manager.AuthURL(ctx, "test", models.OAuthLink, 7)
+	require.NoError(t, err)
+	require.NotEmpty(t, redirect.State)
+
+	authURL, err := url.Parse(redirect.URL)
+	require.NoError(t, err)
+	query := authURL.Query()
+	require.Equal(t, redirect.State, query.Get("state"))
+	require.Equal(t, "S256", query.Get("code_challenge_method"))
+	require.NotEmpty(t, query.Get("nonce"))
+
+	// token endpoint checks PKCE verifier and returns id_token with nonce from authorization request
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		if oauth2.S256ChallengeFromVerifier(r.PostForm.Get("code_verifier")) != query.Get("code_challenge") {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		token := idToken(t, key, jwt.MapClaims{
+			"iss":            issuer.URL,
+			"aud":            testClientID,
+			"sub":            "subject",
+			"exp":            time.Now().Add(time.Minute).Unix(),
+			"nonce":          query.Get("nonce"),
+			"email":          "user@example.com",
+			"email_verified": true,
+		})
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"id_token":     token.Extra("id_token"),
+		})
+	})
+
+	_, err = manager.Exchange(ctx, "test", "code", "unknown", models.OAuthLink, 7)
+	require.ErrorIs(t, err, errStateNotFound)
+
+	_, err = manager.Exchange(ctx, "other", "code", redirect.State, models.OAuthLink, 7)
+	require.ErrorIs(t, err, ErrUnknownProvider)
+
+	identity, err := manager.Exchange(ctx, "test", "code", redirect.State, models.OAuthLink, 7)
+	require.NoError(t, err)
+	require.Equal(t, "test", identity.Provider)
+	require.Equal(t, "subject", identity.Subject)
+
+	// state is consumed
+	_, err = manager.Exchange(ctx, "test", "code", redirect.State, models.OAuthLink, 7)
+	require.ErrorIs(t, err, errStateNotFound)
+}
+
+func TestOAuthManager_StatePurpose(t *testing.T) {
+	ctx := context.Background()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	issuer, _ := newTestIssuer(t, key)
+	provider, err := NewOIDCProvider(ctx, issuer.URL, testClientID, "secret", "http://localhost/callback", nil)
+	require.NoError(t, err)
+
+	manager := NewOAuthManager(offlog.New(), map[string]Provider{"test": provider}, &memoryStateStorage{states: map[string]models.OAuthState{}}, time.Minute)
+
+	tests := []struct {
+		name    string
+		purpose models.OAuthPurpose
+		userID  int32
+	}{
+		{name: "login with link state", purpose: models.OAuthLogin},
+		{name: "link by another user", purpose: models.OAuthLink, userID: 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			redirect, err := manager.AuthURL(ctx, "test", models.OAuthLink, 7)
+			require.NoError(t, err)
+
+			_, err = manager.Exchange(ctx, "test", "code", redirect.State, tt.purpose, tt.userID)
+			require.ErrorIs(t, err, ErrStateMisused)
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package oauth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/kuromii5/sync-auth/internal/models"
+	"golang.org/x/oauth2"
+)
+
+var (
+	ErrNoIDToken     = errors.New("id_token is missing in token response")
+	ErrInvalidNonce  = errors.New("id_token nonce mismatch")
+	ErrNoEmailClaims = errors.New("id_token has no email claim")
+)
+
+// OIDCProvider is any OpenID Connect provider (Google, GitLab, Keycloak, ...).
+// Endpoints and signing keys are fetched by discovery from the issuer URL
+type OIDCProvider struct {
+	config   *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+func NewOIDCProvider(ctx context.Context, issuerURL, clientID, clientSecret, redirectURL string, scopes []string) (*OIDCProvider, error) {
+	const f = "oauth.NewOIDCProvider"
+
+	provider, err := oidc.NewProvider(ctx, issuerURL)
+	if err != nil {
+		return nil, fmt.Errorf("%s: discovery failed: %w", f, err)
+	}
+
+	if len(scopes) == 0 {
+		scopes = []string{"email"}
+	}
+	if !slices.Contains(scopes, oidc.ScopeOpenID) {
+		scopes = append([]string{oidc.ScopeOpenID}, scopes...)
+	}
+
+	return &OIDCProvider{
+		config: &oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			Endpoint:     provider.Endpoint(),
+			RedirectURL:  redirectURL,
+			Scopes:       scopes,
+		},
+		// checks signature, issuer, audience and expiration
+		verifier: provider.Verifier(&oidc.Config{ClientID: clientID}),
+	}, nil
+}
+
+func (p *OIDCProvider) Config() *oauth2.Config {
+	return p.config
+}
+
+func (p *OIDCProvider) Identity(ctx context.Context, token *oauth2.Token, nonce string) (models.ExternalIdentity, error) {
+	const f = "oauth.OIDCProvider.Identity"
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return models.ExternalIdentity{}, fmt.Errorf("%s:%w", f, ErrNoIDToken)
+	}
+
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return models.ExternalIdentity{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(nonce)) != 1 {
+		return models.ExternalIdentity{}, fmt.Errorf("%s:%w", f, ErrInvalidNonce)
+	}
+
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return models.ExternalIdentity{}, fmt.Errorf("%s:%w", f, err)
+	}
+	if claims.Email == "" {
+		return models.ExternalIdentity{}, fmt.Errorf("%s:%w", f, ErrNoEmailClaims)
+	}
+
+	return models.ExternalIdentity{
+		Subject:       idToken.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+	}, nil
+}
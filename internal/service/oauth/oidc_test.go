@@ -0,0 +1,124 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+const testClientID = "client"
+
+// newTestIssuer starts OIDC provider which publishes discovery document and signing key
+func newTestIssuer(t *testing.T, key *rsa.PrivateKey) (*httptest.Server, *http.ServeMux) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                server.URL,
+			"authorization_endpoint":                server.URL + "/authorize",
+			"token_endpoint":                        server.URL + "/token",
+			"jwks_uri":                              server.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test",
+				"alg": "RS256",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+
+	return server, mux
+}
+
+func idToken(t *testing.T, key *rsa.PrivateKey, claims jwt.MapClaims) *oauth2.Token {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "test"
+
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+
+	return (&oauth2.Token{AccessToken: "access"}).WithExtra(map[string]any{"id_token": signed})
+}
+
+func TestOIDCProviderIdentity(t *testing.T) {
+	ctx := context.Background()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	issuer, _ := newTestIssuer(t, key)
+	provider, err := NewOIDCProvider(ctx, issuer.URL, testClientID, "secret", "http://localhost/callback", nil)
+	require.NoError(t, err)
+	require.Equal(t, issuer.URL+"/token", provider.Config().Endpoint.TokenURL)
+	require.Equal(t, []string{"openid", "email"}, provider.Config().Scopes)
+
+	claims := func(overrides jwt.MapClaims) jwt.MapClaims {
+		claims := jwt.MapClaims{
+			"iss":            issuer.URL,
+			"aud":            testClientID,
+			"sub":            "subject",
+			"exp":            time.Now().Add(time.Minute).Unix(),
+			"iat":            time.Now().Unix(),
+			"nonce":          "nonce",
+			"email":          "user@example.com",
+			"email_verified": true,
+		}
+		for k, v := range overrides {
+			claims[k] = v
+		}
+
+		return claims
+	}
+
+	identity, err := provider.Identity(ctx, idToken(t, key, claims(nil)), "nonce")
+	require.NoError(t, err)
+	require.Equal(t, "subject", identity.Subject)
+	require.Equal(t, "user@example.com", identity.Email)
+	require.True(t, identity.EmailVerified)
+
+	tests := []struct {
+		name  string
+		token *oauth2.Token
+		nonce string
+		err   error
+	}{
+		{name: "wrong nonce", token: idToken(t, key, claims(nil)), nonce: "other", err: ErrInvalidNonce},
+		{name: "wrong audience", token: idToken(t, key, claims(jwt.MapClaims{"aud": "other"})), nonce: "nonce"},
+		{name: "wrong issuer", token: idToken(t, key, claims(jwt.MapClaims{"iss": "https://evil.example"})), nonce: "nonce"},
+		{name: "expired", token: idToken(t, key, claims(jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()})), nonce: "nonce"},
+		{name: "wrong signature", token: idToken(t, otherKey, claims(nil)), nonce: "nonce"},
+		{name: "no email", token: idToken(t, key, claims(jwt.MapClaims{"email": ""})), nonce: "nonce", err: ErrNoEmailClaims},
+		{name: "no id_token", token: &oauth2.Token{AccessToken: "access"}, nonce: "nonce", err: ErrNoIDToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := provider.Identity(ctx, tt.token, tt.nonce)
+			require.Error(t, err)
+			if tt.err != nil {
+				require.ErrorIs(t, err, tt.err)
+			}
+		})
+	}
+}
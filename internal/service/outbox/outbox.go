@@ -0,0 +1,198 @@
+package outbox
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/kuromii5/sync-auth/internal/mail"
+	"github.com/kuromii5/sync-auth/internal/models"
+	le "github.com/kuromii5/sync-auth/pkg/logger/l_err"
+)
+
+type Storage interface {
+	ClaimEmails(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxEmail, error)
+	MarkEmailSent(ctx context.Context, id int64) error
+	RetryEmail(ctx context.Context, id int64, delay time.Duration, lastErr string) error
+	DeadLetterEmail(ctx context.Context, id int64, lastErr string) error
+	PurgeEmails(ctx context.Context, before time.Time) (int64, error)
+}
+
+type Config struct {
+	PollInterval time.Duration
+	BatchSize    int
+	// Message is dead-lettered after MaxAttempts failed attempts
+	MaxAttempts int
+	// Delay before the second attempt, doubled after every next failure up to MaxBackoff
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// Time for sending a single message
+	SendTimeout time.Duration
+	// Sent and dead messages older than Retention are deleted every PurgeInterval
+	Retention     time.Duration
+	PurgeInterval time.Duration
+}
+
+// Worker delivers messages from the email outbox
+type Worker struct {
+	log     *slog.Logger
+	storage Storage
+	mailer  mail.Mailer
+	cfg     Config
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewWorker(log *slog.Logger, storage Storage, mailer mail.Mailer, cfg Config) *Worker {
+	return &Worker{
+		log:     log,
+		storage: storage,
+		mailer:  mailer,
+		cfg:     cfg,
+		stop:    make(chan struct{}),
+	}
+}
+
+// Start runs delivery loop in background until Stop is called
+func (w *Worker) Start() {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		w.run()
+	}()
+}
+
+// Stop waits until message being sent is processed and stops the worker
+func (w *Worker) Stop() {
+	close(w.stop)
+	w.wg.Wait()
+}
+
+func (w *Worker) run() {
+	w.log.Info("starting email outbox worker", slog.Duration("poll_interval", w.cfg.PollInterval))
+
+	ticker := time.NewTicker(w.cfg.PollInterval)
+	defer ticker.Stop()
+
+	var lastPurge time.Time
+	for {
+		if time.Since(lastPurge) >= w.cfg.PurgeInterval {
+			w.Purge(context.Background())
+			lastPurge = time.Now()
+		}
+
+		// a full batch means there may be more due messages
+		for w.ProcessBatch(context.Background()) == w.cfg.BatchSize {
+			if w.stopped() {
+				return
+			}
+		}
+
+		select {
+		case <-w.stop:
+			w.log.Info("email outbox worker stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *Worker) stopped() bool {
+	select {
+	case <-w.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// ProcessBatch sends a batch of due messages and returns its size
+func (w *Worker) ProcessBatch(ctx context.Context) int {
+	const f = "outbox.ProcessBatch"
+
+	log := w.log.With(slog.String("func", f))
+
+	// message is hidden from other workers while it is being sent
+	lease := w.cfg.SendTimeout * time.Duration(w.cfg.BatchSize+1)
+	emails, err := w.storage.ClaimEmails(ctx, w.cfg.BatchSize, lease)
+	if err != nil {
+		log.Error("failed to claim emails", le.Err(err))
+
+		return 0
+	}
+
+	for i, email := range emails {
+		if w.stopped() {
+			// the rest is sent after the lease expires
+			return i
+		}
+
+		w.deliver(ctx, log, email)
+	}
+
+	return len(emails)
+}
+
+func (w *Worker) deliver(ctx context.Context, log *slog.Logger, email models.OutboxEmail) {
+	log = log.With(slog.Int64("email_id", email.ID), slog.Int("attempt", email.Attempts))
+
+	sendCtx, cancel := context.WithTimeout(ctx, w.cfg.SendTimeout)
+	err := w.mailer.Send(sendCtx, mail.Message{
+		To:      email.To,
+		Subject: email.Subject,
+		Text:    email.Text,
+		HTML:    email.HTML,
+	})
+	cancel()
+
+	switch {
+	case err == nil:
+		if err := w.storage.MarkEmailSent(ctx, email.ID); err != nil {
+			log.Error("failed to mark email as sent", le.Err(err))
+			return
+		}
+		log.Info("email sent")
+
+	case mail.IsPermanent(err) || email.Attempts >= w.cfg.MaxAttempts:
+		log.Error("email can't be delivered, moving to dead letters", le.Err(err))
+		if err := w.storage.DeadLetterEmail(ctx, email.ID, err.Error()); err != nil {
+			log.Error("failed to dead-letter email", le.Err(err))
+		}
+
+	default:
+		delay := w.Backoff(email.Attempts)
+		log.Warn("failed to send email, retrying later", le.Err(err), slog.Duration("delay", delay))
+		if err := w.storage.RetryEmail(ctx, email.ID, delay, err.Error()); err != nil {
+			log.Error("failed to schedule email retry", le.Err(err))
+		}
+	}
+}
+
+// Purge deletes sent and dead messages older than retention
+func (w *Worker) Purge(ctx context.Context) {
+	const f = "outbox.Purge"
+
+	log := w.log.With(slog.String("func", f))
+
+	purged, err := w.storage.PurgeEmails(ctx, time.Now().Add(-w.cfg.Retention))
+	if err != nil {
+		log.Error("failed to purge emails", le.Err(err))
+
+		return
+	}
+	if purged > 0 {
+		log.Info("old emails purged", slog.Int64("count", purged))
+	}
+}
+
+// Backoff returns delay after given number of failed attempts
+func (w *Worker) Backoff(attempts int) time.Duration {
+	delay := w.cfg.BaseBackoff
+	for i := 1; i < attempts && delay < w.cfg.MaxBackoff; i++ {
+		delay *= 2
+	}
+
+	return min(delay, w.cfg.MaxBackoff)
+}
@@ -0,0 +1,193 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"net/textproto"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kuromii5/sync-auth/internal/mail"
+	"github.com/kuromii5/sync-auth/internal/models"
+	offlog "github.com/kuromii5/sync-auth/pkg/logger/off"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryStorage keeps outbox in memory, every message is due immediately
+type memoryStorage struct {
+	mu      sync.Mutex
+	pending []models.OutboxEmail
+	sent    []int64
+	retried map[int64]time.Duration
+	dead    map[int64]string
+	purged  []time.Time
+}
+
+func newMemoryStorage(emails ...models.OutboxEmail) *memoryStorage {
+	return &memoryStorage{
+		pending: emails,
+		retried: make(map[int64]time.Duration),
+		dead:    make(map[int64]string),
+	}
+}
+
+func (s *memoryStorage) ClaimEmails(_ context.Context, limit int, _ time.Duration) ([]models.OutboxEmail, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := min(limit, len(s.pending))
+	claimed := s.pending[:n]
+	s.pending = s.pending[n:]
+	for i := range claimed {
+		claimed[i].Attempts++
+	}
+
+	return claimed, nil
+}
+
+func (s *memoryStorage) MarkEmailSent(_ context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sent = append(s.sent, id)
+
+	return nil
+}
+
+func (s *memoryStorage) RetryEmail(_ context.Context, id int64, delay time.Duration, _ string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.retried[id] = delay
+
+	return nil
+}
+
+func (s *memoryStorage) DeadLetterEmail(_ context.Context, id int64, lastErr string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.dead[id] = lastErr
+
+	return nil
+}
+
+func (s *memoryStorage) PurgeEmails(_ context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.purged = append(s.purged, before)
+
+	return 0, nil
+}
+
+// failingMailer fails sending to given recipients
+type failingMailer struct {
+	*mail.MemoryMailer
+	failures map[string]error
+}
+
+func (m *failingMailer) Send(ctx context.Context, msg mail.Message) error {
+	if err, ok := m.failures[msg.To]; ok {
+		return err
+	}
+
+	return m.MemoryMailer.Send(ctx, msg)
+}
+
+var testConfig = Config{
+	PollInterval:  10 * time.Millisecond,
+	BatchSize:     2,
+	MaxAttempts:   3,
+	BaseBackoff:   time.Second,
+	MaxBackoff:    5 * time.Second,
+	SendTimeout:   time.Second,
+	Retention:     time.Hour,
+	PurgeInterval: time.Hour,
+}
+
+func TestProcessBatch(t *testing.T) {
+	storage := newMemoryStorage(
+		models.OutboxEmail{ID: 1, To: "ok@example.com", Subject: "Hi"},
+		models.OutboxEmail{ID: 2, To: "down@example.com"},
+		models.OutboxEmail{ID: 3, To: "unknown@example.com"},
+		models.OutboxEmail{ID: 4, To: "down@example.com", Attempts: 2},
+	)
+	mailer := &failingMailer{
+		MemoryMailer: mail.NewMemoryMailer(),
+		failures: map[string]error{
+			"down@example.com":    &textproto.Error{Code: 421, Msg: "try again later"},
+			"unknown@example.com": &textproto.Error{Code: 550, Msg: "mailbox unavailable"},
+		},
+	}
+	worker := NewWorker(offlog.New(), storage, mailer, testConfig)
+
+	assert.Equal(t, 2, worker.ProcessBatch(context.Background()))
+	assert.Equal(t, 2, worker.ProcessBatch(context.Background()))
+	assert.Equal(t, 0, worker.ProcessBatch(context.Background()))
+
+	assert.Equal(t, []int64{1}, storage.sent)
+	last, ok := mailer.Last()
+	require.True(t, ok)
+	assert.Equal(t, "Hi", last.Subject)
+
+	// temporary failure is retried after backoff
+	assert.Equal(t, map[int64]time.Duration{2: time.Second}, storage.retried)
+	// permanent failure and the last attempt go to dead letters
+	assert.Contains(t, storage.dead, int64(3))
+	assert.Contains(t, storage.dead, int64(4))
+}
+
+func TestBackoff(t *testing.T) {
+	worker := NewWorker(offlog.New(), newMemoryStorage(), mail.NewMemoryMailer(), testConfig)
+
+	assert.Equal(t, time.Second, worker.Backoff(1))
+	assert.Equal(t, 2*time.Second, worker.Backoff(2))
+	assert.Equal(t, 4*time.Second, worker.Backoff(3))
+	assert.Equal(t, 5*time.Second, worker.Backoff(4))
+	assert.Equal(t, 5*time.Second, worker.Backoff(100))
+}
+
+func TestWorker_StartStop(t *testing.T) {
+	storage := newMemoryStorage(models.OutboxEmail{ID: 1, To: "ok@example.com"})
+	mailer := mail.NewMemoryMailer()
+	worker := NewWorker(offlog.New(), storage, mailer, testConfig)
+
+	worker.Start()
+	require.Eventually(t, func() bool {
+		return len(mailer.Messages()) == 1
+	}, time.Second, 5*time.Millisecond)
+
+	// old messages are purged on start
+	storage.mu.Lock()
+	require.Len(t, storage.purged, 1)
+	assert.WithinDuration(t, time.Now().Add(-time.Hour), storage.purged[0], time.Second)
+	storage.mu.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		worker.Stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("worker didn't stop")
+	}
+}
+
+func TestProcessBatch_LastAttemptKeepsError(t *testing.T) {
+	storage := newMemoryStorage(models.OutboxEmail{ID: 1, To: "down@example.com", Attempts: 2})
+	mailer := &failingMailer{
+		MemoryMailer: mail.NewMemoryMailer(),
+		failures:     map[string]error{"down@example.com": errors.New("connection refused")},
+	}
+	worker := NewWorker(offlog.New(), storage, mailer, testConfig)
+
+	worker.ProcessBatch(context.Background())
+
+	assert.Equal(t, "connection refused", storage.dead[1])
+}
@@ -0,0 +1,458 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/kuromii5/sync-auth/internal/models"
+	"github.com/kuromii5/sync-auth/internal/repo/postgres"
+	"github.com/kuromii5/sync-auth/internal/repo/redis"
+	"github.com/kuromii5/sync-auth/internal/service/webauthn"
+	le "github.com/kuromii5/sync-auth/pkg/logger/l_err"
+)
+
+// purposes of WebAuthn ceremonies, a challenge can't be used by another flow
+const (
+	ceremonyRegister = "register"
+	ceremonyLogin    = "login"
+	ceremonyMFA      = "mfa"
+)
+
+const defaultPasskeyName = "Passkey"
+
+// BeginPasskeyRegistration returns options for navigator.credentials.create()
+func (a *Auth) BeginPasskeyRegistration(ctx context.Context, accessToken string) (webauthn.CreationOptions, error) {
+	const f = "service.BeginPasskeyRegistration"
+
+	log := a.logger(ctx).With(slog.String("func", f))
+	log.Info("starting passkey registration")
+
+	claims, err := a.authenticate(ctx, accessToken)
+	if err != nil {
+		return webauthn.CreationOptions{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	user, err := a.userProvider.UserByID(ctx, claims.UserID)
+	if err != nil {
+		log.Error("failed to get user", le.Err(err))
+
+		return webauthn.CreationOptions{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	// authenticator refuses to create the second credential for the same account
+	registered, err := a.passkeys.Passkeys(ctx, user.ID)
+	if err != nil {
+		log.Error("failed to get passkeys", le.Err(err))
+
+		return webauthn.CreationOptions{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	challenge, err := a.startCeremony(ctx, models.WebAuthnCeremony{Purpose: ceremonyRegister, UserID: user.ID})
+	if err != nil {
+		log.Error("failed to start ceremony", le.Err(err))
+
+		return webauthn.CreationOptions{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	return a.PasskeyManager.CreationOptions(challenge, user, registered), nil
+}
+
+// FinishPasskeyRegistration verifies attestation and saves the new passkey
+func (a *Auth) FinishPasskeyRegistration(ctx context.Context, accessToken, name string, credentialJSON []byte) (models.Passkey, error) {
+	const f = "service.FinishPasskeyRegistration"
+
+	log := a.logger(ctx).With(slog.String("func", f))
+	log.Info("finishing passkey registration")
+
+	claims, err := a.authenticate(ctx, accessToken)
+	if err != nil {
+		return models.Passkey{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	challenge, err := webauthn.ParseRegistrationChallenge(credentialJSON)
+	if err != nil {
+		log.Warn("failed to parse credential", le.Err(err))
+
+		return models.Passkey{}, fmt.Errorf("%s:%w: %w", f, ErrInvalidPasskey, err)
+	}
+
+	ceremony, err := a.consumeCeremony(ctx, challenge, ceremonyRegister)
+	if err != nil {
+		log.Warn("ceremony is not found", le.Err(err))
+
+		return models.Passkey{}, fmt.Errorf("%s:%w", f, err)
+	}
+	if ceremony.UserID != claims.UserID {
+		log.Warn("ceremony was started by another user")
+
+		return models.Passkey{}, fmt.Errorf("%s:%w", f, ErrInvalidPasskey)
+	}
+
+	passkey, err := a.PasskeyManager.VerifyRegistration(credentialJSON, ceremony.Challenge)
+	if err != nil {
+		log.Warn("failed to verify registration", le.Err(err))
+
+		return models.Passkey{}, fmt.Errorf("%s:%w: %w", f, ErrInvalidPasskey, err)
+	}
+
+	passkey.UserID = claims.UserID
+	passkey.Name = name
+	if passkey.Name == "" {
+		passkey.Name = defaultPasskeyName
+	}
+
+	if err := a.passkeys.SavePasskey(ctx, passkey); err != nil {
+		if errors.Is(err, postgres.ErrPasskeyExists) {
+			log.Warn("passkey is already registered", le.Err(err))
+
+			return models.Passkey{}, fmt.Errorf("%s:%w", f, ErrPasskeyExists)
+		}
+		log.Error("failed to save passkey", le.Err(err))
+
+		return models.Passkey{}, fmt.Errorf("%s:%w", f, err)
+	}
+	passkey.CreatedAt = time.Now()
+	passkey.LastUsedAt = passkey.CreatedAt
+
+	log.Info("passkey registered", slog.Int("user_id", int(claims.UserID)))
+
+	return passkey, nil
+}
+
+func (a *Auth) ListPasskeys(ctx context.Context, accessToken string) ([]models.Passkey, error) {
+	const f = "service.ListPasskeys"
+
+	log := a.logger(ctx).With(slog.String("func", f))
+
+	claims, err := a.authenticate(ctx, accessToken)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", f, err)
+	}
+
+	passkeys, err := a.passkeys.Passkeys(ctx, claims.UserID)
+	if err != nil {
+		log.Error("failed to get passkeys", le.Err(err))
+
+		return nil, fmt.Errorf("%s:%w", f, err)
+	}
+
+	return passkeys, nil
+}
+
+func (a *Auth) DeletePasskey(ctx context.Context, accessToken string, id []byte) error {
+	const f = "service.DeletePasskey"
+
+	log := a.logger(ctx).With(slog.String("func", f))
+	log.Info("deleting passkey")
+
+	claims, err := a.authenticate(ctx, accessToken)
+	if err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	if err := a.passkeys.DeletePasskey(ctx, claims.UserID, id); err != nil {
+		if errors.Is(err, postgres.ErrPasskeyNotFound) {
+			log.Warn("passkey not found", le.Err(err))
+
+			return fmt.Errorf("%s:%w", f, ErrPasskeyNotFound)
+		}
+		log.Error("failed to delete passkey", le.Err(err))
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	log.Info("passkey deleted", slog.Int("user_id", int(claims.UserID)))
+
+	return nil
+}
+
+// BeginPasskeyLogin returns options for navigator.credentials.get() without
+// allowed credentials, the user picks any discoverable passkey
+func (a *Auth) BeginPasskeyLogin(ctx context.Context) (webauthn.RequestOptions, error) {
+	const f = "service.BeginPasskeyLogin"
+
+	log := a.logger(ctx).With(slog.String("func", f))
+	log.Info("starting passkey login")
+
+	challenge, err := a.startCeremony(ctx, models.WebAuthnCeremony{Purpose: ceremonyLogin})
+	if err != nil {
+		log.Error("failed to start ceremony", le.Err(err))
+
+		return webauthn.RequestOptions{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	return a.PasskeyManager.RequestOptions(challenge, nil, "required"), nil
+}
+
+// FinishPasskeyLogin logs user in with passkey. User verification is required,
+// so the passkey is both factors and TOTP is not asked
+func (a *Auth) FinishPasskeyLogin(ctx context.Context, credentialJSON []byte, fingerprint string) error {
+	const f = "service.FinishPasskeyLogin"
+
+	log := a.logger(ctx).With(slog.String("func", f))
+	log.Info("finishing passkey login")
+
+	assertion, err := webauthn.ParseAssertion(credentialJSON)
+	if err != nil {
+		log.Warn("failed to parse assertion", le.Err(err))
+
+		return fmt.Errorf("%s:%w: %w", f, ErrInvalidPasskey, err)
+	}
+
+	ceremony, err := a.consumeCeremony(ctx, assertion.Challenge, ceremonyLogin)
+	if err != nil {
+		log.Warn("ceremony is not found", le.Err(err))
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	passkey, err := a.passkeyByID(ctx, assertion.CredentialID)
+	if err != nil {
+		log.Warn("failed to get passkey", le.Err(err))
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	userID, err := webauthn.ParseUserHandle(assertion.UserHandle)
+	if err != nil || userID != passkey.UserID {
+		log.Warn("user handle doesn't match passkey owner")
+
+		return fmt.Errorf("%s:%w", f, ErrInvalidPasskey)
+	}
+
+	if err := a.verifyPasskey(ctx, assertion, ceremony.Challenge, passkey, true); err != nil {
+		log.Warn("failed to verify passkey", le.Err(err))
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	user, err := a.userProvider.UserByID(ctx, passkey.UserID)
+	if err != nil {
+		log.Error("failed to get user", le.Err(err))
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	if err := a.startSession(ctx, user, fingerprint); err != nil {
+		log.Error("failed to start session", le.Err(err))
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	log.Info("user logged in with passkey", slog.Int("user_id", int(user.ID)))
+
+	return nil
+}
+
+// BeginPasskeyMFA returns options for navigator.credentials.get() with passkeys
+// of the user who has to pass MFA challenge
+func (a *Auth) BeginPasskeyMFA(ctx context.Context, mfaToken string) (webauthn.RequestOptions, error) {
+	const f = "service.BeginPasskeyMFA"
+
+	log := a.logger(ctx).With(slog.String("func", f))
+	log.Info("starting passkey second factor")
+
+	tokenHash := hashToken(mfaToken)
+
+	login, err := a.mfaLogins.MFALogin(ctx, tokenHash)
+	if err != nil {
+		if errors.Is(err, redis.ErrMFANotFound) {
+			log.Warn("mfa challenge expired or doesn't exist", le.Err(err))
+
+			return webauthn.RequestOptions{}, fmt.Errorf("%s:%w", f, ErrInvalidMFAToken)
+		}
+		log.Error("failed to get mfa challenge", le.Err(err))
+
+		return webauthn.RequestOptions{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	passkeys, err := a.passkeys.Passkeys(ctx, login.UserID)
+	if err != nil {
+		log.Error("failed to get passkeys", le.Err(err))
+
+		return webauthn.RequestOptions{}, fmt.Errorf("%s:%w", f, err)
+	}
+	if len(passkeys) == 0 {
+		log.Warn("user has no passkeys")
+
+		return webauthn.RequestOptions{}, fmt.Errorf("%s:%w", f, ErrPasskeyNotFound)
+	}
+
+	challenge, err := a.startCeremony(ctx, models.WebAuthnCeremony{
+		Purpose:      ceremonyMFA,
+		UserID:       login.UserID,
+		MFATokenHash: tokenHash,
+	})
+	if err != nil {
+		log.Error("failed to start ceremony", le.Err(err))
+
+		return webauthn.RequestOptions{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	return a.PasskeyManager.RequestOptions(challenge, passkeys, "preferred"), nil
+}
+
+// FinishPasskeyMFA completes login started with MFA challenge using passkey
+func (a *Auth) FinishPasskeyMFA(ctx context.Context, mfaToken string, credentialJSON []byte) error {
+	const f = "service.FinishPasskeyMFA"
+
+	log := a.logger(ctx).With(slog.String("func", f))
+	log.Info("verifying passkey second factor")
+
+	tokenHash := hashToken(mfaToken)
+
+	assertion, err := webauthn.ParseAssertion(credentialJSON)
+	if err != nil {
+		log.Warn("failed to parse assertion", le.Err(err))
+
+		return fmt.Errorf("%s:%w: %w", f, ErrInvalidPasskey, err)
+	}
+
+	ceremony, err := a.consumeCeremony(ctx, assertion.Challenge, ceremonyMFA)
+	if err != nil {
+		log.Warn("ceremony is not found", le.Err(err))
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
+	if ceremony.MFATokenHash != tokenHash {
+		log.Warn("ceremony was started for another mfa challenge")
+
+		return fmt.Errorf("%s:%w", f, ErrInvalidPasskey)
+	}
+
+	login, err := a.mfaLogins.MFALogin(ctx, tokenHash)
+	if err != nil {
+		if errors.Is(err, redis.ErrMFANotFound) {
+			log.Warn("mfa challenge expired or doesn't exist", le.Err(err))
+
+			return fmt.Errorf("%s:%w", f, ErrInvalidMFAToken)
+		}
+		log.Error("failed to get mfa challenge", le.Err(err))
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	passkey, err := a.passkeyByID(ctx, assertion.CredentialID)
+	if err == nil && passkey.UserID != login.UserID {
+		err = ErrInvalidPasskey
+	}
+	if err == nil {
+		err = a.verifyPasskey(ctx, assertion, ceremony.Challenge, passkey, false)
+	}
+	if err != nil {
+		if !errors.Is(err, ErrInvalidPasskey) {
+			log.Error("failed to verify passkey", le.Err(err))
+
+			return fmt.Errorf("%s:%w", f, err)
+		}
+		log.Warn("passkey is not accepted", le.Err(err))
+
+		if err := a.failMFAAttempt(ctx, tokenHash); err != nil {
+			log.Error("failed to count mfa attempt", le.Err(err))
+
+			return fmt.Errorf("%s:%w", f, err)
+		}
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	// only one of concurrent requests deletes the challenge
+	if err := a.mfaLogins.DeleteMFALogin(ctx, tokenHash); err != nil {
+		if errors.Is(err, redis.ErrMFANotFound) {
+			log.Warn("mfa challenge was already completed", le.Err(err))
+
+			return fmt.Errorf("%s:%w", f, ErrInvalidMFAToken)
+		}
+		log.Error("failed to delete mfa challenge", le.Err(err))
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	user, err := a.userProvider.UserByID(ctx, login.UserID)
+	if err != nil {
+		log.Error("failed to get user", le.Err(err))
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	if err := a.startSession(ctx, user, login.Fingerprint); err != nil {
+		log.Error("failed to start session", le.Err(err))
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	log.Info("user logged in with passkey as second factor", slog.Int("user_id", int(user.ID)))
+
+	return nil
+}
+
+// startCeremony saves the ceremony until the authenticator responds and returns its challenge
+func (a *Auth) startCeremony(ctx context.Context, ceremony models.WebAuthnCeremony) (string, error) {
+	challenge, err := a.PasskeyManager.Challenge()
+	if err != nil {
+		return "", err
+	}
+
+	if err := a.ceremonies.SetWebAuthnCeremony(ctx, challenge, ceremony, a.PasskeyManager.Timeout); err != nil {
+		return "", err
+	}
+
+	return challenge, nil
+}
+
+func (a *Auth) consumeCeremony(ctx context.Context, challenge, purpose string) (models.WebAuthnCeremony, error) {
+	ceremony, err := a.ceremonies.ConsumeWebAuthnCeremony(ctx, challenge)
+	if err != nil {
+		if errors.Is(err, redis.ErrCeremonyNotFound) {
+			return models.WebAuthnCeremony{}, ErrInvalidPasskey
+		}
+
+		return models.WebAuthnCeremony{}, err
+	}
+	if ceremony.Purpose != purpose {
+		return models.WebAuthnCeremony{}, ErrInvalidPasskey
+	}
+
+	return ceremony, nil
+}
+
+func (a *Auth) passkeyByID(ctx context.Context, id []byte) (models.Passkey, error) {
+	passkey, err := a.passkeys.PasskeyByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, postgres.ErrPasskeyNotFound) {
+			return models.Passkey{}, ErrInvalidPasskey
+		}
+
+		return models.Passkey{}, err
+	}
+
+	return passkey, nil
+}
+
+// verifyPasskey checks the assertion against the challenge of the consumed ceremony
+// and saves the new signature counter. Counter that didn't grow means the credential may be cloned
+func (a *Auth) verifyPasskey(ctx context.Context, assertion webauthn.Assertion, challenge string, passkey models.Passkey, requireUV bool) error {
+	signCount, err := a.PasskeyManager.VerifyAssertion(assertion, challenge, passkey, requireUV)
+	if err == nil {
+		err = a.passkeys.UpdatePasskeySignCount(ctx, passkey.ID, signCount)
+		if err != nil && !errors.Is(err, postgres.ErrStaleSignCount) {
+			return err
+		}
+	}
+
+	if errors.Is(err, webauthn.ErrSignCount) || errors.Is(err, postgres.ErrStaleSignCount) {
+		a.securityEvents.Emit(ctx, models.SecurityEvent{
+			Type:   models.EventPasskeySignCount,
+			UserID: passkey.UserID,
+			At:     time.Now(),
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidPasskey, err)
+	}
+
+	return nil
+}
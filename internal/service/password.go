@@ -0,0 +1,162 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/kuromii5/sync-auth/internal/repo/postgres"
+	"github.com/kuromii5/sync-auth/internal/repo/redis"
+	"github.com/kuromii5/sync-auth/pkg/hasher"
+	le "github.com/kuromii5/sync-auth/pkg/logger/l_err"
+)
+
+// RequestPasswordReset emails one-time reset link. Unknown email is not
+// reported to the caller, so it can't be used to find registered users
+func (a *Auth) RequestPasswordReset(ctx context.Context, email string) error {
+	const f = "service.RequestPasswordReset"
+
+	log := a.logger(ctx).With(slog.String("func", f))
+	log.Info("requesting password reset")
+
+	user, err := a.userProvider.UserByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, postgres.ErrUserNotFound) {
+			log.Warn("password reset requested for unknown email")
+
+			return nil
+		}
+		log.Error("failed to get user", le.Err(err))
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		log.Error("failed to generate reset token", le.Err(err))
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	// only hash is stored, so tokens can't be used if storage leaks
+	if err := a.passwordResets.SetPasswordResetToken(ctx, hashToken(token), user.ID, a.VerificationManager.PasswordResetTTL); err != nil {
+		log.Error("failed to save reset token", le.Err(err))
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	if err := a.VerificationManager.SendPasswordReset(ctx, user.Email, locale(ctx, user), token); err != nil {
+		log.Error("failed to send reset link", le.Err(err))
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	log.Info("password reset link was sent", slog.Int("user_id", int(user.ID)))
+
+	return nil
+}
+
+// ResetPassword sets new password by reset token and signs user out on every device
+func (a *Auth) ResetPassword(ctx context.Context, token, password string) error {
+	const f = "service.ResetPassword"
+
+	log := a.logger(ctx).With(slog.String("func", f))
+	log.Info("resetting password")
+
+	userID, err := a.passwordResets.ConsumePasswordResetToken(ctx, hashToken(token))
+	if err != nil {
+		if errors.Is(err, redis.ErrResetNotFound) {
+			log.Warn("invalid reset token", le.Err(err))
+
+			return fmt.Errorf("%s:%w", f, ErrInvalidResetToken)
+		}
+		log.Error("failed to get reset token", le.Err(err))
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	hash, err := hasher.HashPassword(password)
+	if err != nil {
+		log.Error("failed to hash password", le.Err(err))
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	if err := a.userSaver.UpdatePassword(ctx, userID, hash); err != nil {
+		log.Error("failed to update password", le.Err(err))
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	if err := a.accessTokenManager.RevokeAllTokens(ctx, userID); err != nil {
+		log.Error("failed to revoke tokens", le.Err(err))
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	log.Info("password was reset", slog.Int("user_id", int(userID)))
+
+	return nil
+}
+
+// ChangePassword sets new password of the signed in user. If signOutOthers
+// is set, every session except the current one is revoked
+func (a *Auth) ChangePassword(ctx context.Context, accessToken, currentPassword, newPassword string, signOutOthers bool) (int, error) {
+	const f = "service.ChangePassword"
+
+	log := a.logger(ctx).With(slog.String("func", f))
+	log.Info("changing password")
+
+	claims, err := a.authenticate(ctx, accessToken)
+	if err != nil {
+		return 0, fmt.Errorf("%s:%w", f, err)
+	}
+
+	// current session of tokens issued before sid is unknown, check it before password is changed
+	if signOutOthers && claims.SessionID == "" {
+		log.Warn("access token has no session id", slog.Int("user_id", int(claims.UserID)))
+
+		return 0, fmt.Errorf("%s:%w", f, ErrUnauthenticated)
+	}
+
+	user, err := a.userProvider.UserByID(ctx, claims.UserID)
+	if err != nil {
+		log.Error("failed to get user", le.Err(err))
+
+		return 0, fmt.Errorf("%s:%w", f, err)
+	}
+
+	if err := hasher.CheckPassword(currentPassword, user.PasswordHash); err != nil {
+		log.Warn("invalid current password", le.Err(err))
+
+		return 0, fmt.Errorf("%s:%w", f, ErrInvalidCreds)
+	}
+
+	hash, err := hasher.HashPassword(newPassword)
+	if err != nil {
+		log.Error("failed to hash password", le.Err(err))
+
+		return 0, fmt.Errorf("%s:%w", f, err)
+	}
+
+	if err := a.userSaver.UpdatePassword(ctx, user.ID, hash); err != nil {
+		log.Error("failed to update password", le.Err(err))
+
+		return 0, fmt.Errorf("%s:%w", f, err)
+	}
+
+	revoked := 0
+	if signOutOthers {
+		revoked, err = a.revokeOtherSessions(ctx, user.ID, claims.SessionID)
+		if err != nil {
+			log.Error("failed to revoke other sessions", le.Err(err))
+
+			return revoked, fmt.Errorf("%s:%w", f, err)
+		}
+	}
+
+	log.Info("password changed successfully", slog.Int("user_id", int(user.ID)), slog.Int("revoked_sessions", revoked))
+
+	return revoked, nil
+}
@@ -0,0 +1,219 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/kuromii5/sync-auth/internal/models"
+	"github.com/kuromii5/sync-auth/internal/repo/postgres"
+	"github.com/kuromii5/sync-auth/internal/repo/redis"
+	le "github.com/kuromii5/sync-auth/pkg/logger/l_err"
+)
+
+// StartPasswordlessLogin emails one-time code and magic link. Unknown email and
+// send limits are not reported to the caller, so it can't be used to find
+// registered users
+func (a *Auth) StartPasswordlessLogin(ctx context.Context, email string) (models.VerifyEmailResp, error) {
+	const f = "service.StartPasswordlessLogin"
+
+	log := a.logger(ctx).With(slog.String("func", f))
+	log.Info("starting passwordless login")
+
+	magicLinks := a.VerificationManager.MagicLinks
+	response := models.VerifyEmailResp{
+		Status:      "code sent",
+		CodeTTL:     magicLinks.TTL,
+		ResendAfter: a.VerificationManager.CodeLimits.ResendCooldown,
+	}
+
+	user, err := a.userProvider.UserByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, postgres.ErrUserNotFound) {
+			log.Warn("passwordless login requested for unknown email")
+
+			return response, nil
+		}
+		log.Error("failed to get user", le.Err(err))
+
+		return models.VerifyEmailResp{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	limit, err := a.allowCodeSend(ctx, user.ID, passwordlessCodeFlow)
+	if err != nil {
+		// limit is reported only in logs, the error would tell that email exists
+		if errors.Is(err, ErrTooManyRequests) {
+			log.Warn("code send is limited", le.Err(err))
+
+			return response, nil
+		}
+		log.Error("failed to check code send limit", le.Err(err))
+
+		return models.VerifyEmailResp{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	code, err := verificationCode()
+	if err != nil {
+		log.Error("failed to generate code", le.Err(err))
+
+		return models.VerifyEmailResp{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	// nonce makes the link single-use, it is forgotten after login
+	nonce, err := randomToken()
+	if err != nil {
+		log.Error("failed to generate nonce", le.Err(err))
+
+		return models.VerifyEmailResp{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	login := models.PasswordlessLogin{Code: code, Nonce: nonce}
+	if err := a.passwordlessLogins.SetPasswordlessLogin(ctx, user.ID, login, magicLinks.TTL); err != nil {
+		log.Error("failed to save passwordless login", le.Err(err))
+
+		return models.VerifyEmailResp{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	token := magicLinks.Token(user.ID, nonce)
+	if err := a.VerificationManager.SendPasswordlessLogin(ctx, user.Email, locale(ctx, user), code, token); err != nil {
+		log.Error("failed to send login code", le.Err(err))
+
+		return models.VerifyEmailResp{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	log.Info("passwordless login code was sent", slog.Int("user_id", int(user.ID)))
+
+	response.ResendAfter = limit.RetryAfter
+
+	return response, nil
+}
+
+// CompletePasswordlessLogin logs user in by email and code or by magic link token.
+// Email of the user becomes verified because the user has received the email
+func (a *Auth) CompletePasswordlessLogin(ctx context.Context, email string, code int32, token, fingerprint string) (models.MFAChallenge, error) {
+	const f = "service.CompletePasswordlessLogin"
+
+	log := a.logger(ctx).With(slog.String("func", f))
+	log.Info("completing passwordless login")
+
+	var (
+		userID int32
+		nonce  string
+		err    error
+	)
+	if token != "" {
+		userID, nonce, err = a.VerificationManager.MagicLinks.Parse(token)
+		if err != nil {
+			log.Warn("invalid magic link", le.Err(err))
+
+			return models.MFAChallenge{}, fmt.Errorf("%s:%w", f, ErrInvalidLoginCode)
+		}
+	} else {
+		user, err := a.userProvider.UserByEmail(ctx, email)
+		if err != nil {
+			if errors.Is(err, postgres.ErrUserNotFound) {
+				log.Warn("passwordless login for unknown email")
+
+				return models.MFAChallenge{}, fmt.Errorf("%s:%w", f, ErrInvalidLoginCode)
+			}
+			log.Error("failed to get user", le.Err(err))
+
+			return models.MFAChallenge{}, fmt.Errorf("%s:%w", f, err)
+		}
+		userID = user.ID
+	}
+
+	login, err := a.passwordlessLogins.PasswordlessLogin(ctx, userID)
+	if err != nil {
+		if errors.Is(err, redis.ErrLoginNotFound) {
+			log.Warn("passwordless login expired or not started", le.Err(err))
+
+			return models.MFAChallenge{}, fmt.Errorf("%s:%w", f, ErrInvalidLoginCode)
+		}
+		log.Error("failed to get passwordless login", le.Err(err))
+
+		return models.MFAChallenge{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	if token != "" {
+		if subtle.ConstantTimeCompare([]byte(nonce), []byte(login.Nonce)) != 1 {
+			log.Warn("magic link was replaced by a newer one")
+
+			return models.MFAChallenge{}, fmt.Errorf("%s:%w", f, ErrInvalidLoginCode)
+		}
+	} else if login.Code != code {
+		log.Warn("user entered incorrect login code")
+
+		if err := a.failPasswordlessAttempt(ctx, userID); err != nil {
+			log.Error("failed to count login attempt", le.Err(err))
+
+			return models.MFAChallenge{}, fmt.Errorf("%s:%w", f, err)
+		}
+
+		return models.MFAChallenge{}, fmt.Errorf("%s:%w", f, ErrInvalidLoginCode)
+	}
+
+	// only one of concurrent requests deletes the login
+	if err := a.passwordlessLogins.DeletePasswordlessLogin(ctx, userID); err != nil {
+		if errors.Is(err, redis.ErrLoginNotFound) {
+			log.Warn("passwordless login was already completed", le.Err(err))
+
+			return models.MFAChallenge{}, fmt.Errorf("%s:%w", f, ErrInvalidLoginCode)
+		}
+		log.Error("failed to delete passwordless login", le.Err(err))
+
+		return models.MFAChallenge{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	user, err := a.userProvider.UserByID(ctx, userID)
+	if err != nil {
+		log.Error("failed to get user", le.Err(err))
+
+		return models.MFAChallenge{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	if !user.EmailVerified {
+		if err := a.userSaver.VerifyUser(ctx, userID); err != nil {
+			log.Error("failed to verify user email", le.Err(err))
+
+			return models.MFAChallenge{}, fmt.Errorf("%s:%w", f, err)
+		}
+		user.EmailVerified = true
+	}
+
+	challenge, err := a.login(ctx, user, fingerprint)
+	if err != nil {
+		log.Error("failed to log in", le.Err(err))
+
+		return models.MFAChallenge{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	if challenge.Token != "" {
+		log.Info("second factor is required", slog.Int("user_id", int(user.ID)))
+
+		return challenge, nil
+	}
+
+	log.Info("user logged in without password", slog.Int("user_id", int(user.ID)))
+
+	return models.MFAChallenge{}, nil
+}
+
+// failPasswordlessAttempt counts wrong code and cancels the login when attempts are over
+func (a *Auth) failPasswordlessAttempt(ctx context.Context, userID int32) error {
+	failed, err := a.passwordlessLogins.FailPasswordlessAttempt(ctx, userID, a.VerificationManager.MagicLinks.TTL)
+	if err != nil {
+		return err
+	}
+
+	if failed >= a.VerificationManager.CodeLimits.MaxAttempts {
+		err := a.passwordlessLogins.DeletePasswordlessLogin(ctx, userID)
+		if err != nil && !errors.Is(err, redis.ErrLoginNotFound) {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -2,36 +2,72 @@ package service
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"log/slog"
 	"time"
 
 	"github.com/kuromii5/sync-auth/internal/models"
+	"github.com/kuromii5/sync-auth/internal/service/cookies"
+	"github.com/kuromii5/sync-auth/internal/service/mfa"
 	"github.com/kuromii5/sync-auth/internal/service/verification"
-	"golang.org/x/oauth2"
+	"github.com/kuromii5/sync-auth/internal/service/webauthn"
+	"github.com/kuromii5/sync-auth/pkg/logger/ctxlog"
 )
 
 var (
-	ErrInvalidCreds       = errors.New("invalid credentials")
-	ErrUserExists         = errors.New("user already exists")
-	ErrUserNotFound       = errors.New("user not found")
-	ErrInvalidOAuthClient = errors.New("oauth client not found")
+	ErrInvalidCreds      = errors.New("invalid credentials")
+	ErrUserExists        = errors.New("user already exists")
+	ErrUserNotFound      = errors.New("user not found")
+	ErrEmailNotVerified  = errors.New("email is not verified by oauth provider")
+	ErrIdentityNotLinked = errors.New("account with this email exists, identity must be linked by its owner")
+	ErrLastLoginMethod   = errors.New("can't unlink the only login method")
+	ErrInvalidResetToken = errors.New("invalid or expired password reset token")
+	ErrUnauthenticated   = errors.New("invalid access token")
+	ErrTooManyRequests   = errors.New("too many requests, try again later")
+	ErrInvalidLoginCode  = errors.New("invalid or expired login code")
+	ErrMFAEnabled        = errors.New("two-factor authentication is already enabled")
+	ErrMFANotEnabled     = errors.New("two-factor authentication is not enabled")
+	ErrInvalidMFACode    = errors.New("invalid two-factor code")
+	ErrInvalidMFAToken   = errors.New("invalid or expired mfa token")
+	ErrInvalidPasskey    = errors.New("passkey verification failed")
+	ErrPasskeyExists     = errors.New("passkey is already registered")
+	ErrPasskeyNotFound   = errors.New("passkey not found")
 )
 
 type Auth struct {
 	log                 *slog.Logger
 	VerificationManager *verification.VerificationManager
+	MFAManager          *mfa.MFAManager
+	PasskeyManager      *webauthn.PasskeyManager
+	Cookies             *cookies.Policy
 	userSaver           UserSaver
 	userProvider        UserProvider
 	accessTokenManager  AccessTokenManager
 	refreshTokenManager RefreshTokenManager
+	signingKeyRotator   SigningKeyRotator
+	sessionManager      SessionManager
 	codeManager         CodeManager
+	passwordResets      PasswordResetManager
+	pendingEmails       PendingEmailManager
+	passwordlessLogins  PasswordlessLoginManager
+	totps               TOTPManager
+	mfaLogins           MFALoginManager
+	passkeys            PasskeyStorage
+	ceremonies          WebAuthnCeremonyManager
 	oAuthManager        OAuthManager
+	identityManager     IdentityManager
+	securityEvents      SecurityEventEmitter
 }
 
 type UserSaver interface {
-	SaveUser(ctx context.Context, email string, hash []byte) (int32, error)
+	SaveUser(ctx context.Context, email string, hash []byte, locale string) (int32, error)
 	VerifyUser(ctx context.Context, userID int32) error
+	UpdatePassword(ctx context.Context, userID int32, passwordHash []byte) error
+	UpdateEmail(ctx context.Context, userID int32, email string) error
 }
 type UserProvider interface {
 	UserByEmail(ctx context.Context, email string) (models.User, error)
@@ -39,35 +75,128 @@ type UserProvider interface {
 }
 
 type AccessTokenManager interface {
-	NewAccessToken(ctx context.Context, userID int32) (string, error)
-	ValidateAccessToken(ctx context.Context, token string) (int32, error)
+	NewAccessToken(ctx context.Context, user models.User, sessionID string) (string, error)
+	ValidateAccessToken(ctx context.Context, token string) (models.AccessClaims, error)
+	RevokeAccessToken(ctx context.Context, token string) error
+	RevokeSession(ctx context.Context, sessionID string) error
+	RevokeAllTokens(ctx context.Context, userID int32) error
+	JWKS() []models.JWK
 }
 type RefreshTokenManager interface {
-	NewRefreshToken(ctx context.Context, userID int32, fingerprint string) (string, error)
+	NewRefreshToken(ctx context.Context, userID int32, fingerprint string) (models.RefreshToken, error)
 	ValidateRefreshToken(ctx context.Context, token string, fingerprint string) (int32, error)
+	RotateRefreshToken(ctx context.Context, token string, fingerprint string) (models.RefreshToken, error)
+	RefreshTokenClaims(ctx context.Context, token, fingerprint string) (models.RefreshClaims, error)
 	Delete(ctx context.Context, userID int32, fingerprint string) error
 }
 
+type SessionManager interface {
+	Sessions(ctx context.Context, userID int32) ([]models.Session, error)
+	DeleteSession(ctx context.Context, userID int32, sessionID string) error
+}
+
+type SigningKeyRotator interface {
+	RotateSigningKey(ctx context.Context, keyFile string) (models.KeyRotation, error)
+}
+
 type OAuthManager interface {
-	ConfigByProvider(provider string) *oauth2.Config
-	GetGithubEmail(ctx context.Context, accessToken string) (string, error)
+	AuthURL(ctx context.Context, provider string, purpose models.OAuthPurpose, userID int32) (models.OAuthRedirect, error)
+	Exchange(ctx context.Context, provider, code, state string, purpose models.OAuthPurpose, userID int32) (models.ExternalIdentity, error)
+}
+
+type PendingEmailManager interface {
+	SetPendingEmail(ctx context.Context, userID int32, pending models.PendingEmail, expires time.Duration) error
+	PendingEmail(ctx context.Context, userID int32) (models.PendingEmail, error)
+	DeletePendingEmail(ctx context.Context, userID int32) error
+	FailPendingEmailAttempt(ctx context.Context, userID int32, expires time.Duration) (int, error)
+}
+
+type PasswordlessLoginManager interface {
+	SetPasswordlessLogin(ctx context.Context, userID int32, login models.PasswordlessLogin, expires time.Duration) error
+	PasswordlessLogin(ctx context.Context, userID int32) (models.PasswordlessLogin, error)
+	DeletePasswordlessLogin(ctx context.Context, userID int32) error
+	FailPasswordlessAttempt(ctx context.Context, userID int32, expires time.Duration) (int, error)
+}
+
+type TOTPManager interface {
+	SaveTOTPSecret(ctx context.Context, userID int32, secret string) error
+	TOTP(ctx context.Context, userID int32) (models.TOTP, error)
+	EnableTOTP(ctx context.Context, userID int32, recoveryCodeHashes []string) error
+	DisableTOTP(ctx context.Context, userID int32) error
+	ReplaceRecoveryCodes(ctx context.Context, userID int32, recoveryCodeHashes []string) error
+	HasRecoveryCode(ctx context.Context, userID int32, codeHash string) (bool, error)
+	UseRecoveryCode(ctx context.Context, userID int32, codeHash string) error
+}
+
+type MFALoginManager interface {
+	SetMFALogin(ctx context.Context, tokenHash string, login models.MFALogin, expires time.Duration) error
+	MFALogin(ctx context.Context, tokenHash string) (models.MFALogin, error)
+	DeleteMFALogin(ctx context.Context, tokenHash string) error
+	FailMFAAttempt(ctx context.Context, tokenHash string, expires time.Duration) (int, error)
+	UseTOTPStep(ctx context.Context, userID int32, step uint64, expires time.Duration) (bool, error)
+}
+
+type PasskeyStorage interface {
+	SavePasskey(ctx context.Context, passkey models.Passkey) error
+	Passkeys(ctx context.Context, userID int32) ([]models.Passkey, error)
+	PasskeyByID(ctx context.Context, id []byte) (models.Passkey, error)
+	UpdatePasskeySignCount(ctx context.Context, id []byte, signCount uint32) error
+	DeletePasskey(ctx context.Context, userID int32, id []byte) error
+}
+
+type WebAuthnCeremonyManager interface {
+	SetWebAuthnCeremony(ctx context.Context, challenge string, ceremony models.WebAuthnCeremony, expires time.Duration) error
+	ConsumeWebAuthnCeremony(ctx context.Context, challenge string) (models.WebAuthnCeremony, error)
+}
+
+type PasswordResetManager interface {
+	SetPasswordResetToken(ctx context.Context, tokenHash string, userID int32, expires time.Duration) error
+	ConsumePasswordResetToken(ctx context.Context, tokenHash string) (int32, error)
+}
+
+type IdentityManager interface {
+	UserByIdentity(ctx context.Context, provider, subject string) (models.User, error)
+	SaveUserWithIdentity(ctx context.Context, identity models.ExternalIdentity) (int32, error)
+	LinkIdentity(ctx context.Context, userID int32, identity models.ExternalIdentity) error
+	UnlinkIdentity(ctx context.Context, userID int32, provider string) error
+	Identities(ctx context.Context, userID int32) ([]models.Identity, error)
 }
 
 type CodeManager interface {
 	SetCode(ctx context.Context, code, userID int32, expires time.Duration) error
 	Code(ctx context.Context, userID int32) (int32, error)
 	DeleteCode(ctx context.Context, userID int32) error
+	AllowCodeSend(ctx context.Context, userID int32, flow string, cooldown time.Duration, dailyLimit int) (models.CodeSendLimit, error)
+	FailCodeAttempt(ctx context.Context, userID int32, expires time.Duration) (int, error)
+}
+
+type SecurityEventEmitter interface {
+	Emit(ctx context.Context, event models.SecurityEvent)
 }
 
 func NewAuthService(
 	log *slog.Logger,
 	VerificationManager *verification.VerificationManager,
+	MFAManager *mfa.MFAManager,
+	PasskeyManager *webauthn.PasskeyManager,
+	Cookies *cookies.Policy,
 	userSaver UserSaver,
 	userProvider UserProvider,
 	accessTokenManager AccessTokenManager,
 	refreshTokenManager RefreshTokenManager,
+	signingKeyRotator SigningKeyRotator,
+	sessionManager SessionManager,
 	codeManager CodeManager,
+	passwordResets PasswordResetManager,
+	pendingEmails PendingEmailManager,
+	passwordlessLogins PasswordlessLoginManager,
+	totps TOTPManager,
+	mfaLogins MFALoginManager,
+	passkeys PasskeyStorage,
+	ceremonies WebAuthnCeremonyManager,
 	oAuthManager OAuthManager,
+	identityManager IdentityManager,
+	securityEvents SecurityEventEmitter,
 ) *Auth {
 	return &Auth{
 		log:                 log,
@@ -75,8 +204,44 @@ func NewAuthService(
 		userProvider:        userProvider,
 		accessTokenManager:  accessTokenManager,
 		refreshTokenManager: refreshTokenManager,
+		signingKeyRotator:   signingKeyRotator,
+		sessionManager:      sessionManager,
 		VerificationManager: VerificationManager,
+		MFAManager:          MFAManager,
+		PasskeyManager:      PasskeyManager,
+		Cookies:             Cookies,
 		codeManager:         codeManager,
+		passwordResets:      passwordResets,
+		pendingEmails:       pendingEmails,
+		passwordlessLogins:  passwordlessLogins,
+		totps:               totps,
+		mfaLogins:           mfaLogins,
+		passkeys:            passkeys,
+		ceremonies:          ceremonies,
 		oAuthManager:        oAuthManager,
+		identityManager:     identityManager,
+		securityEvents:      securityEvents,
 	}
 }
+
+// logger returns logger of the request, so its lines have the request id
+func (a *Auth) logger(ctx context.Context) *slog.Logger {
+	return ctxlog.From(ctx, a.log)
+}
+
+// randomToken returns random url-safe token for links and challenges
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns SHA-256 of the token, only hashes of tokens are stored
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/kuromii5/sync-auth/internal/models"
+	le "github.com/kuromii5/sync-auth/pkg/logger/l_err"
+)
+
+func (a *Auth) ListSessions(ctx context.Context, accessToken string) ([]models.Session, error) {
+	const f = "service.ListSessions"
+
+	log := a.logger(ctx).With(slog.String("func", f))
+	log.Info("listing user sessions")
+
+	claims, err := a.authenticate(ctx, accessToken)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", f, err)
+	}
+	userID := claims.UserID
+
+	sessions, err := a.sessionManager.Sessions(ctx, userID)
+	if err != nil {
+		log.Error("failed to get sessions", le.Err(err))
+
+		return nil, fmt.Errorf("%s:%w", f, err)
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == claims.SessionID
+	}
+
+	log.Info("sessions listed successfully", slog.Int("user_id", int(userID)), slog.Int("count", len(sessions)))
+
+	return sessions, nil
+}
+
+func (a *Auth) RevokeSession(ctx context.Context, accessToken, sessionID string) error {
+	const f = "service.RevokeSession"
+
+	log := a.logger(ctx).With(slog.String("func", f))
+	log.Info("revoking user session")
+
+	claims, err := a.authenticate(ctx, accessToken)
+	if err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+	userID := claims.UserID
+
+	if err := a.sessionManager.DeleteSession(ctx, userID, sessionID); err != nil {
+		log.Warn("failed to delete session", le.Err(err))
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	if err := a.accessTokenManager.RevokeSession(ctx, sessionID); err != nil {
+		log.Error("failed to revoke access tokens of session", le.Err(err))
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	log.Info("session revoked successfully", slog.Int("user_id", int(userID)))
+
+	return nil
+}
+
+func (a *Auth) RevokeAllOtherSessions(ctx context.Context, accessToken string) (int, error) {
+	const f = "service.RevokeAllOtherSessions"
+
+	log := a.logger(ctx).With(slog.String("func", f))
+	log.Info("revoking all other user sessions")
+
+	claims, err := a.authenticate(ctx, accessToken)
+	if err != nil {
+		return 0, fmt.Errorf("%s:%w", f, err)
+	}
+	userID := claims.UserID
+
+	// current session of tokens issued before sid is unknown
+	if claims.SessionID == "" {
+		log.Warn("access token has no session id", slog.Int("user_id", int(userID)))
+
+		return 0, fmt.Errorf("%s:%w", f, ErrUnauthenticated)
+	}
+
+	revoked, err := a.revokeOtherSessions(ctx, userID, claims.SessionID)
+	if err != nil {
+		log.Error("failed to revoke sessions", le.Err(err))
+
+		return 0, fmt.Errorf("%s:%w", f, err)
+	}
+
+	log.Info("other sessions revoked successfully", slog.Int("user_id", int(userID)), slog.Int("count", revoked))
+
+	return revoked, nil
+}
+
+// revokeOtherSessions deletes every session of the user except the current one
+// and rejects access tokens of deleted sessions
+func (a *Auth) revokeOtherSessions(ctx context.Context, userID int32, currentSessionID string) (int, error) {
+	// without current session every session would be revoked
+	if currentSessionID == "" {
+		return 0, ErrUnauthenticated
+	}
+
+	sessions, err := a.sessionManager.Sessions(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	revoked := 0
+	for _, session := range sessions {
+		if session.ID == currentSessionID {
+			continue
+		}
+
+		if err := a.sessionManager.DeleteSession(ctx, userID, session.ID); err != nil {
+			return revoked, err
+		}
+		if err := a.accessTokenManager.RevokeSession(ctx, session.ID); err != nil {
+			return revoked, err
+		}
+		revoked++
+	}
+
+	return revoked, nil
+}
@@ -2,51 +2,172 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"time"
 
+	"github.com/kuromii5/sync-auth/internal/models"
+	"github.com/kuromii5/sync-auth/internal/repo/redis"
 	le "github.com/kuromii5/sync-auth/pkg/logger/l_err"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 )
 
-func (a *Auth) GetAccessToken(ctx context.Context, refreshToken, fingerprint string) (string, error) {
+func (a *Auth) GetAccessToken(ctx context.Context, refreshToken, fingerprint string) (models.TokenPair, error) {
 	const f = "service.GetAccessToken"
 
-	log := a.log.With(slog.String("func", f))
-	log.Info("attempting to generate new access token using refresh token")
+	log := a.logger(ctx).With(slog.String("func", f))
+	log.Info("attempting to generate new token pair using refresh token")
 
-	userID, err := a.refreshTokenManager.ValidateRefreshToken(ctx, refreshToken, fingerprint)
+	newRefreshToken, err := a.refreshTokenManager.RotateRefreshToken(ctx, refreshToken, fingerprint)
 	if err != nil {
-		log.Error("failed to validate refresh token", le.Err(err))
+		if errors.Is(err, redis.ErrTokenReused) {
+			log.Warn("refresh token reuse detected", slog.Int("user_id", int(newRefreshToken.UserID)))
+			a.securityEvents.Emit(ctx, models.SecurityEvent{
+				Type:        models.EventRefreshTokenReuse,
+				UserID:      newRefreshToken.UserID,
+				Fingerprint: fingerprint,
+				At:          time.Now(),
+			})
+
+			return models.TokenPair{}, fmt.Errorf("%s:%w", f, err)
+		}
+		log.Error("failed to rotate refresh token", le.Err(err))
+
+		return models.TokenPair{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	// claims are taken from the current state of the user
+	user, err := a.userProvider.UserByID(ctx, newRefreshToken.UserID)
+	if err != nil {
+		log.Error("failed to get user", le.Err(err))
 
-		return "", fmt.Errorf("%s:%w", f, err)
+		return models.TokenPair{}, fmt.Errorf("%s:%w", f, err)
 	}
 
-	accessToken, err := a.accessTokenManager.NewAccessToken(ctx, userID)
+	accessToken, err := a.accessTokenManager.NewAccessToken(ctx, user, newRefreshToken.SessionID)
 	if err != nil {
 		log.Error("failed to create access token", le.Err(err))
 
-		return "", fmt.Errorf("%s:%w", f, err)
+		return models.TokenPair{}, fmt.Errorf("%s:%w", f, err)
 	}
 
-	log.Info("successfully generated new access token", slog.Int("user_id", int(userID)))
+	tokens := models.TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: newRefreshToken.Token,
+	}
+	a.setAuthCookies(ctx, tokens)
+
+	log.Info("successfully generated new token pair", slog.Int("user_id", int(user.ID)))
 
-	return accessToken, nil
+	return tokens, nil
 }
 
-func (a *Auth) ValidateAccessToken(ctx context.Context, token string) (int32, error) {
+func (a *Auth) ValidateAccessToken(ctx context.Context, token string) (models.AccessClaims, error) {
 	const f = "service.ValidateAccessToken"
 
-	log := a.log.With(slog.String("func", f))
+	log := a.logger(ctx).With(slog.String("func", f))
 	log.Info("validating access token")
 
-	userID, err := a.accessTokenManager.ValidateAccessToken(ctx, token)
+	claims, err := a.accessTokenManager.ValidateAccessToken(ctx, token)
 	if err != nil {
 		log.Warn("failed to validate access token", le.Err(err))
 
-		return 0, fmt.Errorf("%s:%w", f, err)
+		return models.AccessClaims{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	log.Info("access token validated successfully", slog.Int("user_id", int(claims.UserID)))
+
+	return claims, nil
+}
+
+// issueTokens starts a new session for the user on given device
+func (a *Auth) issueTokens(ctx context.Context, user models.User, fingerprint string) (models.TokenPair, error) {
+	refreshToken, err := a.refreshTokenManager.NewRefreshToken(ctx, user.ID, fingerprint)
+	if err != nil {
+		return models.TokenPair{}, fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+
+	accessToken, err := a.accessTokenManager.NewAccessToken(ctx, user, refreshToken.SessionID)
+	if err != nil {
+		return models.TokenPair{}, fmt.Errorf("failed to generate access token: %w", err)
+	}
+
+	return models.TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken.Token,
+	}, nil
+}
+
+// setAuthCookies sends tokens to the client in response headers,
+// HTTP gateway passes them to the browser as Set-Cookie
+func (a *Auth) setAuthCookies(ctx context.Context, tokens models.TokenPair) {
+	md := metadata.Pairs()
+	md.Append("set-cookie", a.Cookies.AuthCookies(tokens)...)
+	if err := grpc.SetHeader(ctx, md); err != nil {
+		a.logger(ctx).Warn("failed to set auth cookies", le.Err(err))
+	}
+}
+
+// clearAuthCookies removes tokens from the browser
+func (a *Auth) clearAuthCookies(ctx context.Context) {
+	md := metadata.Pairs()
+	md.Append("set-cookie", a.Cookies.ClearCookies()...)
+	if err := grpc.SetHeader(ctx, md); err != nil {
+		a.logger(ctx).Warn("failed to clear auth cookies", le.Err(err))
+	}
+}
+
+func (a *Auth) JWKS(_ context.Context) []models.JWK {
+	return a.accessTokenManager.JWKS()
+}
+
+func (a *Auth) RotateSigningKey(ctx context.Context, keyFile string) (models.KeyRotation, error) {
+	const f = "service.RotateSigningKey"
+
+	log := a.logger(ctx).With(slog.String("func", f))
+	log.Info("rotating access token signing key")
+
+	rotation, err := a.signingKeyRotator.RotateSigningKey(ctx, keyFile)
+	if err != nil {
+		log.Error("failed to rotate signing key", le.Err(err))
+
+		return models.KeyRotation{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	log.Info("signing key rotated successfully", slog.String("kid", rotation.KeyID))
+
+	return rotation, nil
+}
+
+// RevokeUserTokens signs user out everywhere: all refresh tokens are deleted
+// and access tokens issued until now are rejected
+func (a *Auth) RevokeUserTokens(ctx context.Context, userID int32) error {
+	const f = "service.RevokeUserTokens"
+
+	log := a.logger(ctx).With(slog.String("func", f))
+	log.Info("revoking all tokens of user", slog.Int("user_id", int(userID)))
+
+	if err := a.accessTokenManager.RevokeAllTokens(ctx, userID); err != nil {
+		log.Error("failed to revoke tokens", le.Err(err))
+
+		return fmt.Errorf("%s:%w", f, err)
 	}
 
-	log.Info("access token validated successfully", slog.Int("user_id", int(userID)))
+	log.Info("all tokens of user revoked", slog.Int("user_id", int(userID)))
+
+	return nil
+}
+
+// authenticate returns claims of the access token or ErrUnauthenticated
+func (a *Auth) authenticate(ctx context.Context, accessToken string) (models.AccessClaims, error) {
+	claims, err := a.accessTokenManager.ValidateAccessToken(ctx, accessToken)
+	if err != nil {
+		a.logger(ctx).Warn("failed to validate access token", le.Err(err))
+
+		return models.AccessClaims{}, ErrUnauthenticated
+	}
 
-	return userID, nil
+	return claims, nil
 }
@@ -0,0 +1,107 @@
+package tokens
+
+import (
+	"sync"
+	"time"
+)
+
+// KeyRing holds one active signing key and any number of retired keys
+// which are only used to verify tokens issued before rotation.
+type KeyRing struct {
+	mu      sync.RWMutex
+	active  *SigningKey
+	retired map[string]retiredKey
+}
+
+type retiredKey struct {
+	key       *SigningKey
+	expiresAt time.Time
+}
+
+func NewKeyRing(active *SigningKey) *KeyRing {
+	return &KeyRing{
+		active:  active,
+		retired: make(map[string]retiredKey),
+	}
+}
+
+// Retire adds key which verifies tokens until the given time, e.g. key
+// configured by operator after rotation. Active key can't be retired
+func (r *KeyRing) Retire(key *SigningKey, until time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if key.ID != r.active.ID {
+		r.retired[key.ID] = retiredKey{key: key, expiresAt: until}
+	}
+}
+
+// Active returns key which is used to sign new tokens
+func (r *KeyRing) Active() *SigningKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.active
+}
+
+// Key returns active or not yet expired retired key by its id
+func (r *KeyRing) Key(kid string) (*SigningKey, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.active.ID == kid {
+		return r.active, true
+	}
+
+	retired, ok := r.retired[kid]
+	if !ok || retired.expired(time.Now()) {
+		return nil, false
+	}
+
+	return retired.key, true
+}
+
+// Keys returns all keys that are valid for verification, active key goes first
+func (r *KeyRing) Keys() []*SigningKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := time.Now()
+	keys := []*SigningKey{r.active}
+	for _, retired := range r.retired {
+		if !retired.expired(now) {
+			keys = append(keys, retired.key)
+		}
+	}
+
+	return keys
+}
+
+// Rotate promotes next key to active. Previous active key stays valid
+// for verification until retiredUntil.
+func (r *KeyRing) Rotate(next *SigningKey, retiredUntil time.Time) (previous *SigningKey) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	previous = r.active
+
+	// drop expired keys
+	for kid, retired := range r.retired {
+		if retired.expired(now) {
+			delete(r.retired, kid)
+		}
+	}
+
+	delete(r.retired, next.ID)
+	if previous.ID != next.ID {
+		r.retired[previous.ID] = retiredKey{key: previous, expiresAt: retiredUntil}
+	}
+	r.active = next
+
+	return previous
+}
+
+func (k retiredKey) expired(now time.Time) bool {
+	return now.After(k.expiresAt)
+}
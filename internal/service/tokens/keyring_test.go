@@ -0,0 +1,118 @@
+package tokens
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kuromii5/sync-auth/internal/models"
+	offlog "github.com/kuromii5/sync-auth/pkg/logger/off"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotateSigningKey(t *testing.T) {
+	ctx := context.Background()
+
+	oldKey, err := NewHMACKey("old_secret")
+	require.NoError(t, err)
+
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	keyFile := filepath.Join(t.TempDir(), "next.pem")
+	require.NoError(t, os.WriteFile(keyFile, pemKey(t, private), 0o600))
+
+	ring := NewKeyRing(oldKey)
+	storage := &memoryKeyStorage{}
+	manager := NewTokenManager(offlog.New(), ring, testIssuer, testAudience, time.Minute, time.Hour, nil, nil, nil, newMemoryDenylist(), nil, nil, storage)
+	// another instance started with the same config
+	replica := NewTokenManager(offlog.New(), NewKeyRing(oldKey), testIssuer, testAudience, time.Minute, time.Hour, nil, nil, nil, newMemoryDenylist(), nil, nil, storage)
+
+	oldToken, err := manager.NewAccessToken(ctx, models.User{ID: 1}, "sid")
+	require.NoError(t, err)
+
+	rotation, err := manager.RotateSigningKey(ctx, keyFile)
+	require.NoError(t, err)
+	require.Equal(t, oldKey.ID, rotation.PreviousKeyID)
+	require.Equal(t, "ES256", rotation.Algorithm)
+	require.Equal(t, rotation.KeyID, ring.Active().ID)
+
+	newToken, err := manager.NewAccessToken(ctx, models.User{ID: 2}, "sid")
+	require.NoError(t, err)
+
+	// both tokens are valid during overlapping window
+	claims, err := manager.ValidateAccessToken(ctx, oldToken)
+	require.NoError(t, err)
+	require.Equal(t, int32(1), claims.UserID)
+
+	claims, err = manager.ValidateAccessToken(ctx, newToken)
+	require.NoError(t, err)
+	require.Equal(t, int32(2), claims.UserID)
+
+	// only the public key is published
+	require.Len(t, manager.JWKS(), 1)
+
+	// replica loads the new key on first token signed by it
+	claims, err = replica.ValidateAccessToken(ctx, newToken)
+	require.NoError(t, err)
+	require.Equal(t, int32(2), claims.UserID)
+
+	claims, err = replica.ValidateAccessToken(ctx, oldToken)
+	require.NoError(t, err)
+	require.Equal(t, int32(1), claims.UserID)
+
+	// restarted instance signs with the rotated key
+	restarted := NewTokenManager(offlog.New(), NewKeyRing(oldKey), testIssuer, testAudience, time.Minute, time.Hour, nil, nil, nil, newMemoryDenylist(), nil, nil, storage)
+	require.NoError(t, restarted.SyncSigningKeys(ctx))
+	require.Equal(t, rotation.KeyID, restarted.keys.Active().ID)
+}
+
+// memoryKeyStorage keeps signing keys like postgres storage does
+type memoryKeyStorage struct {
+	keys []models.StoredSigningKey
+}
+
+func (s *memoryKeyStorage) SigningKeys(_ context.Context) ([]models.StoredSigningKey, error) {
+	return s.keys, nil
+}
+
+func (s *memoryKeyStorage) SaveActiveSigningKey(_ context.Context, key models.StoredSigningKey, retireAfter time.Duration) error {
+	now := time.Now()
+	for i := range s.keys {
+		if s.keys[i].RetiredUntil.IsZero() {
+			s.keys[i].RetiredUntil = now.Add(retireAfter)
+		}
+	}
+	key.ActivatedAt = now
+	s.keys = append(s.keys, key)
+
+	return nil
+}
+
+func TestKeyRing_RetiredKeyExpires(t *testing.T) {
+	first, err := NewHMACKey("first")
+	require.NoError(t, err)
+	second, err := NewHMACKey("second")
+	require.NoError(t, err)
+
+	ring := NewKeyRing(first)
+	ring.Rotate(second, time.Now().Add(-time.Second))
+
+	_, ok := ring.Key(first.ID)
+	require.False(t, ok)
+	require.Len(t, ring.Keys(), 1)
+
+	ring = NewKeyRing(second)
+	ring.Retire(first, time.Now().Add(time.Minute))
+	_, ok = ring.Key(first.ID)
+	require.True(t, ok)
+
+	ring.Retire(first, time.Now().Add(-time.Second))
+	_, ok = ring.Key(first.ID)
+	require.False(t, ok)
+	require.Len(t, ring.Keys(), 1)
+}
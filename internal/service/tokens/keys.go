@@ -0,0 +1,208 @@
+package tokens
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/kuromii5/sync-auth/internal/models"
+)
+
+var (
+	ErrInvalidKey         = errors.New("invalid signing key")
+	ErrUnsupportedKeyType = errors.New("unsupported signing key type")
+)
+
+const hmacKeyIDLabel = "sync-auth hs256 key id"
+
+// SigningKey is a key used to sign and verify access tokens.
+// Symmetric (HMAC) keys are never published in the JWKS document.
+type SigningKey struct {
+	ID     string
+	Method jwt.SigningMethod
+	Sign   interface{}
+	Verify interface{}
+}
+
+// NewHMACKey creates HS256 key from shared secret
+func NewHMACKey(secret string) (*SigningKey, error) {
+	const f = "tokens.NewHMACKey"
+
+	if secret == "" {
+		return nil, fmt.Errorf("%s:%w", f, ErrInvalidKey)
+	}
+
+	// thumbprint of the secret would be a plain hash of it, so kid is a MAC
+	// of fixed label, which reveals no more than the token signature itself
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(hmacKeyIDLabel))
+	kid := b64(mac.Sum(nil))
+
+	return &SigningKey{
+		ID:     kid,
+		Method: jwt.SigningMethodHS256,
+		Sign:   []byte(secret),
+		Verify: []byte(secret),
+	}, nil
+}
+
+// LoadSigningKey reads PEM encoded private key from file.
+// Algorithm is chosen by key type: RSA - RS256, ECDSA - ES256/ES384/ES512, Ed25519 - EdDSA
+func LoadSigningKey(path string) (*SigningKey, error) {
+	const f = "tokens.LoadSigningKey"
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", f, err)
+	}
+
+	key, err := ParseSigningKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", f, err)
+	}
+
+	return key, nil
+}
+
+// ParseSigningKey parses PEM encoded PKCS#8, PKCS#1 or SEC 1 private key
+func ParseSigningKey(data []byte) (*SigningKey, error) {
+	const f = "tokens.ParseSigningKey"
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s:%w", f, ErrInvalidKey)
+	}
+
+	var private interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		private, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s:%w: %s", f, ErrUnsupportedKeyType, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", f, err)
+	}
+
+	key, err := newAsymmetricKey(private)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", f, err)
+	}
+
+	return key, nil
+}
+
+func newAsymmetricKey(private interface{}) (*SigningKey, error) {
+	key := &SigningKey{Sign: private}
+
+	switch k := private.(type) {
+	case *rsa.PrivateKey:
+		key.Method = jwt.SigningMethodRS256
+		key.Verify = &k.PublicKey
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			key.Method = jwt.SigningMethodES256
+		case elliptic.P384():
+			key.Method = jwt.SigningMethodES384
+		case elliptic.P521():
+			key.Method = jwt.SigningMethodES512
+		default:
+			return nil, ErrUnsupportedKeyType
+		}
+		key.Verify = &k.PublicKey
+	case ed25519.PrivateKey:
+		key.Method = jwt.SigningMethodEdDSA
+		key.Verify = k.Public()
+	default:
+		return nil, ErrUnsupportedKeyType
+	}
+
+	jwk, ok := key.JWK()
+	if !ok {
+		return nil, ErrUnsupportedKeyType
+	}
+
+	kid, err := thumbprint(thumbprintMembers(jwk))
+	if err != nil {
+		return nil, err
+	}
+	key.ID = kid
+
+	return key, nil
+}
+
+// JWK returns public part of the key. Returns false for symmetric keys
+func (k *SigningKey) JWK() (models.JWK, bool) {
+	jwk := models.JWK{
+		Kid: k.ID,
+		Use: "sig",
+		Alg: k.Method.Alg(),
+	}
+
+	switch pub := k.Verify.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = b64(pub.N.Bytes())
+		jwk.E = b64(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = pub.Curve.Params().Name
+		jwk.X = b64(pub.X.FillBytes(make([]byte, size)))
+		jwk.Y = b64(pub.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = b64(pub)
+	default:
+		return models.JWK{}, false
+	}
+
+	return jwk, true
+}
+
+// required members of the key in lexicographic order (RFC 7638)
+func thumbprintMembers(jwk models.JWK) map[string]string {
+	switch jwk.Kty {
+	case "RSA":
+		return map[string]string{"e": jwk.E, "kty": jwk.Kty, "n": jwk.N}
+	case "EC":
+		return map[string]string{"crv": jwk.Crv, "kty": jwk.Kty, "x": jwk.X, "y": jwk.Y}
+	default:
+		return map[string]string{"crv": jwk.Crv, "kty": jwk.Kty, "x": jwk.X}
+	}
+}
+
+func thumbprint(members map[string]string) (string, error) {
+	// encoding/json sorts map keys, so output is canonical
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+
+	return b64(sum[:]), nil
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
@@ -0,0 +1,115 @@
+package tokens
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/kuromii5/sync-auth/internal/models"
+	offlog "github.com/kuromii5/sync-auth/pkg/logger/off"
+	"github.com/stretchr/testify/require"
+)
+
+func pemKey(t *testing.T, private any) []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func TestParseSigningKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		private any
+		alg     string
+		kty     string
+	}{
+		{name: "rsa", private: rsaKey, alg: "RS256", kty: "RSA"},
+		{name: "ecdsa", private: ecKey, alg: "ES256", kty: "EC"},
+		{name: "ed25519", private: edKey, alg: "EdDSA", kty: "OKP"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ParseSigningKey(pemKey(t, tt.private))
+			require.NoError(t, err)
+			require.Equal(t, tt.alg, key.Method.Alg())
+			require.NotEmpty(t, key.ID)
+
+			jwk, ok := key.JWK()
+			require.True(t, ok)
+			require.Equal(t, tt.kty, jwk.Kty)
+			require.Equal(t, key.ID, jwk.Kid)
+
+			manager := NewTokenManager(offlog.New(), NewKeyRing(key), testIssuer, testAudience, time.Minute, time.Hour, nil, nil, nil, newMemoryDenylist(), nil, nil, nil)
+			token, err := manager.NewAccessToken(context.Background(), models.User{ID: 42}, "sid")
+			require.NoError(t, err)
+
+			claims, err := manager.ValidateAccessToken(context.Background(), token)
+			require.NoError(t, err)
+			require.Equal(t, int32(42), claims.UserID)
+			require.Len(t, manager.JWKS(), 1)
+		})
+	}
+}
+
+func TestHMACKeyIsNotPublished(t *testing.T) {
+	key, err := NewHMACKey("secret")
+	require.NoError(t, err)
+
+	_, ok := key.JWK()
+	require.False(t, ok)
+
+	_, err = NewHMACKey("")
+	require.ErrorIs(t, err, ErrInvalidKey)
+}
+
+func TestValidateAccessToken_WrongKey(t *testing.T) {
+	_, first, _ := ed25519.GenerateKey(rand.Reader)
+	_, second, _ := ed25519.GenerateKey(rand.Reader)
+
+	signKey, err := ParseSigningKey(pemKey(t, first))
+	require.NoError(t, err)
+	otherKey, err := ParseSigningKey(pemKey(t, second))
+	require.NoError(t, err)
+
+	signer := NewTokenManager(offlog.New(), NewKeyRing(signKey), testIssuer, testAudience, time.Minute, time.Hour, nil, nil, nil, newMemoryDenylist(), nil, nil, nil)
+	verifier := NewTokenManager(offlog.New(), NewKeyRing(otherKey), testIssuer, testAudience, time.Minute, time.Hour, nil, nil, nil, newMemoryDenylist(), nil, nil, nil)
+
+	token, err := signer.NewAccessToken(context.Background(), models.User{ID: 1}, "sid")
+	require.NoError(t, err)
+
+	_, err = verifier.ValidateAccessToken(context.Background(), token)
+	require.Error(t, err)
+}
+
+func TestNewHMACKey_KeyID(t *testing.T) {
+	key, err := NewHMACKey("secret")
+	require.NoError(t, err)
+	same, err := NewHMACKey("secret")
+	require.NoError(t, err)
+	other, err := NewHMACKey("other_secret")
+	require.NoError(t, err)
+
+	require.Equal(t, key.ID, same.ID)
+	require.NotEqual(t, key.ID, other.ID)
+
+	// kid must not be the RFC 7638 thumbprint, i.e. plain hash of the secret
+	hash, err := thumbprint(map[string]string{"k": b64([]byte("secret")), "kty": "oct"})
+	require.NoError(t, err)
+	require.NotEqual(t, hash, key.ID)
+}
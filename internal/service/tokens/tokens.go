@@ -4,68 +4,150 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"log/slog"
+	"os"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt"
+	"github.com/kuromii5/sync-auth/internal/client"
+	"github.com/kuromii5/sync-auth/internal/models"
 	le "github.com/kuromii5/sync-auth/pkg/logger/l_err"
 )
 
+var (
+	ErrTokenRevoked  = errors.New("access token is revoked")
+	ErrInvalidClaims = errors.New("invalid token claims")
+)
+
+// Claims of access token
+type Claims struct {
+	jwt.StandardClaims
+	EmailVerified bool     `json:"email_verified"`
+	Roles         []string `json:"roles"`
+	SessionID     string   `json:"sid"`
+	// iat in nanoseconds, so tokens issued right after revocation
+	// in the same second are not revoked
+	IssuedAtNano int64 `json:"iat_ns,omitempty"`
+}
+
 type TokenManager struct {
 	log *slog.Logger
 
 	accessTTL  time.Duration
 	refreshTTL time.Duration
-	secret     string
+	keys       *KeyRing
+	issuer     string
+	audience   string
 
 	refreshTokenSetter  RefreshTokenSetter
+	refreshTokenRotator RefreshTokenRotator
 	refreshTokenDeleter RefreshTokenDeleter
+	accessTokenDenylist AccessTokenDenylist
 	userGetter          UserGetter
+	refreshTokenGetter  RefreshTokenGetter
+	signingKeyStorage   SigningKeyStorage
+
+	syncMu   sync.Mutex
+	lastSync time.Time
 }
 
 type RefreshTokenSetter interface {
-	SetRefreshToken(ctx context.Context, userID int32, fingerprint, token string, expires time.Duration) error
+	SetRefreshToken(ctx context.Context, token string, session models.Session, expires time.Duration) error
+}
+type RefreshTokenRotator interface {
+	RotateRefreshToken(ctx context.Context, oldToken, newToken, fingerprint string, usage models.Session, expires time.Duration) (string, string, error)
 }
 type RefreshTokenDeleter interface {
 	DeleteRefreshToken(ctx context.Context, userID int32, fingerprint string) error
+	DeleteAllRefreshTokens(ctx context.Context, userID int32) error
+}
+type AccessTokenDenylist interface {
+	DenyAccessToken(ctx context.Context, jti string, expires time.Duration) error
+	IsAccessTokenDenied(ctx context.Context, jti string) (bool, error)
+	DenySession(ctx context.Context, sessionID string, expires time.Duration) error
+	IsSessionDenied(ctx context.Context, sessionID string) (bool, error)
+	SetRevokedBefore(ctx context.Context, userID int32, before time.Time, expires time.Duration) error
+	RevokedBefore(ctx context.Context, userID int32) (time.Time, error)
 }
 type UserGetter interface {
 	UserID(ctx context.Context, token, fingerprint string) (string, error)
 }
+type RefreshTokenGetter interface {
+	RefreshTokenSession(ctx context.Context, token, fingerprint string) (models.Session, time.Time, error)
+}
+
+// SigningKeyStorage shares rotated signing keys between instances
+type SigningKeyStorage interface {
+	SigningKeys(ctx context.Context) ([]models.StoredSigningKey, error)
+	SaveActiveSigningKey(ctx context.Context, key models.StoredSigningKey, retireAfter time.Duration) error
+}
 
 func NewTokenManager(
 	log *slog.Logger,
-	secret string,
+	keys *KeyRing,
+	issuer, audience string,
 	accessTTL, refreshTTL time.Duration,
 	refreshTokenSetter RefreshTokenSetter,
+	refreshTokenRotator RefreshTokenRotator,
 	refreshTokenDeleter RefreshTokenDeleter,
+	accessTokenDenylist AccessTokenDenylist,
 	userGetter UserGetter,
+	refreshTokenGetter RefreshTokenGetter,
+	signingKeyStorage SigningKeyStorage,
 ) *TokenManager {
 	return &TokenManager{
 		log:                 log,
 		accessTTL:           accessTTL,
 		refreshTTL:          refreshTTL,
-		secret:              secret,
+		keys:                keys,
+		issuer:              issuer,
+		audience:            audience,
 		refreshTokenSetter:  refreshTokenSetter,
+		refreshTokenRotator: refreshTokenRotator,
 		refreshTokenDeleter: refreshTokenDeleter,
+		accessTokenDenylist: accessTokenDenylist,
 		userGetter:          userGetter,
+		refreshTokenGetter:  refreshTokenGetter,
+		signingKeyStorage:   signingKeyStorage,
 	}
 }
 
-func (t *TokenManager) NewAccessToken(_ context.Context, userID int32) (string, error) {
+func (t *TokenManager) NewAccessToken(_ context.Context, user models.User, sessionID string) (string, error) {
 	const f = "tokens.NewAccessToken"
 
-	jwtToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{
-		Subject:   fmt.Sprintf("%d", userID),
-		IssuedAt:  time.Now().Unix(),
-		ExpiresAt: time.Now().Add(t.accessTTL).Unix(),
+	key := t.keys.Active()
+
+	jti, err := randomToken(16)
+	if err != nil {
+		t.log.Error("failed to generate token id", le.Err(err), slog.Int("user_id", int(user.ID)))
+
+		return "", fmt.Errorf("%s:%w", f, err)
+	}
+
+	now := time.Now()
+	jwtToken := jwt.NewWithClaims(key.Method, Claims{
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
+			Issuer:    t.issuer,
+			Audience:  t.audience,
+			Subject:   fmt.Sprintf("%d", user.ID),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(t.accessTTL).Unix(),
+		},
+		EmailVerified: user.EmailVerified,
+		Roles:         user.Roles,
+		SessionID:     sessionID,
+		IssuedAtNano:  now.UnixNano(),
 	})
+	jwtToken.Header["kid"] = key.ID
 
-	token, err := jwtToken.SignedString([]byte(t.secret))
+	token, err := jwtToken.SignedString(key.Sign)
 	if err != nil {
-		t.log.Error("failed to sign access token", le.Err(err), slog.Int("user_id", int(userID)))
+		t.log.Error("failed to sign access token", le.Err(err), slog.Int("user_id", int(user.ID)))
 
 		return "", fmt.Errorf("%s:%w", f, err)
 	}
@@ -73,34 +155,53 @@ func (t *TokenManager) NewAccessToken(_ context.Context, userID int32) (string,
 	return token, nil
 }
 
-func (t *TokenManager) NewRefreshToken(ctx context.Context, userID int32, fingerprint string) (string, error) {
+func (t *TokenManager) NewRefreshToken(ctx context.Context, userID int32, fingerprint string) (models.RefreshToken, error) {
 	const f = "tokens.NewRefreshToken"
 
 	log := t.log.With(slog.String("func", f))
 	log.Info("generating new refresh token", slog.Int("user_id", int(userID)))
 
-	b := make([]byte, 32)
-	_, err := rand.Read(b)
+	refreshToken, err := randomToken(32)
 	if err != nil {
 		log.Error("failed to generate random bytes for refresh token", le.Err(err))
 
-		return "", fmt.Errorf("%s:%w", f, err)
+		return models.RefreshToken{}, fmt.Errorf("%s:%w", f, err)
 	}
 
-	// encode token
-	refreshToken := base64.URLEncoding.EncodeToString(b)
+	// every login starts a new token family
+	family, err := randomToken(16)
+	if err != nil {
+		log.Error("failed to generate token family", le.Err(err))
+
+		return models.RefreshToken{}, fmt.Errorf("%s:%w", f, err)
+	}
 
-	// save token
-	err = t.refreshTokenSetter.SetRefreshToken(ctx, userID, fingerprint, refreshToken, t.refreshTTL)
+	// save token with info about the device
+	now := time.Now()
+	info := client.FromContext(ctx)
+	session := models.Session{
+		ID:          family,
+		UserID:      userID,
+		Fingerprint: fingerprint,
+		IP:          info.IP,
+		UserAgent:   info.UserAgent,
+		CreatedAt:   now,
+		LastUsedAt:  now,
+	}
+	err = t.refreshTokenSetter.SetRefreshToken(ctx, refreshToken, session, t.refreshTTL)
 	if err != nil {
 		log.Error("failed to save refresh token", le.Err(err))
 
-		return "", fmt.Errorf("%s:%w", f, err)
+		return models.RefreshToken{}, fmt.Errorf("%s:%w", f, err)
 	}
 
 	log.Info("successfully generated and saved refresh token", slog.String("refresh_token", refreshToken))
 
-	return refreshToken, nil
+	return models.RefreshToken{
+		Token:     refreshToken,
+		UserID:    userID,
+		SessionID: family,
+	}, nil
 }
 
 func (t *TokenManager) ValidateRefreshToken(ctx context.Context, token, fingerprint string) (int32, error) {
@@ -129,47 +230,447 @@ func (t *TokenManager) ValidateRefreshToken(ctx context.Context, token, fingerpr
 	return int32(id), nil
 }
 
-func (t *TokenManager) ValidateAccessToken(ctx context.Context, token string) (int32, error) {
+// RefreshTokenClaims returns owner, session and lifetime of the refresh token
+func (t *TokenManager) RefreshTokenClaims(ctx context.Context, token, fingerprint string) (models.RefreshClaims, error) {
+	const f = "tokens.RefreshTokenClaims"
+
+	session, expiresAt, err := t.refreshTokenGetter.RefreshTokenSession(ctx, token, fingerprint)
+	if err != nil {
+		return models.RefreshClaims{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	// token is issued on login or on the last rotation
+	return models.RefreshClaims{
+		UserID:    session.UserID,
+		SessionID: session.ID,
+		Issuer:    t.issuer,
+		Audience:  t.audience,
+		IssuedAt:  session.LastUsedAt,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// RotateRefreshToken invalidates given refresh token and issues a new one
+// in the same family. Replaying already rotated token revokes the whole family
+// and access tokens of the session
+func (t *TokenManager) RotateRefreshToken(ctx context.Context, token, fingerprint string) (models.RefreshToken, error) {
+	const f = "tokens.RotateRefreshToken"
+
+	log := t.log.With(slog.String("func", f))
+	log.Info("rotating refresh token")
+
+	refreshToken, err := randomToken(32)
+	if err != nil {
+		log.Error("failed to generate random bytes for refresh token", le.Err(err))
+
+		return models.RefreshToken{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	info := client.FromContext(ctx)
+	usage := models.Session{
+		IP:         info.IP,
+		UserAgent:  info.UserAgent,
+		LastUsedAt: time.Now(),
+	}
+
+	userIDStr, family, rotateErr := t.refreshTokenRotator.RotateRefreshToken(ctx, token, refreshToken, fingerprint, usage, t.refreshTTL)
+	if userIDStr == "" {
+		log.Warn("failed to rotate refresh token", le.Err(rotateErr))
+
+		return models.RefreshToken{}, fmt.Errorf("%s:%w", f, rotateErr)
+	}
+
+	// convert string to int32
+	id, err := strconv.ParseInt(userIDStr, 10, 32)
+	if err != nil {
+		log.Error("failed to parse user ID from string", le.Err(err))
+
+		return models.RefreshToken{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	// token reuse, refresh tokens of the family are already deleted by storage.
+	// Family is the session, so its access tokens are rejected too
+	if rotateErr != nil {
+		log.Warn("refresh token reuse detected, token family revoked",
+			slog.Int("user_id", int(id)),
+			slog.String("family", family),
+		)
+
+		if err := t.accessTokenDenylist.DenySession(ctx, family, t.accessTTL); err != nil {
+			log.Error("failed to deny session of reused token", le.Err(err))
+
+			return models.RefreshToken{UserID: int32(id), SessionID: family}, fmt.Errorf("%s:%w", f, errors.Join(rotateErr, err))
+		}
+
+		return models.RefreshToken{UserID: int32(id), SessionID: family}, fmt.Errorf("%s:%w", f, rotateErr)
+	}
+
+	log.Info("successfully rotated refresh token", slog.Int("user_id", int(id)))
+
+	return models.RefreshToken{
+		Token:     refreshToken,
+		UserID:    int32(id),
+		SessionID: family,
+	}, nil
+}
+
+func (t *TokenManager) ValidateAccessToken(ctx context.Context, token string) (models.AccessClaims, error) {
 	const f = "tokenManager.ValidateAccessToken"
 
 	log := t.log.With(slog.String("func", f))
 	log.Info("validating given access token", slog.String("access_token", token))
 
-	keyFunc := func(token *jwt.Token) (interface{}, error) {
-		_, ok := token.Method.(*jwt.SigningMethodHMAC)
-		if !ok {
-			err := fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			log.Error("unexpected signing method", le.Err(err))
+	claims, err := t.parseAccessToken(ctx, token)
+	if err != nil {
+		log.Warn("failed to parse access token", le.Err(err))
+
+		return models.AccessClaims{}, fmt.Errorf("%s:%w", f, err)
+	}
 
-			return nil, fmt.Errorf("%s:%w", f, err)
+	// convert string to int32
+	userID, err := strconv.ParseInt(claims.Subject, 10, 32)
+	if err != nil {
+		log.Error("failed to parse user ID", le.Err(err))
+
+		return models.AccessClaims{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	denied, err := t.accessTokenDenylist.IsAccessTokenDenied(ctx, claims.Id)
+	if err != nil {
+		log.Error("failed to check access token denylist", le.Err(err))
+
+		return models.AccessClaims{}, fmt.Errorf("%s:%w", f, err)
+	}
+	if denied {
+		log.Warn("access token is revoked", slog.Int("user_id", int(userID)))
+
+		return models.AccessClaims{}, fmt.Errorf("%s:%w", f, ErrTokenRevoked)
+	}
+
+	if claims.SessionID != "" {
+		denied, err := t.accessTokenDenylist.IsSessionDenied(ctx, claims.SessionID)
+		if err != nil {
+			log.Error("failed to check session denylist", le.Err(err))
+
+			return models.AccessClaims{}, fmt.Errorf("%s:%w", f, err)
+		}
+		if denied {
+			log.Warn("session of access token is revoked", slog.Int("user_id", int(userID)))
+
+			return models.AccessClaims{}, fmt.Errorf("%s:%w", f, ErrTokenRevoked)
 		}
-		return []byte(t.secret), nil
 	}
 
-	accessToken, err := jwt.ParseWithClaims(token, &jwt.StandardClaims{}, keyFunc)
+	revokedBefore, err := t.accessTokenDenylist.RevokedBefore(ctx, int32(userID))
+	if err != nil {
+		log.Error("failed to get revocation watermark", le.Err(err))
+
+		return models.AccessClaims{}, fmt.Errorf("%s:%w", f, err)
+	}
+	if !revokedBefore.IsZero() && issuedAt(claims).Before(revokedBefore) {
+		log.Warn("access token issued before revocation watermark", slog.Int("user_id", int(userID)))
+
+		return models.AccessClaims{}, fmt.Errorf("%s:%w", f, ErrTokenRevoked)
+	}
+
+	return models.AccessClaims{
+		ID:            claims.Id,
+		UserID:        int32(userID),
+		EmailVerified: claims.EmailVerified,
+		Roles:         claims.Roles,
+		SessionID:     claims.SessionID,
+		Issuer:        claims.Issuer,
+		Audience:      claims.Audience,
+		IssuedAt:      time.Unix(claims.IssuedAt, 0),
+		ExpiresAt:     time.Unix(claims.ExpiresAt, 0),
+	}, nil
+}
+
+// issuedAt returns precise issue time, tokens without iat_ns are treated
+// as issued at the start of their second
+func issuedAt(claims *Claims) time.Time {
+	if claims.IssuedAtNano != 0 {
+		return time.Unix(0, claims.IssuedAtNano)
+	}
+
+	return time.Unix(claims.IssuedAt, 0)
+}
+
+// RevokeAccessToken denies access token until it expires
+func (t *TokenManager) RevokeAccessToken(ctx context.Context, token string) error {
+	const f = "tokenManager.RevokeAccessToken"
+
+	log := t.log.With(slog.String("func", f))
+	log.Info("revoking access token")
+
+	claims, err := t.parseAccessToken(ctx, token)
 	if err != nil {
 		log.Warn("failed to parse access token", le.Err(err))
 
-		return 0, fmt.Errorf("%s:%w", f, err)
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	// tokens issued before jti was introduced can't be denied one by one
+	if claims.Id == "" {
+		return nil
+	}
+
+	ttl := time.Until(time.Unix(claims.ExpiresAt, 0))
+	if ttl <= 0 {
+		return nil
 	}
 
-	claims, ok := accessToken.Claims.(*jwt.StandardClaims)
+	if err := t.accessTokenDenylist.DenyAccessToken(ctx, claims.Id, ttl); err != nil {
+		log.Error("failed to deny access token", le.Err(err))
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	return nil
+}
+
+// RevokeSession rejects access tokens of the session, its refresh token
+// is deleted by the caller
+func (t *TokenManager) RevokeSession(ctx context.Context, sessionID string) error {
+	const f = "tokenManager.RevokeSession"
+
+	log := t.log.With(slog.String("func", f))
+	log.Info("revoking access tokens of session")
+
+	if err := t.accessTokenDenylist.DenySession(ctx, sessionID, t.accessTTL); err != nil {
+		log.Error("failed to deny session", le.Err(err))
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	return nil
+}
+
+// RevokeAllTokens rejects every access token of the user issued until now
+// and deletes all refresh tokens (password change, account lockout)
+func (t *TokenManager) RevokeAllTokens(ctx context.Context, userID int32) error {
+	const f = "tokenManager.RevokeAllTokens"
+
+	log := t.log.With(slog.String("func", f))
+	log.Info("revoking all tokens of user", slog.Int("user_id", int(userID)))
+
+	if err := t.accessTokenDenylist.SetRevokedBefore(ctx, userID, time.Now(), t.accessTTL); err != nil {
+		log.Error("failed to set revocation watermark", le.Err(err))
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	if err := t.refreshTokenDeleter.DeleteAllRefreshTokens(ctx, userID); err != nil {
+		log.Error("failed to delete refresh tokens", le.Err(err))
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	return nil
+}
+
+// parseAccessToken checks signature, expiration, issuer and audience of the token
+func (t *TokenManager) parseAccessToken(ctx context.Context, token string) (*Claims, error) {
+	keyFunc := func(token *jwt.Token) (interface{}, error) {
+		// tokens without kid were issued before key ring, check them with active key
+		key := t.keys.Active()
+		if kid, ok := token.Header["kid"].(string); ok {
+			key, ok = t.keys.Key(kid)
+			if !ok {
+				// key could be rotated by another instance
+				key, ok = t.reloadKey(ctx, kid)
+			}
+			if !ok {
+				return nil, fmt.Errorf("unknown key id: %s", kid)
+			}
+		}
+
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key.Verify, nil
+	}
+
+	accessToken, err := jwt.ParseWithClaims(token, &Claims{}, keyFunc)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := accessToken.Claims.(*Claims)
 	if !ok || !accessToken.Valid {
-		err := fmt.Errorf("invalid token claims")
-		log.Warn("invalid token claims", le.Err(err))
+		return nil, ErrInvalidClaims
+	}
 
-		return 0, fmt.Errorf("%s:%w", f, err)
+	if !claims.VerifyIssuer(t.issuer, true) || !claims.VerifyAudience(t.audience, true) {
+		return nil, ErrInvalidClaims
 	}
 
-	// convert string to int32
-	userID, err := strconv.ParseInt(claims.Subject, 10, 32)
+	return claims, nil
+}
+
+// JWKS returns public keys that can be used to verify access tokens,
+// including retired ones. Symmetric keys are skipped
+func (t *TokenManager) JWKS() []models.JWK {
+	keys := []models.JWK{}
+	for _, key := range t.keys.Keys() {
+		if jwk, ok := key.JWK(); ok {
+			keys = append(keys, jwk)
+		}
+	}
+
+	return keys
+}
+
+// RotateSigningKey makes key from keyFile active. Previous key is retired
+// and still accepted until every token signed by it has expired.
+// Key is saved to shared storage, so other instances pick it up too
+func (t *TokenManager) RotateSigningKey(ctx context.Context, keyFile string) (models.KeyRotation, error) {
+	const f = "tokenManager.RotateSigningKey"
+
+	log := t.log.With(slog.String("func", f))
+	log.Info("rotating signing key")
+
+	data, err := os.ReadFile(keyFile)
 	if err != nil {
-		log.Error("failed to parse user ID", le.Err(err))
+		log.Error("failed to read new signing key", le.Err(err))
 
-		return 0, fmt.Errorf("%s:%w", f, err)
+		return models.KeyRotation{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	next, err := ParseSigningKey(data)
+	if err != nil {
+		log.Error("failed to load new signing key", le.Err(err))
+
+		return models.KeyRotation{}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	if t.signingKeyStorage != nil {
+		err := t.signingKeyStorage.SaveActiveSigningKey(ctx, models.StoredSigningKey{ID: next.ID, PrivateKey: data}, t.accessTTL)
+		if err != nil {
+			log.Error("failed to save new signing key", le.Err(err))
+
+			return models.KeyRotation{}, fmt.Errorf("%s:%w", f, err)
+		}
+	}
+
+	retiredUntil := time.Now().Add(t.accessTTL)
+	previous := t.keys.Rotate(next, retiredUntil)
+
+	log.Info("signing key rotated",
+		slog.String("kid", next.ID),
+		slog.String("previous_kid", previous.ID),
+		slog.Time("retired_until", retiredUntil),
+	)
+
+	return models.KeyRotation{
+		KeyID:         next.ID,
+		Algorithm:     next.Method.Alg(),
+		PreviousKeyID: previous.ID,
+		RetiredUntil:  retiredUntil,
+	}, nil
+}
+
+// SyncSigningKeys loads active and retired keys from shared storage into the key ring.
+// Keys from config are kept until the stored active key replaces them
+func (t *TokenManager) SyncSigningKeys(ctx context.Context) error {
+	const f = "tokenManager.SyncSigningKeys"
+
+	if t.signingKeyStorage == nil {
+		return nil
+	}
+
+	stored, err := t.signingKeyStorage.SigningKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	// active key goes first, so the previous active key can be retired after it
+	var retired []models.StoredSigningKey
+	for _, s := range stored {
+		if !s.RetiredUntil.IsZero() {
+			retired = append(retired, s)
+			continue
+		}
+
+		key, err := ParseSigningKey(s.PrivateKey)
+		if err != nil {
+			return fmt.Errorf("%s:%w", f, err)
+		}
+		if t.keys.Active().ID != key.ID {
+			previous := t.keys.Rotate(key, s.ActivatedAt.Add(t.accessTTL))
+			t.log.Info("signing key rotated by another instance", slog.String("kid", key.ID), slog.String("previous_kid", previous.ID))
+		}
+	}
+	for _, s := range retired {
+		key, err := ParseSigningKey(s.PrivateKey)
+		if err != nil {
+			return fmt.Errorf("%s:%w", f, err)
+		}
+		t.keys.Retire(key, s.RetiredUntil)
+	}
+
+	return nil
+}
+
+// WatchSigningKeys syncs signing keys with the given interval until ctx is done
+func (t *TokenManager) WatchSigningKeys(ctx context.Context, interval time.Duration) {
+	const f = "tokenManager.WatchSigningKeys"
+
+	if t.signingKeyStorage == nil || interval <= 0 {
+		return
+	}
+
+	log := t.log.With(slog.String("func", f))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := t.SyncSigningKeys(ctx); err != nil {
+				log.Error("failed to sync signing keys", le.Err(err))
+			}
+		}
+	}
+}
+
+// reloadKey syncs signing keys when token has unknown kid. Tokens with
+// garbage kid can't make more than one storage query per second
+func (t *TokenManager) reloadKey(ctx context.Context, kid string) (*SigningKey, bool) {
+	const f = "tokenManager.reloadKey"
+
+	if t.signingKeyStorage == nil {
+		return nil, false
+	}
+
+	t.syncMu.Lock()
+	if time.Since(t.lastSync) < time.Second {
+		t.syncMu.Unlock()
+
+		return nil, false
+	}
+	t.lastSync = time.Now()
+	t.syncMu.Unlock()
+
+	if err := t.SyncSigningKeys(ctx); err != nil {
+		t.log.Error("failed to sync signing keys", le.Err(err), slog.String("func", f))
+
+		return nil, false
+	}
+
+	return t.keys.Key(kid)
+}
+
+func randomToken(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
 	}
 
-	return int32(userID), nil
+	return base64.URLEncoding.EncodeToString(b), nil
 }
 
 func (t *TokenManager) Delete(ctx context.Context, userID int32, fingerprint string) error {
@@ -0,0 +1,248 @@
+package tokens
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kuromii5/sync-auth/internal/models"
+	offlog "github.com/kuromii5/sync-auth/pkg/logger/off"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testIssuer   = "sync-auth"
+	testAudience = "sync"
+)
+
+type memoryDenylist struct {
+	mu            sync.Mutex
+	denied        map[string]bool
+	deniedSession map[string]bool
+	revokedBefore map[int32]time.Time
+}
+
+func newMemoryDenylist() *memoryDenylist {
+	return &memoryDenylist{
+		denied:        make(map[string]bool),
+		deniedSession: make(map[string]bool),
+		revokedBefore: make(map[int32]time.Time),
+	}
+}
+
+func (m *memoryDenylist) DenyAccessToken(_ context.Context, jti string, _ time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.denied[jti] = true
+	return nil
+}
+
+func (m *memoryDenylist) IsAccessTokenDenied(_ context.Context, jti string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.denied[jti], nil
+}
+
+func (m *memoryDenylist) DenySession(_ context.Context, sessionID string, _ time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.deniedSession[sessionID] = true
+	return nil
+}
+
+func (m *memoryDenylist) IsSessionDenied(_ context.Context, sessionID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.deniedSession[sessionID], nil
+}
+
+func (m *memoryDenylist) SetRevokedBefore(_ context.Context, userID int32, before time.Time, _ time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.revokedBefore[userID] = before
+	return nil
+}
+
+func (m *memoryDenylist) RevokedBefore(_ context.Context, userID int32) (time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.revokedBefore[userID], nil
+}
+
+type noopRefreshDeleter struct{}
+
+func (noopRefreshDeleter) DeleteRefreshToken(context.Context, int32, string) error { return nil }
+func (noopRefreshDeleter) DeleteAllRefreshTokens(context.Context, int32) error     { return nil }
+
+var errReused = errors.New("reused")
+
+// reusedRotator reports every rotated token as already used
+type reusedRotator struct {
+	userID string
+	family string
+}
+
+func (r reusedRotator) RotateRefreshToken(context.Context, string, string, string, models.Session, time.Duration) (string, string, error) {
+	return r.userID, r.family, errReused
+}
+
+func newTestManager(t *testing.T) *TokenManager {
+	key, err := NewHMACKey("secret")
+	require.NoError(t, err)
+
+	return NewTokenManager(offlog.New(), NewKeyRing(key), testIssuer, testAudience, time.Minute, time.Hour, nil, nil, noopRefreshDeleter{}, newMemoryDenylist(), nil, nil, nil)
+}
+
+func TestRevokeAccessToken(t *testing.T) {
+	ctx := context.Background()
+	manager := newTestManager(t)
+
+	revoked, err := manager.NewAccessToken(ctx, models.User{ID: 1}, "sid")
+	require.NoError(t, err)
+	other, err := manager.NewAccessToken(ctx, models.User{ID: 1}, "sid")
+	require.NoError(t, err)
+
+	require.NoError(t, manager.RevokeAccessToken(ctx, revoked))
+
+	_, err = manager.ValidateAccessToken(ctx, revoked)
+	require.ErrorIs(t, err, ErrTokenRevoked)
+
+	_, err = manager.ValidateAccessToken(ctx, other)
+	require.NoError(t, err)
+}
+
+func TestRevokeSession(t *testing.T) {
+	ctx := context.Background()
+	manager := newTestManager(t)
+
+	revoked, err := manager.NewAccessToken(ctx, models.User{ID: 1}, "other device")
+	require.NoError(t, err)
+	current, err := manager.NewAccessToken(ctx, models.User{ID: 1}, "sid")
+	require.NoError(t, err)
+
+	require.NoError(t, manager.RevokeSession(ctx, "other device"))
+
+	_, err = manager.ValidateAccessToken(ctx, revoked)
+	require.ErrorIs(t, err, ErrTokenRevoked)
+
+	_, err = manager.ValidateAccessToken(ctx, current)
+	require.NoError(t, err)
+}
+
+func TestRotateRefreshToken_ReuseRevokesSession(t *testing.T) {
+	ctx := context.Background()
+	key, err := NewHMACKey("secret")
+	require.NoError(t, err)
+	manager := NewTokenManager(offlog.New(), NewKeyRing(key), testIssuer, testAudience, time.Minute, time.Hour, nil, reusedRotator{userID: "1", family: "compromised"}, noopRefreshDeleter{}, newMemoryDenylist(), nil, nil, nil)
+
+	compromised, err := manager.NewAccessToken(ctx, models.User{ID: 1}, "compromised")
+	require.NoError(t, err)
+	other, err := manager.NewAccessToken(ctx, models.User{ID: 1}, "other device")
+	require.NoError(t, err)
+
+	_, err = manager.RotateRefreshToken(ctx, "stolen", "fingerprint")
+	require.ErrorIs(t, err, errReused)
+
+	_, err = manager.ValidateAccessToken(ctx, compromised)
+	require.ErrorIs(t, err, ErrTokenRevoked)
+
+	_, err = manager.ValidateAccessToken(ctx, other)
+	require.NoError(t, err)
+}
+
+func TestRevokeAllTokens(t *testing.T) {
+	ctx := context.Background()
+	manager := newTestManager(t)
+
+	token, err := manager.NewAccessToken(ctx, models.User{ID: 1}, "sid")
+	require.NoError(t, err)
+	otherUser, err := manager.NewAccessToken(ctx, models.User{ID: 2}, "sid")
+	require.NoError(t, err)
+
+	require.NoError(t, manager.RevokeAllTokens(ctx, 1))
+
+	_, err = manager.ValidateAccessToken(ctx, token)
+	require.ErrorIs(t, err, ErrTokenRevoked)
+
+	_, err = manager.ValidateAccessToken(ctx, otherUser)
+	require.NoError(t, err)
+}
+
+func TestRevokeAllTokens_SameSecond(t *testing.T) {
+	ctx := context.Background()
+	manager := newTestManager(t)
+
+	// leave enough time to stay in the same second
+	if now := time.Now(); now.Nanosecond() > 900*int(time.Millisecond) {
+		time.Sleep(time.Second - time.Duration(now.Nanosecond()))
+	}
+
+	before, err := manager.NewAccessToken(ctx, models.User{ID: 1}, "sid")
+	require.NoError(t, err)
+
+	require.NoError(t, manager.RevokeAllTokens(ctx, 1))
+
+	// e.g. the pair issued by ResetPassword right after revocation
+	after, err := manager.NewAccessToken(ctx, models.User{ID: 1}, "sid")
+	require.NoError(t, err)
+
+	_, err = manager.ValidateAccessToken(ctx, before)
+	require.ErrorIs(t, err, ErrTokenRevoked)
+
+	claims, err := manager.ValidateAccessToken(ctx, after)
+	require.NoError(t, err)
+	require.Equal(t, time.Now().Unix(), claims.IssuedAt.Unix())
+}
+
+func TestAccessTokenClaims(t *testing.T) {
+	ctx := context.Background()
+	manager := newTestManager(t)
+
+	user := models.User{ID: 7, EmailVerified: true, Roles: []string{"user", "admin"}}
+	token, err := manager.NewAccessToken(ctx, user, "session")
+	require.NoError(t, err)
+
+	claims, err := manager.ValidateAccessToken(ctx, token)
+	require.NoError(t, err)
+	require.Equal(t, int32(7), claims.UserID)
+	require.True(t, claims.EmailVerified)
+	require.Equal(t, []string{"user", "admin"}, claims.Roles)
+	require.Equal(t, "session", claims.SessionID)
+	require.NotEmpty(t, claims.ID)
+}
+
+func TestValidateAccessToken_IssuerAndAudience(t *testing.T) {
+	ctx := context.Background()
+	key, err := NewHMACKey("secret")
+	require.NoError(t, err)
+
+	verifier := NewTokenManager(offlog.New(), NewKeyRing(key), testIssuer, testAudience, time.Minute, time.Hour, nil, nil, nil, newMemoryDenylist(), nil, nil, nil)
+
+	tests := []struct {
+		name     string
+		issuer   string
+		audience string
+	}{
+		{name: "wrong issuer", issuer: "other", audience: testAudience},
+		{name: "wrong audience", issuer: testIssuer, audience: "other"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer := NewTokenManager(offlog.New(), NewKeyRing(key), tt.issuer, tt.audience, time.Minute, time.Hour, nil, nil, nil, newMemoryDenylist(), nil, nil, nil)
+			token, err := signer.NewAccessToken(ctx, models.User{ID: 1}, "sid")
+			require.NoError(t, err)
+
+			_, err = verifier.ValidateAccessToken(ctx, token)
+			require.ErrorIs(t, err, ErrInvalidClaims)
+		})
+	}
+}
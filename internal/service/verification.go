@@ -2,11 +2,11 @@ package service
 
 import (
 	"context"
+	"crypto/rand"
 	"errors"
 	"fmt"
 	"log/slog"
-
-	"math/rand"
+	"math/big"
 
 	"github.com/kuromii5/sync-auth/internal/models"
 	"github.com/kuromii5/sync-auth/internal/repo/redis"
@@ -16,15 +16,16 @@ import (
 func (a *Auth) VerifyEmail(ctx context.Context, accessToken string) (models.VerifyEmailResp, error) {
 	const f = "auth.VerifyEmail"
 
-	log := a.log.With(slog.String("func", f))
+	log := a.logger(ctx).With(slog.String("func", f))
 	log.Info("verifying user email")
 
-	userID, err := a.accessTokenManager.ValidateAccessToken(ctx, accessToken)
+	claims, err := a.accessTokenManager.ValidateAccessToken(ctx, accessToken)
 	if err != nil {
 		log.Warn("failed to validate access token", le.Err(err))
 
 		return models.VerifyEmailResp{}, fmt.Errorf("%s:%w", f, err)
 	}
+	userID := claims.UserID
 
 	user, err := a.userProvider.UserByID(ctx, userID)
 	if err != nil {
@@ -32,10 +33,21 @@ func (a *Auth) VerifyEmail(ctx context.Context, accessToken string) (models.Veri
 
 		return models.VerifyEmailResp{}, fmt.Errorf("%s:%w", f, err)
 	}
-	email := user.Email
 
-	// 6 digit code
-	code := rand.Int31n(899_999) + 100_000
+	limit, err := a.allowCodeSend(ctx, userID, verificationCodeFlow)
+	if err != nil {
+		log.Warn("code send is limited", le.Err(err))
+
+		return models.VerifyEmailResp{ResendAfter: limit.RetryAfter, SendsLeft: limit.SendsLeft}, fmt.Errorf("%s:%w", f, err)
+	}
+
+	code, err := verificationCode()
+	if err != nil {
+		log.Error("failed to generate verification code", le.Err(err))
+
+		return models.VerifyEmailResp{}, fmt.Errorf("%s:%w", f, err)
+	}
+
 	err = a.codeManager.SetCode(ctx, code, userID, a.VerificationManager.CodeTTL)
 	if err != nil {
 		log.Error("failed to save verification code", le.Err(err))
@@ -43,7 +55,7 @@ func (a *Auth) VerifyEmail(ctx context.Context, accessToken string) (models.Veri
 		return models.VerifyEmailResp{}, fmt.Errorf("%s:%w", f, err)
 	}
 
-	err = a.VerificationManager.SendCode(email, code)
+	err = a.VerificationManager.SendCode(ctx, user.Email, locale(ctx, user), code)
 	if err != nil {
 		log.Error("failed to send verification code on email", le.Err(err))
 
@@ -53,23 +65,26 @@ func (a *Auth) VerifyEmail(ctx context.Context, accessToken string) (models.Veri
 	log.Info("code was successfully sent")
 
 	return models.VerifyEmailResp{
-		Status:  "code sent",
-		CodeTTL: a.VerificationManager.CodeTTL,
+		Status:      "code sent",
+		CodeTTL:     a.VerificationManager.CodeTTL,
+		ResendAfter: limit.RetryAfter,
+		SendsLeft:   limit.SendsLeft,
 	}, nil
 }
 
 func (a *Auth) ConfirmCode(ctx context.Context, code int32, accessToken string) (models.ConfirmCodeResp, error) {
 	const f = "auth.ConfirmCode"
 
-	log := a.log.With(slog.String("func", f))
+	log := a.logger(ctx).With(slog.String("func", f))
 	log.Info("confirming verification code")
 
-	userID, err := a.accessTokenManager.ValidateAccessToken(ctx, accessToken)
+	claims, err := a.accessTokenManager.ValidateAccessToken(ctx, accessToken)
 	if err != nil {
 		log.Warn("failed to validate access token", le.Err(err))
 
 		return models.ConfirmCodeResp{}, fmt.Errorf("%s:%w", f, err)
 	}
+	userID := claims.UserID
 
 	realCode, err := a.codeManager.Code(ctx, userID)
 	if err != nil {
@@ -87,11 +102,33 @@ func (a *Auth) ConfirmCode(ctx context.Context, code int32, accessToken string)
 	}
 
 	if realCode != code {
-		log.Warn("user entered incorrect code", le.Err(err))
+		log.Warn("user entered incorrect code")
+
+		failed, err := a.codeManager.FailCodeAttempt(ctx, userID, a.VerificationManager.CodeTTL)
+		if err != nil {
+			log.Error("failed to count code attempt", le.Err(err))
+
+			return models.ConfirmCodeResp{}, fmt.Errorf("%s:%w", f, err)
+		}
+
+		attemptsLeft := a.VerificationManager.CodeLimits.MaxAttempts - failed
+		if attemptsLeft <= 0 {
+			if err := a.codeManager.DeleteCode(ctx, userID); err != nil {
+				log.Error("failed to delete code from storage", le.Err(err))
+
+				return models.ConfirmCodeResp{}, fmt.Errorf("%s:%w", f, err)
+			}
+
+			return models.ConfirmCodeResp{
+				Success: false,
+				Message: "Too many attempts, request a new code",
+			}, nil
+		}
 
 		return models.ConfirmCodeResp{
-			Success: false,
-			Message: "Incorrect code",
+			Success:      false,
+			Message:      "Incorrect code",
+			AttemptsLeft: attemptsLeft,
 		}, nil
 	}
 
@@ -114,3 +151,34 @@ func (a *Auth) ConfirmCode(ctx context.Context, code int32, accessToken string)
 		Message: "Code confirmed",
 	}, nil
 }
+
+// Flows of code sends, each has own cooldown and daily limit, so unauthenticated
+// passwordless login can't exhaust sends of email verification
+const (
+	verificationCodeFlow = "verification"
+	emailChangeCodeFlow  = "email_change"
+	passwordlessCodeFlow = "passwordless"
+)
+
+// allowCodeSend applies resend cooldown and daily limit of codes of the flow,
+// ErrTooManyRequests is returned with time to wait
+func (a *Auth) allowCodeSend(ctx context.Context, userID int32, flow string) (models.CodeSendLimit, error) {
+	limits := a.VerificationManager.CodeLimits
+
+	limit, err := a.codeManager.AllowCodeSend(ctx, userID, flow, limits.ResendCooldown, limits.DailyLimit)
+	if errors.Is(err, redis.ErrCodeCooldown) || errors.Is(err, redis.ErrCodeDailyLimit) {
+		return limit, fmt.Errorf("%w: %w", ErrTooManyRequests, err)
+	}
+
+	return limit, err
+}
+
+// verificationCode returns random 6 digit code
+func verificationCode() (int32, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(900_000))
+	if err != nil {
+		return 0, err
+	}
+
+	return int32(n.Int64()) + 100_000, nil
+}
@@ -0,0 +1,85 @@
+package verification
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidMagicLink = errors.New("invalid or expired magic link")
+
+// MagicLinks signs tokens of passwordless login links with HMAC-SHA256.
+// Token carries user id, single-use nonce and expiration time
+type MagicLinks struct {
+	secret []byte
+	url    string
+	TTL    time.Duration
+}
+
+func NewMagicLinks(secret []byte, url string, TTL time.Duration) *MagicLinks {
+	return &MagicLinks{secret: secret, url: url, TTL: TTL}
+}
+
+// Token returns signed token which expires after TTL
+func (m *MagicLinks) Token(userID int32, nonce string) string {
+	payload := fmt.Sprintf("%d:%s:%d", userID, nonce, time.Now().Add(m.TTL).Unix())
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+
+	return encoded + "." + m.sign(encoded)
+}
+
+// Parse checks signature and expiration of the token and returns its user id and nonce
+func (m *MagicLinks) Parse(token string) (int32, string, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(m.sign(encoded))) {
+		return 0, "", ErrInvalidMagicLink
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return 0, "", ErrInvalidMagicLink
+	}
+
+	parts := strings.Split(string(payload), ":")
+	if len(parts) != 3 {
+		return 0, "", ErrInvalidMagicLink
+	}
+
+	userID, err := strconv.ParseInt(parts[0], 10, 32)
+	if err != nil {
+		return 0, "", ErrInvalidMagicLink
+	}
+
+	expires, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || time.Now().Unix() >= expires {
+		return 0, "", ErrInvalidMagicLink
+	}
+
+	return int32(userID), parts[1], nil
+}
+
+// Link returns login page url with token query parameter
+func (m *MagicLinks) Link(token string) (string, error) {
+	link, err := url.Parse(m.url)
+	if err != nil {
+		return "", err
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	return link.String(), nil
+}
+
+func (m *MagicLinks) sign(encoded string) string {
+	mac := hmac.New(sha256.New, m.secret)
+	mac.Write([]byte(encoded))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
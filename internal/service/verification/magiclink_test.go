@@ -0,0 +1,42 @@
+package verification
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMagicLinks(t *testing.T) {
+	links := NewMagicLinks([]byte("secret"), "https://app.example.com/login?app=1", time.Minute)
+
+	token := links.Token(42, "nonce")
+
+	userID, nonce, err := links.Parse(token)
+	require.NoError(t, err)
+	assert.Equal(t, int32(42), userID)
+	assert.Equal(t, "nonce", nonce)
+
+	link, err := links.Link(token)
+	require.NoError(t, err)
+	assert.Equal(t, "https://app.example.com/login?app=1&token="+token, link)
+}
+
+func TestMagicLinks_Invalid(t *testing.T) {
+	links := NewMagicLinks([]byte("secret"), "https://app.example.com/login", time.Minute)
+	token := links.Token(42, "nonce")
+	encoded, signature, _ := strings.Cut(token, ".")
+
+	expired := NewMagicLinks([]byte("secret"), "", -time.Minute).Token(42, "nonce")
+	otherKey := NewMagicLinks([]byte("other"), "", time.Minute).Token(42, "nonce")
+	// payload of another user with the original signature
+	forged := NewMagicLinks([]byte("other"), "", time.Minute).Token(1, "nonce")
+	forged = strings.SplitN(forged, ".", 2)[0] + "." + signature
+
+	for _, token := range []string{"", "garbage", encoded, expired, otherKey, forged} {
+		_, _, err := links.Parse(token)
+		assert.ErrorIs(t, err, ErrInvalidMagicLink, token)
+	}
+}
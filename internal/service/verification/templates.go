@@ -0,0 +1,173 @@
+package verification
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/kuromii5/sync-auth/internal/mail"
+	"golang.org/x/text/language"
+)
+
+// Email templates
+const (
+	TemplateVerification      = "verification"
+	TemplatePasswordReset     = "password_reset"
+	TemplateEmailChangeCode   = "email_change_code"
+	TemplateEmailChangeNotice = "email_change_notice"
+	TemplateNewDevice         = "new_device"
+	TemplateAccountDeletion   = "account_deletion"
+	TemplatePasswordlessLogin = "passwordless_login"
+)
+
+//go:embed templates
+var builtinTemplates embed.FS
+
+// BuiltinTemplates returns templates shipped with the service (en and ru)
+func BuiltinTemplates() fs.FS {
+	templates, _ := fs.Sub(builtinTemplates, "templates")
+	return templates
+}
+
+var templateFuncs = map[string]any{
+	"minutes": func(d time.Duration) int { return int(d.Round(time.Minute).Minutes()) },
+}
+
+type localeTemplate struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// Templates renders localized multipart emails. Every locale is a directory
+// with layout.html and a pair of files for each template: <name>.txt defines
+// "subject" and "text", <name>.html defines "content" shown inside the layout
+type Templates struct {
+	locales   map[string]map[string]localeTemplate
+	supported []string
+	matcher   language.Matcher
+}
+
+// LoadTemplates parses templates from fsys. Templates missing in a locale are
+// taken from defaultLocale
+func LoadTemplates(fsys fs.FS, defaultLocale string) (*Templates, error) {
+	const f = "verification.LoadTemplates"
+
+	dirs, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", f, err)
+	}
+
+	t := &Templates{locales: make(map[string]map[string]localeTemplate)}
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+
+		templates, err := loadLocale(fsys, dir.Name())
+		if err != nil {
+			return nil, fmt.Errorf("%s:locale %s:%w", f, dir.Name(), err)
+		}
+		t.locales[dir.Name()] = templates
+	}
+
+	if _, ok := t.locales[defaultLocale]; !ok {
+		return nil, fmt.Errorf("%s:no templates for default locale %q", f, defaultLocale)
+	}
+
+	// the first tag is returned when nothing matches
+	t.supported = append(t.supported, defaultLocale)
+	for locale := range t.locales {
+		if locale != defaultLocale {
+			t.supported = append(t.supported, locale)
+		}
+	}
+
+	tags := make([]language.Tag, 0, len(t.supported))
+	for _, locale := range t.supported {
+		tag, err := language.Parse(locale)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%w", f, err)
+		}
+		tags = append(tags, tag)
+	}
+	t.matcher = language.NewMatcher(tags)
+
+	return t, nil
+}
+
+func loadLocale(fsys fs.FS, locale string) (map[string]localeTemplate, error) {
+	layout := path.Join(locale, "layout.html")
+
+	texts, err := fs.Glob(fsys, path.Join(locale, "*.txt"))
+	if err != nil {
+		return nil, err
+	}
+
+	templates := make(map[string]localeTemplate, len(texts))
+	for _, textFile := range texts {
+		name := strings.TrimSuffix(path.Base(textFile), ".txt")
+		htmlFile := path.Join(locale, name+".html")
+
+		text, err := texttemplate.New(name).Funcs(templateFuncs).ParseFS(fsys, textFile)
+		if err != nil {
+			return nil, err
+		}
+
+		// subject is used as title of the page
+		html, err := htmltemplate.New(name).Funcs(templateFuncs).ParseFS(fsys, layout, textFile, htmlFile)
+		if err != nil {
+			return nil, err
+		}
+
+		templates[name] = localeTemplate{text: text, html: html}
+	}
+
+	return templates, nil
+}
+
+// Locale returns the best supported locale for BCP 47 tag or Accept-Language
+// header value, the default one if nothing matches
+func (t *Templates) Locale(locale string) string {
+	tags, _, err := language.ParseAcceptLanguage(locale)
+	if err != nil || len(tags) == 0 {
+		return t.supported[0]
+	}
+
+	_, index, _ := t.matcher.Match(tags...)
+
+	return t.supported[index]
+}
+
+// Render executes template in the locale best matching given one
+func (t *Templates) Render(name, locale string, data any) (mail.Message, error) {
+	tmpl, ok := t.locales[t.Locale(locale)][name]
+	if !ok {
+		tmpl, ok = t.locales[t.supported[0]][name]
+	}
+	if !ok {
+		return mail.Message{}, fmt.Errorf("unknown email template %q", name)
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := tmpl.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return mail.Message{}, err
+	}
+	if err := tmpl.text.ExecuteTemplate(&text, "text", data); err != nil {
+		return mail.Message{}, err
+	}
+	if err := tmpl.html.ExecuteTemplate(&html, "layout", data); err != nil {
+		return mail.Message{}, err
+	}
+
+	return mail.Message{
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    strings.TrimSpace(text.String()),
+		HTML:    html.String(),
+	}, nil
+}
@@ -0,0 +1,74 @@
+package verification
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplates_Locale(t *testing.T) {
+	templates, err := LoadTemplates(BuiltinTemplates(), "en")
+	require.NoError(t, err)
+
+	tests := []struct {
+		locale string
+		want   string
+	}{
+		{locale: "", want: "en"},
+		{locale: "ru", want: "ru"},
+		{locale: "ru-RU", want: "ru"},
+		{locale: "de-DE,ru;q=0.8,en;q=0.5", want: "ru"},
+		{locale: "de", want: "en"},
+		{locale: "not a locale", want: "en"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, templates.Locale(tt.locale), tt.locale)
+	}
+}
+
+func TestTemplates_EveryLocaleHasEveryTemplate(t *testing.T) {
+	templates, err := LoadTemplates(BuiltinTemplates(), "en")
+	require.NoError(t, err)
+
+	names := []string{
+		TemplateVerification,
+		TemplatePasswordReset,
+		TemplateEmailChangeCode,
+		TemplateEmailChangeNotice,
+		TemplateNewDevice,
+		TemplateAccountDeletion,
+		TemplatePasswordlessLogin,
+	}
+	for locale, localeTemplates := range templates.locales {
+		for _, name := range names {
+			assert.Contains(t, localeTemplates, name, "%s/%s", locale, name)
+		}
+	}
+}
+
+func TestTemplates_FallbackToDefaultLocale(t *testing.T) {
+	fsys := fstest.MapFS{
+		"en/layout.html": {Data: []byte(`{{define "layout"}}{{template "content" .}}{{end}}`)},
+		"en/hello.txt":   {Data: []byte(`{{define "subject"}}Hello{{end}}{{define "text"}}Hello, {{.Name}}{{end}}`)},
+		"en/hello.html":  {Data: []byte(`{{define "content"}}<p>Hello, {{.Name}}</p>{{end}}`)},
+		"ru/layout.html": {Data: []byte(`{{define "layout"}}{{template "content" .}}{{end}}`)},
+	}
+	templates, err := LoadTemplates(fsys, "en")
+	require.NoError(t, err)
+
+	msg, err := templates.Render("hello", "ru", map[string]any{"Name": "<b>"})
+	require.NoError(t, err)
+	assert.Equal(t, "Hello", msg.Subject)
+	assert.Equal(t, "Hello, <b>", msg.Text)
+	assert.Equal(t, "<p>Hello, &lt;b&gt;</p>", msg.HTML)
+
+	_, err = templates.Render("unknown", "en", nil)
+	assert.Error(t, err)
+}
+
+func TestLoadTemplates_MissingDefaultLocale(t *testing.T) {
+	_, err := LoadTemplates(BuiltinTemplates(), "fr")
+	assert.Error(t, err)
+}
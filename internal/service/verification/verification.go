@@ -1,58 +1,217 @@
 package verification
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
-	"net/smtp"
+	"net/url"
 	"time"
 
+	"github.com/kuromii5/sync-auth/internal/client"
+	"github.com/kuromii5/sync-auth/internal/mail"
 	le "github.com/kuromii5/sync-auth/pkg/logger/l_err"
 )
 
+// Outbox stores messages until they are delivered
+type Outbox interface {
+	EnqueueEmail(ctx context.Context, msg mail.Message) error
+}
+
+// CodeLimits protect verification codes from spam and guessing
+type CodeLimits struct {
+	ResendCooldown time.Duration
+	DailyLimit     int
+	// Code is invalidated after MaxAttempts wrong guesses
+	MaxAttempts int
+}
+
+// VerificationManager renders emails from templates and puts them to the
+// outbox. Locale of the recipient is BCP 47 tag or Accept-Language value,
+// empty means the default one
 type VerificationManager struct {
-	log         *slog.Logger
-	CodeTTL     time.Duration
-	appEmail    string
-	appPassword string
-	appSmtpHost string
+	log              *slog.Logger
+	outbox           Outbox
+	templates        *Templates
+	CodeTTL          time.Duration
+	CodeLimits       CodeLimits
+	PasswordResetTTL time.Duration
+	passwordResetURL string
+	MagicLinks       *MagicLinks
 }
 
 func NewVerificationManager(
 	log *slog.Logger,
+	outbox Outbox,
+	templates *Templates,
 	CodeTTL time.Duration,
-	appEmail, appPassword, appSmtpHost string,
+	CodeLimits CodeLimits,
+	PasswordResetTTL time.Duration,
+	passwordResetURL string,
+	MagicLinks *MagicLinks,
 ) *VerificationManager {
 	return &VerificationManager{
-		log:         log,
-		CodeTTL:     CodeTTL,
-		appEmail:    appEmail,
-		appPassword: appPassword,
-		appSmtpHost: appSmtpHost,
+		log:              log,
+		outbox:           outbox,
+		templates:        templates,
+		CodeTTL:          CodeTTL,
+		CodeLimits:       CodeLimits,
+		PasswordResetTTL: PasswordResetTTL,
+		passwordResetURL: passwordResetURL,
+		MagicLinks:       MagicLinks,
 	}
 }
 
-func (v *VerificationManager) SendCode(email string, code int32) error {
+func (v *VerificationManager) SendCode(ctx context.Context, email, locale string, code int32) error {
 	const f = "verification.SendCode"
 
-	smtpPort := "587"
+	data := map[string]any{
+		"Code": code,
+		"TTL":  v.CodeTTL,
+	}
+	if err := v.send(ctx, email, locale, TemplateVerification, data); err != nil {
+		v.log.Error("Failed to enqueue verification email", le.Err(err))
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
 
-	auth := smtp.PlainAuth("", v.appEmail, v.appPassword, v.appSmtpHost)
+	v.log.Info("verification code queued for email", slog.String("email", email))
 
-	from := v.appEmail
-	to := []string{email}
+	return nil
+}
 
-	subject := "Email Verification Code"
-	body := fmt.Sprintf("Your verification code is: %d\nThis code is valid for %s.", code, v.CodeTTL)
-	msg := []byte(fmt.Sprintf("To: %s\r\nSubject: %s\r\n\r\n%s", email, subject, body))
+// SendPasswordReset sends link with one-time reset token
+func (v *VerificationManager) SendPasswordReset(ctx context.Context, email, locale, token string) error {
+	const f = "verification.SendPasswordReset"
 
-	err := smtp.SendMail(fmt.Sprintf("%s:%s", v.appSmtpHost, smtpPort), auth, from, to, msg)
+	link, err := url.Parse(v.passwordResetURL)
 	if err != nil {
-		v.log.Error("Failed to send verification email", le.Err(err))
+		return fmt.Errorf("%s:%w", f, err)
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	data := map[string]any{
+		"Link": link.String(),
+		"TTL":  v.PasswordResetTTL,
+	}
+	if err := v.send(ctx, email, locale, TemplatePasswordReset, data); err != nil {
+		v.log.Error("Failed to enqueue password reset email", le.Err(err))
 
 		return fmt.Errorf("%s:%w", f, err)
 	}
 
-	v.log.Info("verification code sent to email", slog.String("email", email))
+	v.log.Info("password reset link queued for email", slog.String("email", email))
 
 	return nil
 }
+
+// SendPasswordlessLogin sends one-time code and magic link with the signed token
+func (v *VerificationManager) SendPasswordlessLogin(ctx context.Context, email, locale string, code int32, token string) error {
+	const f = "verification.SendPasswordlessLogin"
+
+	link, err := v.MagicLinks.Link(token)
+	if err != nil {
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	data := map[string]any{
+		"Code": code,
+		"Link": link,
+		"TTL":  v.MagicLinks.TTL,
+	}
+	if err := v.send(ctx, email, locale, TemplatePasswordlessLogin, data); err != nil {
+		v.log.Error("Failed to enqueue passwordless login email", le.Err(err))
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	v.log.Info("passwordless login code queued for email", slog.String("email", email))
+
+	return nil
+}
+
+// SendEmailChangeCode sends confirmation code to the new address
+func (v *VerificationManager) SendEmailChangeCode(ctx context.Context, email, locale string, code int32) error {
+	const f = "verification.SendEmailChangeCode"
+
+	data := map[string]any{
+		"Code": code,
+		"TTL":  v.CodeTTL,
+	}
+	if err := v.send(ctx, email, locale, TemplateEmailChangeCode, data); err != nil {
+		v.log.Error("Failed to enqueue email change code", le.Err(err))
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	v.log.Info("email change code queued for email", slog.String("email", email))
+
+	return nil
+}
+
+// SendEmailChangeNotice warns the current address about requested change
+func (v *VerificationManager) SendEmailChangeNotice(ctx context.Context, email, locale, newEmail string) error {
+	const f = "verification.SendEmailChangeNotice"
+
+	data := map[string]any{
+		"NewEmail": newEmail,
+	}
+	if err := v.send(ctx, email, locale, TemplateEmailChangeNotice, data); err != nil {
+		v.log.Error("Failed to enqueue email change notice", le.Err(err))
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	v.log.Info("email change notice queued for email", slog.String("email", email))
+
+	return nil
+}
+
+// SendNewDeviceAlert tells the user about sign in from unknown device
+func (v *VerificationManager) SendNewDeviceAlert(ctx context.Context, email, locale string, device client.Info, at time.Time) error {
+	const f = "verification.SendNewDeviceAlert"
+
+	data := map[string]any{
+		"IP":        device.IP,
+		"UserAgent": device.UserAgent,
+		"Time":      at,
+	}
+	if err := v.send(ctx, email, locale, TemplateNewDevice, data); err != nil {
+		v.log.Error("Failed to enqueue new device alert", le.Err(err))
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	v.log.Info("new device alert queued for email", slog.String("email", email))
+
+	return nil
+}
+
+// SendAccountDeletionNotice confirms that account with the email was deleted
+func (v *VerificationManager) SendAccountDeletionNotice(ctx context.Context, email, locale string) error {
+	const f = "verification.SendAccountDeletionNotice"
+
+	data := map[string]any{
+		"Email": email,
+	}
+	if err := v.send(ctx, email, locale, TemplateAccountDeletion, data); err != nil {
+		v.log.Error("Failed to enqueue account deletion notice", le.Err(err))
+
+		return fmt.Errorf("%s:%w", f, err)
+	}
+
+	v.log.Info("account deletion notice queued for email", slog.String("email", email))
+
+	return nil
+}
+
+func (v *VerificationManager) send(ctx context.Context, email, locale, template string, data any) error {
+	msg, err := v.templates.Render(template, locale, data)
+	if err != nil {
+		return err
+	}
+	msg.To = email
+
+	return v.outbox.EnqueueEmail(ctx, msg)
+}
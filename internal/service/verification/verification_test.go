@@ -0,0 +1,120 @@
+package verification
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kuromii5/sync-auth/internal/client"
+	"github.com/kuromii5/sync-auth/internal/mail"
+	offlog "github.com/kuromii5/sync-auth/pkg/logger/off"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryOutbox records enqueued messages
+type memoryOutbox struct {
+	messages []mail.Message
+	err      error
+}
+
+func (o *memoryOutbox) EnqueueEmail(_ context.Context, msg mail.Message) error {
+	if o.err != nil {
+		return o.err
+	}
+	o.messages = append(o.messages, msg)
+
+	return nil
+}
+
+func (o *memoryOutbox) Last() (mail.Message, bool) {
+	if len(o.messages) == 0 {
+		return mail.Message{}, false
+	}
+
+	return o.messages[len(o.messages)-1], true
+}
+
+func newTestManager(t *testing.T) (*VerificationManager, *memoryOutbox) {
+	t.Helper()
+
+	templates, err := LoadTemplates(BuiltinTemplates(), "en")
+	require.NoError(t, err)
+
+	outbox := &memoryOutbox{}
+	manager := NewVerificationManager(offlog.New(), outbox, templates, 2*time.Minute, CodeLimits{}, 30*time.Minute, "https://app.example.com/reset?lang=en",
+		NewMagicLinks([]byte("secret"), "https://app.example.com/login", 15*time.Minute))
+
+	return manager, outbox
+}
+
+func TestSendCode(t *testing.T) {
+	manager, outbox := newTestManager(t)
+
+	require.NoError(t, manager.SendCode(context.Background(), "user@example.com", "", 123456))
+
+	msg, ok := outbox.Last()
+	require.True(t, ok)
+	assert.Equal(t, "user@example.com", msg.To)
+	assert.Equal(t, "Email verification code", msg.Subject)
+	assert.Contains(t, msg.Text, "123456")
+	assert.Contains(t, msg.Text, "2 min")
+	assert.Contains(t, msg.HTML, "123456")
+}
+
+func TestSendCode_Localized(t *testing.T) {
+	manager, outbox := newTestManager(t)
+
+	require.NoError(t, manager.SendCode(context.Background(), "user@example.com", "ru-RU,ru;q=0.9,en;q=0.8", 123456))
+
+	msg, ok := outbox.Last()
+	require.True(t, ok)
+	assert.Equal(t, "Код подтверждения почты", msg.Subject)
+	assert.Contains(t, msg.HTML, `<html lang="ru">`)
+}
+
+func TestSendPasswordReset(t *testing.T) {
+	manager, outbox := newTestManager(t)
+
+	require.NoError(t, manager.SendPasswordReset(context.Background(), "user@example.com", "en", "tok/en"))
+
+	msg, ok := outbox.Last()
+	require.True(t, ok)
+	assert.Contains(t, msg.Text, "https://app.example.com/reset?lang=en&token=tok%2Fen")
+	// link is escaped in attribute
+	assert.Contains(t, msg.HTML, `href="https://app.example.com/reset?lang=en&amp;token=tok%2Fen"`)
+}
+
+func TestSendPasswordlessLogin(t *testing.T) {
+	manager, outbox := newTestManager(t)
+
+	require.NoError(t, manager.SendPasswordlessLogin(context.Background(), "user@example.com", "", 123456, "payload.signature"))
+
+	msg, ok := outbox.Last()
+	require.True(t, ok)
+	assert.Contains(t, msg.Text, "123456")
+	assert.Contains(t, msg.Text, "https://app.example.com/login?token=payload.signature")
+	assert.Contains(t, msg.Text, "15 min")
+}
+
+func TestSendNewDeviceAlert_EscapesUserAgent(t *testing.T) {
+	manager, outbox := newTestManager(t)
+
+	device := client.Info{IP: "203.0.113.7", UserAgent: "<script>alert(1)</script>"}
+	require.NoError(t, manager.SendNewDeviceAlert(context.Background(), "user@example.com", "en", device, time.Now()))
+
+	msg, ok := outbox.Last()
+	require.True(t, ok)
+	assert.Contains(t, msg.Text, "203.0.113.7")
+	assert.NotContains(t, msg.HTML, "<script>")
+}
+
+func TestSendCode_OutboxError(t *testing.T) {
+	manager, outbox := newTestManager(t)
+	failure := errors.New("database is down")
+	outbox.err = failure
+
+	err := manager.SendCode(context.Background(), "user@example.com", "", 123456)
+	assert.ErrorIs(t, err, failure)
+}
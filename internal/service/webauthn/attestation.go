@@ -0,0 +1,123 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+var (
+	ErrUnsupportedAttestation = errors.New("unsupported attestation format")
+	ErrInvalidAttestation     = errors.New("invalid attestation statement")
+)
+
+// id-fido-gen-ce-aaguid extension of attestation certificates
+var oidFIDOAAGUID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 45724, 1, 1, 4}
+
+type attestationObject struct {
+	Fmt      string          `cbor:"fmt"`
+	AttStmt  cbor.RawMessage `cbor:"attStmt"`
+	AuthData []byte          `cbor:"authData"`
+}
+
+type packedStatement struct {
+	Alg        int64    `cbor:"alg"`
+	Sig        []byte   `cbor:"sig"`
+	X5C        [][]byte `cbor:"x5c"`
+	EcdaaKeyID []byte   `cbor:"ecdaaKeyId"`
+}
+
+// verifyAttestation checks attestation statement. Certificates of packed
+// attestation are not chained to vendor roots, so the authenticator model is
+// not trusted, only integrity of the statement is checked
+func verifyAttestation(attestation attestationObject, authData authenticatorData, clientDataHash []byte) error {
+	switch attestation.Fmt {
+	case "none":
+		var statement map[string]any
+		if err := cbor.Unmarshal(attestation.AttStmt, &statement); err != nil || len(statement) != 0 {
+			return ErrInvalidAttestation
+		}
+
+		return nil
+	case "packed":
+		return verifyPacked(attestation, authData, clientDataHash)
+	default:
+		return fmt.Errorf("%w: %q", ErrUnsupportedAttestation, attestation.Fmt)
+	}
+}
+
+// verifyPacked implements packed attestation (WebAuthn §8.2)
+func verifyPacked(attestation attestationObject, authData authenticatorData, clientDataHash []byte) error {
+	var statement packedStatement
+	if err := cbor.Unmarshal(attestation.AttStmt, &statement); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidAttestation, err)
+	}
+	if len(statement.EcdaaKeyID) != 0 {
+		return fmt.Errorf("%w: ecdaa", ErrUnsupportedAttestation)
+	}
+
+	signed := slices.Concat(attestation.AuthData, clientDataHash)
+
+	// self attestation is signed by the credential key itself
+	if len(statement.X5C) == 0 {
+		_, alg, err := parsePublicKey(authData.publicKey)
+		if err != nil {
+			return err
+		}
+		if alg != statement.Alg {
+			return ErrInvalidAttestation
+		}
+
+		if err := verifySignature(authData.publicKey, signed, statement.Sig); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidAttestation, err)
+		}
+
+		return nil
+	}
+
+	cert, err := x509.ParseCertificate(statement.X5C[0])
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidAttestation, err)
+	}
+	if err := checkAttestationCert(cert, authData.aaguid); err != nil {
+		return err
+	}
+
+	if err := verifyWithKey(cert.PublicKey, statement.Alg, signed, statement.Sig); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidAttestation, err)
+	}
+
+	return nil
+}
+
+// checkAttestationCert checks requirements of packed attestation certificates (WebAuthn §8.2.1)
+func checkAttestationCert(cert *x509.Certificate, aaguid []byte) error {
+	if cert.Version != 3 || cert.IsCA || !cert.BasicConstraintsValid {
+		return ErrInvalidAttestation
+	}
+	if !slices.Contains(cert.Subject.OrganizationalUnit, "Authenticator Attestation") ||
+		len(cert.Subject.Organization) == 0 || len(cert.Subject.Country) == 0 || cert.Subject.CommonName == "" {
+		return ErrInvalidAttestation
+	}
+
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidFIDOAAGUID) {
+			continue
+		}
+		if ext.Critical {
+			return ErrInvalidAttestation
+		}
+
+		var certAAGUID []byte
+		if _, err := asn1.Unmarshal(ext.Value, &certAAGUID); err != nil || !bytes.Equal(certAAGUID, aaguid) {
+			return ErrInvalidAttestation
+		}
+	}
+
+	return nil
+}
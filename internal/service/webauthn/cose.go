@@ -0,0 +1,146 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+var ErrUnsupportedAlgorithm = errors.New("unsupported public key algorithm")
+
+// COSE algorithms (RFC 9053), in order of preference
+const (
+	algES256 = -7
+	algEdDSA = -8
+	algRS256 = -257
+)
+
+var supportedAlgorithms = []int64{algES256, algEdDSA, algRS256}
+
+// keyDecoder decodes every integer of COSE_Key as int64
+var keyDecoder, _ = cbor.DecOptions{IntDec: cbor.IntDecConvertSigned}.DecMode()
+
+// COSE key types and curves
+const (
+	ktyOKP = 1
+	ktyEC2 = 2
+	ktyRSA = 3
+
+	crvP256    = 1
+	crvEd25519 = 6
+)
+
+// COSE_Key labels, negative labels depend on key type
+const (
+	labelKty = 1
+	labelAlg = 3
+
+	labelCrv = -1 // EC2, OKP
+	labelX   = -2 // EC2, OKP
+	labelY   = -3 // EC2
+	labelN   = -1 // RSA
+	labelE   = -2 // RSA
+)
+
+// parsePublicKey decodes COSE_Key of the credential
+func parsePublicKey(raw []byte) (crypto.PublicKey, int64, error) {
+	var key map[int64]any
+	if err := keyDecoder.Unmarshal(raw, &key); err != nil {
+		return nil, 0, fmt.Errorf("%w: %w", ErrInvalidCredential, err)
+	}
+
+	kty, _ := key[labelKty].(int64)
+	alg, _ := key[labelAlg].(int64)
+
+	switch {
+	case kty == ktyEC2 && alg == algES256:
+		crv, _ := key[labelCrv].(int64)
+		x, _ := key[labelX].([]byte)
+		y, _ := key[labelY].([]byte)
+		if crv != crvP256 || len(x) != 32 || len(y) != 32 {
+			return nil, 0, ErrInvalidCredential
+		}
+
+		// ecdh checks that the point is on the curve
+		point := slices.Concat([]byte{4}, x, y)
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return nil, 0, fmt.Errorf("%w: %w", ErrInvalidCredential, err)
+		}
+
+		return &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, alg, nil
+
+	case kty == ktyOKP && alg == algEdDSA:
+		crv, _ := key[labelCrv].(int64)
+		x, _ := key[labelX].([]byte)
+		if crv != crvEd25519 || len(x) != ed25519.PublicKeySize {
+			return nil, 0, ErrInvalidCredential
+		}
+
+		return ed25519.PublicKey(x), alg, nil
+
+	case kty == ktyRSA && alg == algRS256:
+		n, _ := key[labelN].([]byte)
+		e, _ := key[labelE].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, 0, ErrInvalidCredential
+		}
+
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, alg, nil
+
+	default:
+		return nil, 0, ErrUnsupportedAlgorithm
+	}
+}
+
+// verifySignature checks signature made with the credential key
+func verifySignature(coseKey, data, signature []byte) error {
+	key, alg, err := parsePublicKey(coseKey)
+	if err != nil {
+		return err
+	}
+
+	return verifyWithKey(key, alg, data, signature)
+}
+
+func verifyWithKey(key crypto.PublicKey, alg int64, data, signature []byte) error {
+	digest := sha256.Sum256(data)
+
+	switch alg {
+	case algES256:
+		pub, ok := key.(*ecdsa.PublicKey)
+		if ok && ecdsa.VerifyASN1(pub, digest[:], signature) {
+			return nil
+		}
+	case algEdDSA:
+		pub, ok := key.(ed25519.PublicKey)
+		if ok && ed25519.Verify(pub, data, signature) {
+			return nil
+		}
+	case algRS256:
+		pub, ok := key.(*rsa.PublicKey)
+		if ok && rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) == nil {
+			return nil
+		}
+	default:
+		return ErrUnsupportedAlgorithm
+	}
+
+	return ErrInvalidSignature
+}
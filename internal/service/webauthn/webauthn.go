@@ -0,0 +1,497 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/kuromii5/sync-auth/internal/models"
+)
+
+var (
+	ErrInvalidCredential = errors.New("malformed webauthn credential")
+	ErrChallengeMismatch = errors.New("webauthn challenge mismatch")
+	ErrOriginNotAllowed  = errors.New("webauthn origin is not allowed")
+	ErrRPIDMismatch      = errors.New("credential belongs to another relying party")
+	ErrUserNotPresent    = errors.New("user presence is not confirmed")
+	ErrUserNotVerified   = errors.New("user verification is required")
+	ErrInvalidSignature  = errors.New("invalid webauthn signature")
+	ErrSignCount         = errors.New("signature counter didn't increase, credential may be cloned")
+)
+
+// authenticator data flags
+const (
+	flagUserPresent   = 0x01
+	flagUserVerified  = 0x04
+	flagBackupAllowed = 0x08
+	flagBackedUp      = 0x10
+	flagAttested      = 0x40
+	flagExtensions    = 0x80
+)
+
+const (
+	ceremonyCreate = "webauthn.create"
+	ceremonyGet    = "webauthn.get"
+
+	challengeBytes     = 32
+	maxCredentialIDLen = 1023
+)
+
+// Config of the relying party. Origins are full origins of pages calling
+// WebAuthn API, e.g. https://app.example.com
+type Config struct {
+	RPID        string
+	RPName      string
+	Origins     []string
+	Timeout     time.Duration
+	Attestation string
+}
+
+// PasskeyManager runs registration and authentication ceremonies. Challenges
+// are generated here, storing them between the two steps is up to the caller
+type PasskeyManager struct {
+	rpID        string
+	rpName      string
+	origins     []string
+	attestation string
+	Timeout     time.Duration
+}
+
+func NewPasskeyManager(cfg Config) *PasskeyManager {
+	return &PasskeyManager{
+		rpID:        cfg.RPID,
+		rpName:      cfg.RPName,
+		origins:     cfg.Origins,
+		attestation: cfg.Attestation,
+		Timeout:     cfg.Timeout,
+	}
+}
+
+// Challenge returns random base64url challenge
+func (m *PasskeyManager) Challenge() (string, error) {
+	b := make([]byte, challengeBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CreationOptions are options of navigator.credentials.create() in JSON form
+// accepted by PublicKeyCredential.parseCreationOptionsFromJSON()
+type CreationOptions struct {
+	Challenge              string                 `json:"challenge"`
+	RP                     RelyingParty           `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions are options of navigator.credentials.get() in JSON form
+// accepted by PublicKeyCredential.parseRequestOptionsFromJSON()
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	Timeout          int64                  `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+type RelyingParty struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type UserEntity struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type       string   `json:"type"`
+	ID         string   `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// CreationOptions asks for a discoverable credential, so it can be used for login without email
+func (m *PasskeyManager) CreationOptions(challenge string, user models.User, registered []models.Passkey) CreationOptions {
+	params := make([]CredentialParameter, 0, len(supportedAlgorithms))
+	for _, alg := range supportedAlgorithms {
+		params = append(params, CredentialParameter{Type: "public-key", Alg: alg})
+	}
+
+	return CreationOptions{
+		Challenge: challenge,
+		RP:        RelyingParty{ID: m.rpID, Name: m.rpName},
+		User: UserEntity{
+			ID:          UserHandle(user.ID),
+			Name:        user.Email,
+			DisplayName: user.Email,
+		},
+		PubKeyCredParams:   params,
+		Timeout:            m.Timeout.Milliseconds(),
+		ExcludeCredentials: descriptors(registered),
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:      "required",
+			UserVerification: "preferred",
+		},
+		Attestation: m.attestation,
+	}
+}
+
+// RequestOptions limits login to allowed credentials, any discoverable credential is accepted if it is empty
+func (m *PasskeyManager) RequestOptions(challenge string, allowed []models.Passkey, userVerification string) RequestOptions {
+	return RequestOptions{
+		Challenge:        challenge,
+		Timeout:          m.Timeout.Milliseconds(),
+		RPID:             m.rpID,
+		AllowCredentials: descriptors(allowed),
+		UserVerification: userVerification,
+	}
+}
+
+func descriptors(passkeys []models.Passkey) []CredentialDescriptor {
+	descriptors := make([]CredentialDescriptor, 0, len(passkeys))
+	for _, passkey := range passkeys {
+		descriptors = append(descriptors, CredentialDescriptor{
+			Type:       "public-key",
+			ID:         base64.RawURLEncoding.EncodeToString(passkey.ID),
+			Transports: passkey.Transports,
+		})
+	}
+
+	return descriptors
+}
+
+// UserHandle is user.id of WebAuthn, it doesn't contain personal data
+func UserHandle(userID int32) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(int(userID))))
+}
+
+// ParseUserHandle returns id of the user from user handle of discoverable credential
+func ParseUserHandle(handle []byte) (int32, error) {
+	id, err := strconv.ParseInt(string(handle), 10, 32)
+	if err != nil {
+		return 0, ErrInvalidCredential
+	}
+
+	return int32(id), nil
+}
+
+// bytesURL is binary field of credential JSON, encoded as base64url
+type bytesURL []byte
+
+func (b *bytesURL) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return err
+	}
+	*b = decoded
+
+	return nil
+}
+
+type registrationResponse struct {
+	ID       string   `json:"id"`
+	RawID    bytesURL `json:"rawId"`
+	Type     string   `json:"type"`
+	Response struct {
+		ClientDataJSON    bytesURL `json:"clientDataJSON"`
+		AttestationObject bytesURL `json:"attestationObject"`
+		Transports        []string `json:"transports"`
+	} `json:"response"`
+}
+
+type assertionResponse struct {
+	ID       string   `json:"id"`
+	RawID    bytesURL `json:"rawId"`
+	Type     string   `json:"type"`
+	Response struct {
+		ClientDataJSON    bytesURL `json:"clientDataJSON"`
+		AuthenticatorData bytesURL `json:"authenticatorData"`
+		Signature         bytesURL `json:"signature"`
+		UserHandle        bytesURL `json:"userHandle"`
+	} `json:"response"`
+}
+
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// Assertion is a parsed response of navigator.credentials.get()
+type Assertion struct {
+	CredentialID []byte
+	UserHandle   []byte
+	Challenge    string
+
+	clientDataJSON []byte
+	authData       []byte
+	signature      []byte
+}
+
+// ParseRegistrationChallenge returns challenge the credential was created for,
+// it identifies the pending ceremony
+func ParseRegistrationChallenge(credentialJSON []byte) (string, error) {
+	var response registrationResponse
+	if err := json.Unmarshal(credentialJSON, &response); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidCredential, err)
+	}
+
+	data, err := parseClientData(response.Response.ClientDataJSON)
+	if err != nil {
+		return "", err
+	}
+
+	return data.Challenge, nil
+}
+
+// ParseAssertion decodes response of navigator.credentials.get(), its
+// signature is checked by VerifyAssertion with the stored credential
+func ParseAssertion(credentialJSON []byte) (Assertion, error) {
+	var response assertionResponse
+	if err := json.Unmarshal(credentialJSON, &response); err != nil {
+		return Assertion{}, fmt.Errorf("%w: %w", ErrInvalidCredential, err)
+	}
+	if response.Type != "public-key" || len(response.RawID) == 0 {
+		return Assertion{}, ErrInvalidCredential
+	}
+
+	data, err := parseClientData(response.Response.ClientDataJSON)
+	if err != nil {
+		return Assertion{}, err
+	}
+
+	return Assertion{
+		CredentialID:   response.RawID,
+		UserHandle:     response.Response.UserHandle,
+		Challenge:      data.Challenge,
+		clientDataJSON: response.Response.ClientDataJSON,
+		authData:       response.Response.AuthenticatorData,
+		signature:      response.Response.Signature,
+	}, nil
+}
+
+// VerifyRegistration checks response of navigator.credentials.create() and
+// returns the new credential. Attestation formats none and packed are accepted
+func (m *PasskeyManager) VerifyRegistration(credentialJSON []byte, challenge string) (models.Passkey, error) {
+	var response registrationResponse
+	if err := json.Unmarshal(credentialJSON, &response); err != nil {
+		return models.Passkey{}, fmt.Errorf("%w: %w", ErrInvalidCredential, err)
+	}
+	if response.Type != "public-key" || response.ID != base64.RawURLEncoding.EncodeToString(response.RawID) {
+		return models.Passkey{}, ErrInvalidCredential
+	}
+
+	if err := m.verifyClientData(response.Response.ClientDataJSON, ceremonyCreate, challenge); err != nil {
+		return models.Passkey{}, err
+	}
+
+	var attestation attestationObject
+	if err := cbor.Unmarshal(response.Response.AttestationObject, &attestation); err != nil {
+		return models.Passkey{}, fmt.Errorf("%w: %w", ErrInvalidCredential, err)
+	}
+
+	authData, err := parseAuthData(attestation.AuthData)
+	if err != nil {
+		return models.Passkey{}, err
+	}
+	if err := m.verifyAuthData(authData, false); err != nil {
+		return models.Passkey{}, err
+	}
+	if authData.flags&flagAttested == 0 || !bytes.Equal(authData.credentialID, response.RawID) {
+		return models.Passkey{}, ErrInvalidCredential
+	}
+
+	if _, _, err := parsePublicKey(authData.publicKey); err != nil {
+		return models.Passkey{}, err
+	}
+
+	clientDataHash := sha256.Sum256(response.Response.ClientDataJSON)
+	if err := verifyAttestation(attestation, authData, clientDataHash[:]); err != nil {
+		return models.Passkey{}, err
+	}
+
+	return models.Passkey{
+		ID:         authData.credentialID,
+		PublicKey:  authData.publicKey,
+		SignCount:  authData.signCount,
+		AAGUID:     authData.aaguid,
+		Transports: response.Response.Transports,
+	}, nil
+}
+
+// VerifyAssertion checks signature of the assertion with stored credential and
+// returns the new value of signature counter
+func (m *PasskeyManager) VerifyAssertion(assertion Assertion, challenge string, passkey models.Passkey, requireUV bool) (uint32, error) {
+	if !bytes.Equal(assertion.CredentialID, passkey.ID) {
+		return 0, ErrInvalidCredential
+	}
+
+	if err := m.verifyClientData(assertion.clientDataJSON, ceremonyGet, challenge); err != nil {
+		return 0, err
+	}
+
+	authData, err := parseAuthData(assertion.authData)
+	if err != nil {
+		return 0, err
+	}
+	if err := m.verifyAuthData(authData, requireUV); err != nil {
+		return 0, err
+	}
+
+	clientDataHash := sha256.Sum256(assertion.clientDataJSON)
+	signed := slices.Concat(assertion.authData, clientDataHash[:])
+	if err := verifySignature(passkey.PublicKey, signed, assertion.signature); err != nil {
+		return 0, err
+	}
+
+	// authenticators without counter always send zero
+	if (authData.signCount != 0 || passkey.SignCount != 0) && authData.signCount <= passkey.SignCount {
+		return 0, ErrSignCount
+	}
+
+	return authData.signCount, nil
+}
+
+func parseClientData(raw []byte) (clientData, error) {
+	var data clientData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return clientData{}, fmt.Errorf("%w: %w", ErrInvalidCredential, err)
+	}
+
+	return data, nil
+}
+
+func (m *PasskeyManager) verifyClientData(raw []byte, ceremony, challenge string) error {
+	data, err := parseClientData(raw)
+	if err != nil {
+		return err
+	}
+
+	if data.Type != ceremony {
+		return ErrInvalidCredential
+	}
+	if subtle.ConstantTimeCompare([]byte(data.Challenge), []byte(challenge)) != 1 {
+		return ErrChallengeMismatch
+	}
+	if data.CrossOrigin || !slices.Contains(m.origins, data.Origin) {
+		return ErrOriginNotAllowed
+	}
+
+	return nil
+}
+
+func (m *PasskeyManager) verifyAuthData(authData authenticatorData, requireUV bool) error {
+	rpIDHash := sha256.Sum256([]byte(m.rpID))
+	if !bytes.Equal(authData.rpIDHash, rpIDHash[:]) {
+		return ErrRPIDMismatch
+	}
+	if authData.flags&flagUserPresent == 0 {
+		return ErrUserNotPresent
+	}
+	if requireUV && authData.flags&flagUserVerified == 0 {
+		return ErrUserNotVerified
+	}
+	if authData.flags&flagBackedUp != 0 && authData.flags&flagBackupAllowed == 0 {
+		return ErrInvalidCredential
+	}
+
+	return nil
+}
+
+type authenticatorData struct {
+	rpIDHash  []byte
+	flags     byte
+	signCount uint32
+
+	// attested credential data, only in registration
+	aaguid       []byte
+	credentialID []byte
+	publicKey    []byte
+}
+
+// parseAuthData decodes authenticator data (WebAuthn §6.1)
+func parseAuthData(raw []byte) (authenticatorData, error) {
+	const headerLen = 32 + 1 + 4
+	if len(raw) < headerLen {
+		return authenticatorData{}, ErrInvalidCredential
+	}
+
+	authData := authenticatorData{
+		rpIDHash:  raw[:32],
+		flags:     raw[32],
+		signCount: binary.BigEndian.Uint32(raw[33:37]),
+	}
+	rest := raw[headerLen:]
+
+	if authData.flags&flagAttested != 0 {
+		if len(rest) < 18 {
+			return authenticatorData{}, ErrInvalidCredential
+		}
+		authData.aaguid = rest[:16]
+
+		idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if idLen > maxCredentialIDLen || len(rest) < idLen {
+			return authenticatorData{}, ErrInvalidCredential
+		}
+		authData.credentialID = rest[:idLen]
+		rest = rest[idLen:]
+
+		var key cbor.RawMessage
+		var err error
+		rest, err = cbor.UnmarshalFirst(rest, &key)
+		if err != nil {
+			return authenticatorData{}, fmt.Errorf("%w: %w", ErrInvalidCredential, err)
+		}
+		authData.publicKey = key
+	}
+
+	if authData.flags&flagExtensions != 0 {
+		var extensions cbor.RawMessage
+		var err error
+		rest, err = cbor.UnmarshalFirst(rest, &extensions)
+		if err != nil {
+			return authenticatorData{}, fmt.Errorf("%w: %w", ErrInvalidCredential, err)
+		}
+	}
+
+	if len(rest) != 0 {
+		return authenticatorData{}, ErrInvalidCredential
+	}
+
+	return authData, nil
+}
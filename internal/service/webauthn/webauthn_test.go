@@ -0,0 +1,437 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/kuromii5/sync-auth/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testRPID   = "example.com"
+	testOrigin = "https://app.example.com"
+)
+
+func testManager() *PasskeyManager {
+	return NewPasskeyManager(Config{
+		RPID:        testRPID,
+		RPName:      "Example",
+		Origins:     []string{testOrigin},
+		Timeout:     time.Minute,
+		Attestation: "direct",
+	})
+}
+
+// softAuthenticator is a software authenticator with ES256 or EdDSA credential
+type softAuthenticator struct {
+	t            *testing.T
+	signer       crypto.Signer
+	credentialID []byte
+	aaguid       []byte
+	signCount    uint32
+	noCounter    bool
+	flags        byte
+	rpID         string
+	origin       string
+}
+
+func newSoftAuthenticator(t *testing.T, alg int64) *softAuthenticator {
+	var signer crypto.Signer
+	switch alg {
+	case algES256:
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		signer = key
+	case algEdDSA:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		signer = key
+	}
+
+	credentialID := make([]byte, 16)
+	_, err := rand.Read(credentialID)
+	require.NoError(t, err)
+
+	return &softAuthenticator{
+		t:            t,
+		signer:       signer,
+		credentialID: credentialID,
+		aaguid:       []byte("0123456789abcdef"),
+		flags:        flagUserPresent | flagUserVerified,
+		rpID:         testRPID,
+		origin:       testOrigin,
+	}
+}
+
+func (a *softAuthenticator) coseKey() []byte {
+	var key map[int]any
+	switch pub := a.signer.Public().(type) {
+	case *ecdsa.PublicKey:
+		x, y := make([]byte, 32), make([]byte, 32)
+		pub.X.FillBytes(x)
+		pub.Y.FillBytes(y)
+		key = map[int]any{labelKty: ktyEC2, labelAlg: algES256, labelCrv: crvP256, labelX: x, labelY: y}
+	case ed25519.PublicKey:
+		key = map[int]any{labelKty: ktyOKP, labelAlg: algEdDSA, labelCrv: crvEd25519, labelX: []byte(pub)}
+	}
+
+	return a.marshalCBOR(key)
+}
+
+func (a *softAuthenticator) alg() int64 {
+	if _, ok := a.signer.(ed25519.PrivateKey); ok {
+		return algEdDSA
+	}
+
+	return algES256
+}
+
+func (a *softAuthenticator) sign(signer crypto.Signer, data []byte) []byte {
+	var sig []byte
+	var err error
+	if _, ok := signer.(ed25519.PrivateKey); ok {
+		sig, err = signer.Sign(rand.Reader, data, crypto.Hash(0))
+	} else {
+		digest := sha256.Sum256(data)
+		sig, err = signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	require.NoError(a.t, err)
+
+	return sig
+}
+
+func (a *softAuthenticator) authData(attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	flags := a.flags
+	if attested {
+		flags |= flagAttested
+	}
+
+	data := slices.Concat(rpIDHash[:], []byte{flags}, binary.BigEndian.AppendUint32(nil, a.signCount))
+	if attested {
+		data = slices.Concat(data, a.aaguid, binary.BigEndian.AppendUint16(nil, uint16(len(a.credentialID))), a.credentialID, a.coseKey())
+	}
+
+	return data
+}
+
+func (a *softAuthenticator) clientData(ceremony, challenge string) []byte {
+	data, err := json.Marshal(clientData{Type: ceremony, Challenge: challenge, Origin: a.origin})
+	require.NoError(a.t, err)
+
+	return data
+}
+
+// register returns credential JSON with attestation of the format, packed
+// attestation is signed with attestationKey if it is set or self signed
+func (a *softAuthenticator) register(challenge, format string, attestationKey crypto.Signer, cert []byte) []byte {
+	clientDataJSON := a.clientData(ceremonyCreate, challenge)
+	authData := a.authData(true)
+
+	statement := map[string]any{}
+	if format == "packed" {
+		clientDataHash := sha256.Sum256(clientDataJSON)
+		signed := slices.Concat(authData, clientDataHash[:])
+
+		if attestationKey == nil {
+			statement = map[string]any{"alg": a.alg(), "sig": a.sign(a.signer, signed)}
+		} else {
+			statement = map[string]any{"alg": int64(algES256), "sig": a.sign(attestationKey, signed), "x5c": [][]byte{cert}}
+		}
+	}
+
+	attestation := a.marshalCBOR(map[string]any{"fmt": format, "attStmt": statement, "authData": authData})
+
+	return a.marshalJSON(map[string]any{
+		"id":    encode(a.credentialID),
+		"rawId": encode(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]any{
+			"clientDataJSON":    encode(clientDataJSON),
+			"attestationObject": encode(attestation),
+			"transports":        []string{"internal"},
+		},
+	})
+}
+
+func (a *softAuthenticator) login(challenge string, userHandle []byte) []byte {
+	if !a.noCounter {
+		a.signCount++
+	}
+
+	clientDataJSON := a.clientData(ceremonyGet, challenge)
+	authData := a.authData(false)
+	clientDataHash := sha256.Sum256(clientDataJSON)
+
+	return a.marshalJSON(map[string]any{
+		"id":    encode(a.credentialID),
+		"rawId": encode(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]any{
+			"clientDataJSON":    encode(clientDataJSON),
+			"authenticatorData": encode(authData),
+			"signature":         encode(a.sign(a.signer, slices.Concat(authData, clientDataHash[:]))),
+			"userHandle":        encode(userHandle),
+		},
+	})
+}
+
+func (a *softAuthenticator) marshalCBOR(v any) []byte {
+	enc, err := cbor.CTAP2EncOptions().EncMode()
+	require.NoError(a.t, err)
+
+	data, err := enc.Marshal(v)
+	require.NoError(a.t, err)
+
+	return data
+}
+
+func (a *softAuthenticator) marshalJSON(v any) []byte {
+	data, err := json.Marshal(v)
+	require.NoError(a.t, err)
+
+	return data
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// attestationCert returns self signed packed attestation certificate
+func attestationCert(t *testing.T, aaguid []byte, ou string) (crypto.Signer, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	aaguidExt, err := asn1.Marshal(aaguid)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			Country:            []string{"US"},
+			Organization:       []string{"Vendor"},
+			OrganizationalUnit: []string{ou},
+			CommonName:         "Vendor Authenticator",
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		ExtraExtensions:       []pkix.Extension{{Id: oidFIDOAAGUID, Value: aaguidExt}},
+	}
+
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	require.NoError(t, err)
+
+	return key, cert
+}
+
+func TestCreationOptions(t *testing.T) {
+	m := testManager()
+	registered := []models.Passkey{{ID: []byte{1, 2}, Transports: []string{"usb"}}}
+
+	options := m.CreationOptions("challenge", models.User{ID: 42, Email: "test@example.com"}, registered)
+
+	assert.Equal(t, RelyingParty{ID: testRPID, Name: "Example"}, options.RP)
+	assert.Equal(t, UserHandle(42), options.User.ID)
+	assert.Equal(t, "test@example.com", options.User.Name)
+	assert.Equal(t, int64(60000), options.Timeout)
+	assert.Equal(t, []CredentialDescriptor{{Type: "public-key", ID: "AQI", Transports: []string{"usb"}}}, options.ExcludeCredentials)
+	assert.Equal(t, "required", options.AuthenticatorSelection.ResidentKey)
+	assert.Equal(t, "direct", options.Attestation)
+	assert.Len(t, options.PubKeyCredParams, len(supportedAlgorithms))
+}
+
+func TestUserHandle(t *testing.T) {
+	handle, err := base64.RawURLEncoding.DecodeString(UserHandle(42))
+	require.NoError(t, err)
+
+	userID, err := ParseUserHandle(handle)
+	require.NoError(t, err)
+	assert.Equal(t, int32(42), userID)
+
+	_, err = ParseUserHandle([]byte("not a number"))
+	assert.ErrorIs(t, err, ErrInvalidCredential)
+}
+
+func TestRegistration(t *testing.T) {
+	m := testManager()
+
+	tests := []struct {
+		name   string
+		alg    int64
+		format string
+		x5c    bool
+	}{
+		{name: "none", alg: algES256, format: "none"},
+		{name: "packed self", alg: algES256, format: "packed"},
+		{name: "packed self eddsa", alg: algEdDSA, format: "packed"},
+		{name: "packed x5c", alg: algES256, format: "packed", x5c: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authenticator := newSoftAuthenticator(t, tt.alg)
+			challenge, err := m.Challenge()
+			require.NoError(t, err)
+
+			var attestationKey crypto.Signer
+			var cert []byte
+			if tt.x5c {
+				attestationKey, cert = attestationCert(t, authenticator.aaguid, "Authenticator Attestation")
+			}
+			credential := authenticator.register(challenge, tt.format, attestationKey, cert)
+
+			parsed, err := ParseRegistrationChallenge(credential)
+			require.NoError(t, err)
+			assert.Equal(t, challenge, parsed)
+
+			passkey, err := m.VerifyRegistration(credential, challenge)
+			require.NoError(t, err)
+			assert.Equal(t, authenticator.credentialID, passkey.ID)
+			assert.Equal(t, authenticator.coseKey(), passkey.PublicKey)
+			assert.Equal(t, authenticator.aaguid, passkey.AAGUID)
+			assert.Equal(t, []string{"internal"}, passkey.Transports)
+			assert.Equal(t, uint32(0), passkey.SignCount)
+		})
+	}
+}
+
+func TestRegistration_Invalid(t *testing.T) {
+	m := testManager()
+	challenge, err := m.Challenge()
+	require.NoError(t, err)
+
+	authenticator := newSoftAuthenticator(t, algES256)
+	credential := authenticator.register(challenge, "none", nil, nil)
+
+	_, err = m.VerifyRegistration(credential, "other challenge")
+	assert.ErrorIs(t, err, ErrChallengeMismatch)
+
+	authenticator.origin = "https://evil.example.com"
+	_, err = m.VerifyRegistration(authenticator.register(challenge, "none", nil, nil), challenge)
+	assert.ErrorIs(t, err, ErrOriginNotAllowed)
+
+	authenticator = newSoftAuthenticator(t, algES256)
+	authenticator.rpID = "evil.com"
+	_, err = m.VerifyRegistration(authenticator.register(challenge, "none", nil, nil), challenge)
+	assert.ErrorIs(t, err, ErrRPIDMismatch)
+
+	authenticator = newSoftAuthenticator(t, algES256)
+	authenticator.flags = 0
+	_, err = m.VerifyRegistration(authenticator.register(challenge, "none", nil, nil), challenge)
+	assert.ErrorIs(t, err, ErrUserNotPresent)
+
+	authenticator = newSoftAuthenticator(t, algES256)
+	_, err = m.VerifyRegistration(authenticator.register(challenge, "fido-u2f", nil, nil), challenge)
+	assert.ErrorIs(t, err, ErrUnsupportedAttestation)
+
+	// certificate must be issued for authenticator attestation
+	attestationKey, cert := attestationCert(t, authenticator.aaguid, "Other")
+	_, err = m.VerifyRegistration(authenticator.register(challenge, "packed", attestationKey, cert), challenge)
+	assert.ErrorIs(t, err, ErrInvalidAttestation)
+
+	// aaguid of certificate must match authenticator data
+	attestationKey, cert = attestationCert(t, []byte("fedcba9876543210"), "Authenticator Attestation")
+	_, err = m.VerifyRegistration(authenticator.register(challenge, "packed", attestationKey, cert), challenge)
+	assert.ErrorIs(t, err, ErrInvalidAttestation)
+
+	// statement signed by another key
+	other := newSoftAuthenticator(t, algES256)
+	attestationKey, _ = attestationCert(t, authenticator.aaguid, "Authenticator Attestation")
+	_, cert = attestationCert(t, authenticator.aaguid, "Authenticator Attestation")
+	_, err = m.VerifyRegistration(other.register(challenge, "packed", attestationKey, cert), challenge)
+	assert.ErrorIs(t, err, ErrInvalidAttestation)
+
+	_, err = m.VerifyRegistration([]byte("{}"), challenge)
+	assert.ErrorIs(t, err, ErrInvalidCredential)
+}
+
+func TestAssertion(t *testing.T) {
+	m := testManager()
+	authenticator := newSoftAuthenticator(t, algES256)
+
+	challenge, err := m.Challenge()
+	require.NoError(t, err)
+	passkey, err := m.VerifyRegistration(authenticator.register(challenge, "none", nil, nil), challenge)
+	require.NoError(t, err)
+
+	handle, _ := base64.RawURLEncoding.DecodeString(UserHandle(42))
+	assertion, err := ParseAssertion(authenticator.login(challenge, handle))
+	require.NoError(t, err)
+	assert.Equal(t, authenticator.credentialID, assertion.CredentialID)
+	assert.Equal(t, handle, assertion.UserHandle)
+	assert.Equal(t, challenge, assertion.Challenge)
+
+	signCount, err := m.VerifyAssertion(assertion, challenge, passkey, true)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(1), signCount)
+
+	// replayed assertion has the same counter
+	passkey.SignCount = signCount
+	_, err = m.VerifyAssertion(assertion, challenge, passkey, true)
+	assert.ErrorIs(t, err, ErrSignCount)
+
+	_, err = m.VerifyAssertion(assertion, "other challenge", passkey, true)
+	assert.ErrorIs(t, err, ErrChallengeMismatch)
+}
+
+func TestAssertion_ZeroCounter(t *testing.T) {
+	m := testManager()
+	authenticator := newSoftAuthenticator(t, algEdDSA)
+	authenticator.noCounter = true
+	passkey := models.Passkey{ID: authenticator.credentialID, PublicKey: authenticator.coseKey()}
+
+	// authenticators without counter always send zero
+	for range 2 {
+		assertion, err := ParseAssertion(authenticator.login("challenge", nil))
+		require.NoError(t, err)
+
+		signCount, err := m.VerifyAssertion(assertion, "challenge", passkey, true)
+		require.NoError(t, err)
+		assert.Equal(t, uint32(0), signCount)
+	}
+}
+
+func TestAssertion_Invalid(t *testing.T) {
+	m := testManager()
+	authenticator := newSoftAuthenticator(t, algES256)
+	passkey := models.Passkey{ID: authenticator.credentialID, PublicKey: authenticator.coseKey()}
+
+	authenticator.flags = flagUserPresent
+	assertion, err := ParseAssertion(authenticator.login("challenge", nil))
+	require.NoError(t, err)
+
+	_, err = m.VerifyAssertion(assertion, "challenge", passkey, true)
+	assert.ErrorIs(t, err, ErrUserNotVerified)
+
+	_, err = m.VerifyAssertion(assertion, "challenge", passkey, false)
+	assert.NoError(t, err, "user verification is optional for the second factor")
+
+	// signature of another credential with the same id
+	other := newSoftAuthenticator(t, algES256)
+	other.credentialID = authenticator.credentialID
+	assertion, err = ParseAssertion(other.login("challenge", nil))
+	require.NoError(t, err)
+	_, err = m.VerifyAssertion(assertion, "challenge", passkey, false)
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+
+	// assertion of another credential
+	_, err = m.VerifyAssertion(assertion, "challenge", models.Passkey{ID: []byte("other"), PublicKey: passkey.PublicKey}, false)
+	assert.ErrorIs(t, err, ErrInvalidCredential)
+}
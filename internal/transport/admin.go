@@ -0,0 +1,30 @@
+package transport
+
+import (
+	"context"
+	"crypto/subtle"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const adminTokenHeader = "x-admin-token"
+
+func (a *api) authorizeAdmin(ctx context.Context) error {
+	if a.adminToken == "" {
+		return status.Error(codes.PermissionDenied, "admin api is disabled")
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(adminTokenHeader)
+	if len(values) == 0 {
+		return status.Error(codes.Unauthenticated, "admin token is required")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(values[0]), []byte(a.adminToken)) != 1 {
+		return status.Error(codes.PermissionDenied, "invalid admin token")
+	}
+
+	return nil
+}
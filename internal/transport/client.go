@@ -0,0 +1,77 @@
+package transport
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/kuromii5/sync-auth/internal/client"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// clientInfoInterceptor attaches IP and user agent of the caller to request context
+func clientInfoInterceptor(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	return handler(client.WithInfo(ctx, clientInfo(ctx)), req)
+}
+
+func clientInfo(ctx context.Context) client.Info {
+	info := client.Info{IP: peerIP(ctx)}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	// requests from HTTP gateway: the last address is the one gateway has seen.
+	// Other callers can set the header to anything, so it isn't trusted
+	if forwarded := md.Get("x-forwarded-for"); len(forwarded) > 0 && fromGateway(md, info.IP) {
+		addrs := strings.Split(forwarded[len(forwarded)-1], ",")
+		info.IP = strings.TrimSpace(addrs[len(addrs)-1])
+	}
+
+	if userAgent := md.Get("grpcgateway-user-agent"); len(userAgent) > 0 {
+		info.UserAgent = userAgent[0]
+	} else if userAgent := md.Get("user-agent"); len(userAgent) > 0 {
+		info.UserAgent = userAgent[0]
+	}
+
+	if locale := md.Get("grpcgateway-accept-language"); len(locale) > 0 {
+		info.Locale = locale[0]
+	} else if locale := md.Get("accept-language"); len(locale) > 0 {
+		info.Locale = locale[0]
+	}
+
+	return info
+}
+
+// viaGateway reports whether request came through HTTP gateway
+func viaGateway(ctx context.Context) bool {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	return fromGateway(md, peerIP(ctx))
+}
+
+func peerIP(ctx context.Context) string {
+	addr := peerAddr(ctx)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+
+	return addr
+}
+
+// fromGateway reports whether request was proxied by in-process HTTP gateway:
+// it connects over loopback and adds grpcgateway- prefixed metadata
+func fromGateway(md metadata.MD, peerIP string) bool {
+	ip := net.ParseIP(peerIP)
+	if ip == nil || !ip.IsLoopback() {
+		return false
+	}
+
+	for key := range md {
+		if strings.HasPrefix(key, runtime.MetadataPrefix) {
+			return true
+		}
+	}
+
+	return false
+}
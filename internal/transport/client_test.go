@@ -0,0 +1,61 @@
+package transport
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+func TestClientInfo_ForwardedFor(t *testing.T) {
+	tests := []struct {
+		name string
+		peer string
+		md   metadata.MD
+		ip   string
+	}{
+		{
+			name: "gateway",
+			peer: "127.0.0.1",
+			md:   metadata.Pairs("x-forwarded-for", "198.51.100.7, 203.0.113.5", "grpcgateway-user-agent", "browser"),
+			ip:   "203.0.113.5",
+		},
+		{
+			name: "direct caller with spoofed header",
+			peer: "192.0.2.10",
+			md:   metadata.Pairs("x-forwarded-for", "203.0.113.5", "grpcgateway-user-agent", "browser"),
+			ip:   "192.0.2.10",
+		},
+		{
+			name: "local caller without gateway metadata",
+			peer: "127.0.0.1",
+			md:   metadata.Pairs("x-forwarded-for", "203.0.113.5"),
+			ip:   "127.0.0.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := peer.NewContext(context.Background(), &peer.Peer{
+				Addr: &net.TCPAddr{IP: net.ParseIP(tt.peer), Port: 50000},
+			})
+			ctx = metadata.NewIncomingContext(ctx, tt.md)
+
+			assert.Equal(t, tt.ip, clientInfo(ctx).IP)
+		})
+	}
+}
+
+func TestViaGateway(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("grpcgateway-user-agent", "browser"))
+	assert.False(t, viaGateway(ctx))
+
+	gateway := peer.NewContext(ctx, &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv6loopback, Port: 50000}})
+	assert.True(t, viaGateway(gateway))
+
+	direct := peer.NewContext(ctx, &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 50000}})
+	assert.False(t, viaGateway(direct))
+}
@@ -0,0 +1,41 @@
+package transport
+
+import (
+	"context"
+
+	"github.com/kuromii5/sync-auth/internal/service/cookies"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// cookieTokensInterceptor fills empty accessToken and refreshToken fields of
+// the request with tokens from cookies, so browsers don't have to send them in the body
+func cookieTokensInterceptor(policy *cookies.Policy) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		headers := md.Get("cookie")
+		msg, ok := req.(proto.Message)
+		if len(headers) == 0 || !ok {
+			return handler(ctx, req)
+		}
+
+		accessToken, refreshToken := policy.Tokens(headers)
+		setEmptyField(msg.ProtoReflect(), "accessToken", accessToken)
+		setEmptyField(msg.ProtoReflect(), "refreshToken", refreshToken)
+
+		return handler(ctx, req)
+	}
+}
+
+func setEmptyField(msg protoreflect.Message, name protoreflect.Name, value string) {
+	field := msg.Descriptor().Fields().ByName(name)
+	if value == "" || field == nil || field.Kind() != protoreflect.StringKind || field.IsList() {
+		return
+	}
+
+	if msg.Get(field).String() == "" {
+		msg.Set(field, protoreflect.ValueOfString(value))
+	}
+}
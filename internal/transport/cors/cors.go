@@ -0,0 +1,150 @@
+package cors
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/cors"
+)
+
+var ErrCredentialsWildcard = errors.New("credentials can't be allowed for any origin")
+
+// Rules of cross-origin requests. Without allowed origins
+// CORS headers aren't sent and browsers block cross-origin requests
+type Rules struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// Route overrides rules for the path. Path ending with / matches every
+// path under it, the longest matching route wins. Empty fields are inherited
+type Route struct {
+	Path             string
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials *bool
+	MaxAge           *time.Duration
+}
+
+type route struct {
+	path string
+	// nil if cross-origin requests aren't allowed
+	cors *cors.Cors
+}
+
+// Policy answers preflight requests and adds CORS headers to responses
+type Policy struct {
+	defaults *cors.Cors
+	// sorted by path length, longest first
+	routes []route
+}
+
+func New(defaults Rules, routes []Route) (*Policy, error) {
+	c, err := newCors(defaults)
+	if err != nil {
+		return nil, err
+	}
+	p := &Policy{defaults: c}
+
+	for _, r := range routes {
+		c, err := newCors(r.merge(defaults))
+		if err != nil {
+			return nil, fmt.Errorf("route %s: %w", r.Path, err)
+		}
+		p.routes = append(p.routes, route{path: r.Path, cors: c})
+	}
+	sort.SliceStable(p.routes, func(i, j int) bool {
+		return len(p.routes[i].path) > len(p.routes[j].path)
+	})
+
+	return p, nil
+}
+
+func (p *Policy) Middleware(next http.Handler) http.Handler {
+	defaults := wrap(p.defaults, next)
+	routes := make([]http.Handler, len(p.routes))
+	for i, r := range p.routes {
+		routes[i] = wrap(r.cors, next)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i, route := range p.routes {
+			if matches(route.path, r.URL.Path) {
+				routes[i].ServeHTTP(w, r)
+
+				return
+			}
+		}
+		defaults.ServeHTTP(w, r)
+	})
+}
+
+func wrap(c *cors.Cors, next http.Handler) http.Handler {
+	if c == nil {
+		return next
+	}
+
+	return c.Handler(next)
+}
+
+func (r Route) merge(defaults Rules) Rules {
+	rules := defaults
+	if len(r.AllowedOrigins) > 0 {
+		rules.AllowedOrigins = r.AllowedOrigins
+	}
+	if len(r.AllowedMethods) > 0 {
+		rules.AllowedMethods = r.AllowedMethods
+	}
+	if len(r.AllowedHeaders) > 0 {
+		rules.AllowedHeaders = r.AllowedHeaders
+	}
+	if len(r.ExposedHeaders) > 0 {
+		rules.ExposedHeaders = r.ExposedHeaders
+	}
+	if r.AllowCredentials != nil {
+		rules.AllowCredentials = *r.AllowCredentials
+	}
+	if r.MaxAge != nil {
+		rules.MaxAge = *r.MaxAge
+	}
+
+	return rules
+}
+
+func newCors(rules Rules) (*cors.Cors, error) {
+	// empty list means any origin for rs/cors
+	if len(rules.AllowedOrigins) == 0 {
+		return nil, nil
+	}
+	if rules.AllowCredentials && slices.Contains(rules.AllowedOrigins, "*") {
+		return nil, ErrCredentialsWildcard
+	}
+
+	return cors.New(cors.Options{
+		AllowedOrigins:   rules.AllowedOrigins,
+		AllowedMethods:   rules.AllowedMethods,
+		AllowedHeaders:   rules.AllowedHeaders,
+		ExposedHeaders:   rules.ExposedHeaders,
+		AllowCredentials: rules.AllowCredentials,
+		MaxAge:           int(rules.MaxAge / time.Second),
+	}), nil
+}
+
+func matches(pattern, path string) bool {
+	if strings.HasSuffix(pattern, "/") {
+		return strings.HasPrefix(path, pattern)
+	}
+
+	return path == pattern
+}
@@ -0,0 +1,150 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func defaultRules() Rules {
+	return Rules{
+		AllowedOrigins:   []string{"https://app.example.com"},
+		AllowedMethods:   []string{http.MethodGet, http.MethodPost},
+		AllowedHeaders:   []string{"Content-Type", "X-CSRF-Token"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	}
+}
+
+func serve(t *testing.T, handler http.Handler, method, path string, headers map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(method, "https://auth.example.com"+path, nil)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func newHandler(t *testing.T, defaults Rules, routes []Route) http.Handler {
+	t.Helper()
+
+	p, err := New(defaults, routes)
+	require.NoError(t, err)
+
+	return p.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+func TestNew(t *testing.T) {
+	rules := defaultRules()
+	rules.AllowedOrigins = []string{"*"}
+	_, err := New(rules, nil)
+	assert.ErrorIs(t, err, ErrCredentialsWildcard)
+
+	rules.AllowCredentials = false
+	_, err = New(rules, nil)
+	assert.NoError(t, err)
+
+	_, err = New(defaultRules(), []Route{{Path: "/.well-known/", AllowedOrigins: []string{"*"}}})
+	assert.ErrorIs(t, err, ErrCredentialsWildcard)
+}
+
+func TestPreflight(t *testing.T) {
+	handler := newHandler(t, defaultRules(), nil)
+
+	rec := serve(t, handler, http.MethodOptions, "/login", map[string]string{
+		"Origin":                         "https://app.example.com",
+		"Access-Control-Request-Method":  http.MethodPost,
+		"Access-Control-Request-Headers": "content-type,x-csrf-token",
+	})
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "https://app.example.com", rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", rec.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, http.MethodPost, rec.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "content-type,x-csrf-token", rec.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "600", rec.Header().Get("Access-Control-Max-Age"))
+
+	// not allowed method
+	rec = serve(t, handler, http.MethodOptions, "/login", map[string]string{
+		"Origin":                        "https://app.example.com",
+		"Access-Control-Request-Method": http.MethodDelete,
+	})
+	assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+
+	// not allowed origin
+	rec = serve(t, handler, http.MethodOptions, "/login", map[string]string{
+		"Origin":                        "https://evil.com",
+		"Access-Control-Request-Method": http.MethodPost,
+	})
+	assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestActualRequest(t *testing.T) {
+	handler := newHandler(t, defaultRules(), nil)
+
+	rec := serve(t, handler, http.MethodPost, "/login", map[string]string{"Origin": "https://app.example.com"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "https://app.example.com", rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", rec.Header().Get("Access-Control-Allow-Credentials"))
+
+	rec = serve(t, handler, http.MethodPost, "/login", map[string]string{"Origin": "https://evil.com"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestNoOrigins(t *testing.T) {
+	rules := defaultRules()
+	rules.AllowedOrigins = nil
+	handler := newHandler(t, rules, nil)
+
+	rec := serve(t, handler, http.MethodPost, "/login", map[string]string{"Origin": "https://app.example.com"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestRoutes(t *testing.T) {
+	noCredentials := false
+	handler := newHandler(t, defaultRules(), []Route{
+		{Path: "/.well-known/", AllowedOrigins: []string{"*"}, AllowCredentials: &noCredentials},
+		{Path: "/oauth/", AllowedOrigins: []string{"https://partner.example.com"}},
+		{Path: "/oauth/callback", AllowedMethods: []string{http.MethodPost, http.MethodPut}},
+	})
+
+	tests := []struct {
+		name        string
+		path        string
+		origin      string
+		method      string
+		allowed     string
+		credentials string
+	}{
+		{name: "public keys", path: "/.well-known/jwks.json", origin: "https://any.com", method: http.MethodGet, allowed: "*"},
+		{name: "prefix", path: "/oauth/url", origin: "https://partner.example.com", method: http.MethodPost, allowed: "https://partner.example.com", credentials: "true"},
+		{name: "prefix overrides origins", path: "/oauth/url", origin: "https://app.example.com", method: http.MethodPost},
+		{name: "exact path inherits defaults", path: "/oauth/callback", origin: "https://app.example.com", method: http.MethodPut, allowed: "https://app.example.com", credentials: "true"},
+		{name: "longer path falls back to prefix", path: "/oauth/callback/x", origin: "https://app.example.com", method: http.MethodPost},
+		{name: "defaults", path: "/login", origin: "https://app.example.com", method: http.MethodPost, allowed: "https://app.example.com", credentials: "true"},
+		{name: "defaults method", path: "/login", origin: "https://app.example.com", method: http.MethodPut},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, handler, http.MethodOptions, tt.path, map[string]string{
+				"Origin":                        tt.origin,
+				"Access-Control-Request-Method": tt.method,
+			})
+
+			assert.Equal(t, tt.allowed, rec.Header().Get("Access-Control-Allow-Origin"))
+			assert.Equal(t, tt.credentials, rec.Header().Get("Access-Control-Allow-Credentials"))
+		})
+	}
+}
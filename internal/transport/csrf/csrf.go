@@ -0,0 +1,178 @@
+package csrf
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/kuromii5/sync-auth/internal/service/cookies"
+	"google.golang.org/grpc/codes"
+)
+
+const nonceBytes = 32
+
+type Config struct {
+	Secret     []byte
+	CookieName string
+	HeaderName string
+	// origins allowed besides the origin of the service itself, e.g. https://app.example.com
+	TrustedOrigins []string
+}
+
+// Protection implements signed double-submit cookie. The token is a random
+// nonce signed with HMAC-SHA256, it is sent both in the cookie and in the header.
+// Other sites can't read the cookie, and can't set it to a forged value
+// without knowing the secret
+type Protection struct {
+	secret     []byte
+	cookieName string
+	headerName string
+	origins    map[string]struct{}
+	cookies    *cookies.Policy
+}
+
+func New(cfg Config, cookies *cookies.Policy) *Protection {
+	origins := make(map[string]struct{}, len(cfg.TrustedOrigins))
+	for _, origin := range cfg.TrustedOrigins {
+		origins[normalizeOrigin(origin)] = struct{}{}
+	}
+
+	return &Protection{
+		secret:     cfg.Secret,
+		cookieName: cfg.CookieName,
+		headerName: cfg.HeaderName,
+		origins:    origins,
+		cookies:    cookies,
+	}
+}
+
+// Token returns new signed token
+func (p *Protection) Token() (string, error) {
+	nonce := make([]byte, nonceBytes)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(nonce)
+
+	return encoded + "." + p.sign(encoded), nil
+}
+
+// Valid checks signature of the token
+func (p *Protection) Valid(token string) bool {
+	encoded, signature, ok := strings.Cut(token, ".")
+
+	return ok && encoded != "" && hmac.Equal([]byte(signature), []byte(p.sign(encoded)))
+}
+
+// TokenHandler returns token and sets it in the cookie. Valid token from
+// the cookie is returned as is, so pages opened in other tabs keep working
+func (p *Protection) TokenHandler(w http.ResponseWriter, r *http.Request) {
+	var token string
+	if cookie, err := r.Cookie(p.cookieName); err == nil && p.Valid(cookie.Value) {
+		token = cookie.Value
+	} else {
+		token, err = p.Token()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codes.Internal, "failed to generate csrf token")
+
+			return
+		}
+		w.Header().Add("Set-Cookie", p.cookies.ScriptCookie(p.cookieName, token))
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, struct {
+		Token  string `json:"token"`
+		Header string `json:"header"`
+	}{Token: token, Header: p.headerName})
+}
+
+// Middleware rejects state-changing requests from not trusted origins. If the
+// request has cookies, the token from the header must match the one in the cookie.
+// Clients which don't use cookies send tokens in the body and aren't affected
+func (p *Protection) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
+		if !p.trustedOrigin(r) {
+			writeError(w, http.StatusForbidden, codes.PermissionDenied, "origin is not allowed")
+
+			return
+		}
+
+		if r.Header.Get("Cookie") != "" {
+			cookie, err := r.Cookie(p.cookieName)
+			token := r.Header.Get(p.headerName)
+			if err != nil || token == "" || !hmac.Equal([]byte(cookie.Value), []byte(token)) || !p.Valid(token) {
+				writeError(w, http.StatusForbidden, codes.PermissionDenied, "invalid csrf token")
+
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// trustedOrigin checks Origin header, or Referer if browser didn't send Origin.
+// Requests with neither of them don't come from browsers
+func (p *Protection) trustedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		referer := r.Header.Get("Referer")
+		if referer == "" {
+			return true
+		}
+
+		u, err := url.Parse(referer)
+		if err != nil || u.Host == "" {
+			return false
+		}
+		origin = u.Scheme + "://" + u.Host
+	}
+
+	origin = normalizeOrigin(origin)
+	if _, ok := p.origins[origin]; ok {
+		return true
+	}
+
+	// same origin, scheme isn't compared since TLS may end at a proxy
+	u, err := url.Parse(origin)
+
+	return err == nil && u.Host != "" && strings.EqualFold(u.Host, r.Host)
+}
+
+func (p *Protection) sign(data string) string {
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write([]byte(data))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func normalizeOrigin(origin string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(origin)), "/")
+}
+
+// writeError responds in the same format as HTTP gateway errors
+func writeError(w http.ResponseWriter, status int, code codes.Code, message string) {
+	writeJSON(w, status, struct {
+		Code    codes.Code `json:"code"`
+		Message string     `json:"message"`
+	}{Code: code, Message: message})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,193 @@
+package csrf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kuromii5/sync-auth/internal/service/cookies"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newProtection(t *testing.T, secret string) *Protection {
+	policy, err := cookies.NewPolicy(cookies.Config{
+		AccessName:  "access_token",
+		RefreshName: "refresh_token",
+		Path:        "/",
+		Secure:      true,
+		SameSite:    "lax",
+	})
+	require.NoError(t, err)
+
+	return New(Config{
+		Secret:         []byte(secret),
+		CookieName:     "csrf_token",
+		HeaderName:     "X-CSRF-Token",
+		TrustedOrigins: []string{"https://App.example.com/"},
+	}, policy)
+}
+
+func TestToken(t *testing.T) {
+	p := newProtection(t, "secret")
+
+	token, err := p.Token()
+	require.NoError(t, err)
+	assert.True(t, p.Valid(token))
+
+	other, err := p.Token()
+	require.NoError(t, err)
+	assert.NotEqual(t, token, other)
+
+	nonce, _, _ := strings.Cut(token, ".")
+	assert.False(t, p.Valid(nonce))
+	assert.False(t, p.Valid(nonce+"."))
+	assert.False(t, p.Valid(""))
+	assert.False(t, p.Valid(token+"x"))
+	assert.False(t, newProtection(t, "another secret").Valid(token))
+}
+
+func TestTokenHandler(t *testing.T) {
+	p := newProtection(t, "secret")
+
+	rec := httptest.NewRecorder()
+	p.TokenHandler(rec, httptest.NewRequest(http.MethodGet, "/csrf", nil))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	cookies := rec.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "csrf_token", cookies[0].Name)
+	assert.False(t, cookies[0].HttpOnly)
+	assert.True(t, p.Valid(cookies[0].Value))
+	assert.JSONEq(t, `{"token":"`+cookies[0].Value+`","header":"X-CSRF-Token"}`, rec.Body.String())
+
+	// token from the cookie is reused
+	req := httptest.NewRequest(http.MethodGet, "/csrf", nil)
+	req.AddCookie(cookies[0])
+	rec = httptest.NewRecorder()
+	p.TokenHandler(rec, req)
+
+	assert.Empty(t, rec.Result().Cookies())
+	assert.Contains(t, rec.Body.String(), cookies[0].Value)
+}
+
+func TestMiddleware(t *testing.T) {
+	p := newProtection(t, "secret")
+	token, err := p.Token()
+	require.NoError(t, err)
+	forged, err := newProtection(t, "attacker").Token()
+	require.NoError(t, err)
+
+	handler := p.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		status  int
+	}{
+		{
+			name:    "safe method",
+			method:  http.MethodGet,
+			headers: map[string]string{"Cookie": "access_token=a", "Origin": "https://evil.com"},
+			status:  http.StatusNoContent,
+		},
+		{
+			name:   "no cookies",
+			method: http.MethodPost,
+			status: http.StatusNoContent,
+		},
+		{
+			name:    "valid token",
+			method:  http.MethodPost,
+			headers: map[string]string{"Cookie": "access_token=a; csrf_token=" + token, "X-CSRF-Token": token},
+			status:  http.StatusNoContent,
+		},
+		{
+			name:    "trusted origin",
+			method:  http.MethodPost,
+			headers: map[string]string{"Cookie": "csrf_token=" + token, "X-CSRF-Token": token, "Origin": "https://app.example.com"},
+			status:  http.StatusNoContent,
+		},
+		{
+			name:    "same origin",
+			method:  http.MethodPost,
+			headers: map[string]string{"Cookie": "csrf_token=" + token, "X-CSRF-Token": token, "Origin": "https://auth.example.com"},
+			status:  http.StatusNoContent,
+		},
+		{
+			name:    "trusted referer",
+			method:  http.MethodPost,
+			headers: map[string]string{"Cookie": "csrf_token=" + token, "X-CSRF-Token": token, "Referer": "https://app.example.com/settings?tab=1"},
+			status:  http.StatusNoContent,
+		},
+		{
+			name:    "missing header",
+			method:  http.MethodPost,
+			headers: map[string]string{"Cookie": "access_token=a; csrf_token=" + token},
+			status:  http.StatusForbidden,
+		},
+		{
+			name:    "missing cookie",
+			method:  http.MethodPost,
+			headers: map[string]string{"Cookie": "access_token=a", "X-CSRF-Token": token},
+			status:  http.StatusForbidden,
+		},
+		{
+			name:    "different tokens",
+			method:  http.MethodDelete,
+			headers: map[string]string{"Cookie": "csrf_token=" + token, "X-CSRF-Token": token[1:]},
+			status:  http.StatusForbidden,
+		},
+		{
+			name:    "forged token",
+			method:  http.MethodPost,
+			headers: map[string]string{"Cookie": "csrf_token=" + forged, "X-CSRF-Token": forged},
+			status:  http.StatusForbidden,
+		},
+		{
+			name:    "untrusted origin",
+			method:  http.MethodPost,
+			headers: map[string]string{"Cookie": "csrf_token=" + token, "X-CSRF-Token": token, "Origin": "https://evil.com"},
+			status:  http.StatusForbidden,
+		},
+		{
+			name:    "untrusted origin without cookies",
+			method:  http.MethodPost,
+			headers: map[string]string{"Origin": "https://evil.com"},
+			status:  http.StatusForbidden,
+		},
+		{
+			name:    "null origin",
+			method:  http.MethodPost,
+			headers: map[string]string{"Origin": "null"},
+			status:  http.StatusForbidden,
+		},
+		{
+			name:    "untrusted referer",
+			method:  http.MethodPost,
+			headers: map[string]string{"Referer": "https://evil.com/page"},
+			status:  http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "https://auth.example.com/logout", nil)
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.status, rec.Code)
+			if tt.status == http.StatusForbidden {
+				assert.Contains(t, rec.Body.String(), `"code":7`)
+			}
+		})
+	}
+}
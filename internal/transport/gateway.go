@@ -0,0 +1,51 @@
+package transport
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	auth "github.com/kuromii5/sync-auth/api/sync-auth/v1"
+	"google.golang.org/grpc"
+)
+
+// NewGateway returns REST API generated from the proto annotations. Requests are
+// proxied through conn to the gRPC server, so interceptors run for them as well
+func NewGateway(conn *grpc.ClientConn) (http.Handler, error) {
+	mux := runtime.NewServeMux(
+		runtime.WithIncomingHeaderMatcher(incomingHeader),
+		runtime.WithOutgoingHeaderMatcher(outgoingHeader),
+	)
+	if err := auth.RegisterAuthHandler(context.Background(), mux, conn); err != nil {
+		return nil, err
+	}
+
+	return mux, nil
+}
+
+// incomingHeader passes cookies as they are, tokens are read from them.
+// Request id is passed to be used in logs
+func incomingHeader(key string) (string, bool) {
+	if strings.EqualFold(key, "Cookie") {
+		return "cookie", true
+	}
+	if strings.EqualFold(key, requestIDHeader) {
+		return requestIDHeader, true
+	}
+
+	return runtime.DefaultHeaderMatcher(key)
+}
+
+// outgoingHeader sends cookies set by the service to the browser and request id,
+// other metadata gets the default Grpc-Metadata- prefix
+func outgoingHeader(key string) (string, bool) {
+	if strings.EqualFold(key, "set-cookie") {
+		return "Set-Cookie", true
+	}
+	if strings.EqualFold(key, requestIDHeader) {
+		return "X-Request-Id", true
+	}
+
+	return runtime.MetadataHeaderPrefix + key, true
+}
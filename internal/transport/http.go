@@ -0,0 +1,53 @@
+package transport
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/kuromii5/sync-auth/internal/models"
+	"github.com/kuromii5/sync-auth/internal/service"
+	"github.com/kuromii5/sync-auth/internal/transport/cors"
+	"github.com/kuromii5/sync-auth/internal/transport/csrf"
+)
+
+type httpApi struct {
+	auth *service.Auth
+
+	// client id -> secret of clients allowed to introspect tokens
+	introspectionClients map[string]string
+}
+
+// NewHTTPHandler serves endpoints which have no RPC, every other path goes to the gateway.
+// Gateway is protected from CSRF unless csrf is nil, CORS applies to all endpoints
+func NewHTTPHandler(authApi *service.Auth, introspectionClients map[string]string, gateway http.Handler, csrf *csrf.Protection, cors *cors.Policy) http.Handler {
+	api := &httpApi{
+		auth:                 authApi,
+		introspectionClients: introspectionClients,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/jwks.json", api.JWKS)
+	mux.HandleFunc("POST /oauth/introspect", api.Introspect)
+	if csrf != nil {
+		mux.HandleFunc("GET /csrf", csrf.TokenHandler)
+		gateway = csrf.Middleware(gateway)
+	}
+	mux.Handle("/", gateway)
+
+	return cors.Middleware(mux)
+}
+
+func (a *httpApi) JWKS(w http.ResponseWriter, r *http.Request) {
+	keys := a.auth.JWKS(r.Context())
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, http.StatusOK, struct {
+		Keys []models.JWK `json:"keys"`
+	}{Keys: keys})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,103 @@
+package transport
+
+import (
+	"context"
+	"errors"
+
+	auth "github.com/kuromii5/sync-auth/api/sync-auth/v1"
+	"github.com/kuromii5/sync-auth/internal/models"
+	"github.com/kuromii5/sync-auth/internal/repo/postgres"
+	"github.com/kuromii5/sync-auth/internal/service"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (a *api) GetLinkIdentityURL(ctx context.Context, req *auth.GetLinkIdentityURLRequest) (*auth.GetOAuthURLResponse, error) {
+	redirect, err := a.auth.LinkIdentityURL(ctx, req.GetAccessToken(), req.GetProvider())
+	if err != nil {
+		if errors.Is(err, service.ErrUnauthenticated) {
+			return nil, status.Error(codes.Unauthenticated, "invalid access token")
+		}
+		if st, ok := oauthStatus(err); ok {
+			return nil, st
+		}
+
+		return nil, status.Error(codes.Internal, "failed to generate oauth url")
+	}
+
+	return &auth.GetOAuthURLResponse{
+		Url:       redirect.URL,
+		State:     redirect.State,
+		ExpiresAt: redirect.ExpiresAt.Unix(),
+	}, nil
+}
+
+func (a *api) LinkIdentity(ctx context.Context, req *auth.LinkIdentityRequest) (*auth.LinkIdentityResponse, error) {
+	if req.GetState() == "" {
+		return nil, status.Error(codes.InvalidArgument, "state is required")
+	}
+
+	identity, err := a.auth.LinkIdentity(ctx, req.GetAccessToken(), req.GetProvider(), req.GetCode(), req.GetState())
+	if err != nil {
+		if errors.Is(err, service.ErrUnauthenticated) {
+			return nil, status.Error(codes.Unauthenticated, "invalid access token")
+		}
+		if st, ok := oauthStatus(err); ok {
+			return nil, st
+		}
+		if errors.Is(err, postgres.ErrIdentityExists) {
+			return nil, status.Error(codes.AlreadyExists, "identity is already linked")
+		}
+
+		return nil, status.Error(codes.Internal, "failed to link identity")
+	}
+
+	return &auth.LinkIdentityResponse{
+		Identity: identityToProto(identity),
+	}, nil
+}
+
+func (a *api) UnlinkIdentity(ctx context.Context, req *auth.UnlinkIdentityRequest) (*auth.UnlinkIdentityResponse, error) {
+	if err := a.auth.UnlinkIdentity(ctx, req.GetAccessToken(), req.GetProvider()); err != nil {
+		if errors.Is(err, service.ErrUnauthenticated) {
+			return nil, status.Error(codes.Unauthenticated, "invalid access token")
+		}
+		if errors.Is(err, service.ErrLastLoginMethod) {
+			return nil, status.Error(codes.FailedPrecondition, "can't unlink the only login method")
+		}
+		if errors.Is(err, postgres.ErrIdentityNotFound) {
+			return nil, status.Error(codes.NotFound, "identity not found")
+		}
+
+		return nil, status.Error(codes.Internal, "failed to unlink identity")
+	}
+
+	return &auth.UnlinkIdentityResponse{}, nil
+}
+
+func (a *api) ListIdentities(ctx context.Context, req *auth.ListIdentitiesRequest) (*auth.ListIdentitiesResponse, error) {
+	identities, err := a.auth.ListIdentities(ctx, req.GetAccessToken())
+	if err != nil {
+		if errors.Is(err, service.ErrUnauthenticated) {
+			return nil, status.Error(codes.Unauthenticated, "invalid access token")
+		}
+
+		return nil, status.Error(codes.Internal, "failed to list identities")
+	}
+
+	response := &auth.ListIdentitiesResponse{Identities: make([]*auth.Identity, 0, len(identities))}
+	for _, identity := range identities {
+		response.Identities = append(response.Identities, identityToProto(identity))
+	}
+
+	return response, nil
+}
+
+func identityToProto(identity models.Identity) *auth.Identity {
+	return &auth.Identity{
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+		LinkedAt: identity.LinkedAt.Unix(),
+	}
+}
@@ -0,0 +1,140 @@
+package transport
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"runtime/debug"
+	"strconv"
+	"time"
+
+	"github.com/kuromii5/sync-auth/pkg/logger/ctxlog"
+	le "github.com/kuromii5/sync-auth/pkg/logger/l_err"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	requestIDHeader    = "x-request-id"
+	maxRequestIDLength = 128
+)
+
+// requestIDInterceptor takes x-request-id of the caller or generates a new one,
+// sends it back in response headers and attaches logger with it to the context
+func requestIDInterceptor(log *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		id := incomingRequestID(ctx)
+		if id == "" {
+			id = newRequestID()
+		}
+
+		if err := grpc.SetHeader(ctx, metadata.Pairs(requestIDHeader, id)); err != nil {
+			log.Debug("failed to set request id header", le.Err(err))
+		}
+
+		return handler(ctxlog.With(ctx, log.With(slog.String("request_id", id))), req)
+	}
+}
+
+// accessLogInterceptor logs every request when it is finished. Panic is logged
+// as Internal error and passed on to the recovery interceptor
+func accessLogInterceptor(log *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+		start := time.Now()
+		defer func() {
+			if r := recover(); r != nil {
+				ctxlog.From(ctx, log).Error("request finished",
+					slog.String("method", info.FullMethod),
+					slog.String("code", codes.Internal.String()),
+					slog.Duration("duration", time.Since(start)),
+					slog.String("peer", peerAddr(ctx)),
+					slog.Any("panic", r),
+				)
+				panic(r)
+			}
+		}()
+
+		resp, err = handler(ctx, req)
+
+		code := status.Code(err)
+		attrs := []any{
+			slog.String("method", info.FullMethod),
+			slog.String("code", code.String()),
+			slog.Duration("duration", time.Since(start)),
+			slog.String("peer", peerAddr(ctx)),
+			slog.String("ip", clientInfo(ctx).IP),
+		}
+
+		switch code {
+		case codes.Internal, codes.Unknown, codes.DataLoss:
+			ctxlog.From(ctx, log).Error("request finished", attrs...)
+		default:
+			ctxlog.From(ctx, log).Info("request finished", attrs...)
+		}
+
+		return resp, err
+	}
+}
+
+// recoveryInterceptor turns panic of the handler or other interceptors
+// into Internal error, so one bad request doesn't crash the service
+func recoveryInterceptor(log *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				ctxlog.From(ctx, log).Error("panic recovered",
+					slog.String("method", info.FullMethod),
+					slog.Any("panic", r),
+					slog.String("stack", string(debug.Stack())),
+				)
+				err = status.Error(codes.Internal, "internal error")
+			}
+		}()
+
+		return handler(ctx, req)
+	}
+}
+
+// incomingRequestID returns request id of the caller, ids which are too long
+// or have unexpected characters are ignored since they get into logs
+func incomingRequestID(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	ids := md.Get(requestIDHeader)
+	if len(ids) == 0 {
+		return ""
+	}
+
+	id := ids[0]
+	if len(id) > maxRequestIDLength {
+		return ""
+	}
+	for _, c := range id {
+		isAlnum := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+		if !isAlnum && c != '-' && c != '_' && c != '.' && c != ':' {
+			return ""
+		}
+	}
+
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+func peerAddr(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		return p.Addr.String()
+	}
+
+	return ""
+}
@@ -0,0 +1,154 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/kuromii5/sync-auth/pkg/logger/ctxlog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var testInfo = &grpc.UnaryServerInfo{FullMethod: "/auth.Auth/Login"}
+
+func testLogger() (*slog.Logger, *bytes.Buffer) {
+	var buf bytes.Buffer
+
+	return slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})), &buf
+}
+
+// logLines returns JSON log records with the message
+func logLines(t *testing.T, buf *bytes.Buffer, msg string) []map[string]any {
+	t.Helper()
+
+	var lines []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		if record["msg"] == msg {
+			lines = append(lines, record)
+		}
+	}
+
+	return lines
+}
+
+func TestRequestIDInterceptor(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming string
+		keep     bool
+	}{
+		{name: "propagated", incoming: "4f2c1e0a-9b7d-4c3e-8a1f-6d5e4c3b2a19", keep: true},
+		{name: "generated", incoming: ""},
+		{name: "unexpected characters", incoming: "id\nforged=1"},
+		{name: "too long", incoming: strings.Repeat("a", maxRequestIDLength+1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log, buf := testLogger()
+			ctx := context.Background()
+			if tt.incoming != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(requestIDHeader, tt.incoming))
+			}
+
+			_, err := requestIDInterceptor(log)(ctx, nil, testInfo, func(ctx context.Context, req any) (any, error) {
+				ctxlog.From(ctx, nil).Info("handled")
+
+				return nil, nil
+			})
+			require.NoError(t, err)
+
+			lines := logLines(t, buf, "handled")
+			require.Len(t, lines, 1)
+			id, _ := lines[0]["request_id"].(string)
+			if tt.keep {
+				assert.Equal(t, tt.incoming, id)
+			} else {
+				assert.Len(t, id, 32)
+				assert.NotEqual(t, tt.incoming, id)
+			}
+		})
+	}
+}
+
+func TestAccessLogInterceptor(t *testing.T) {
+	log, buf := testLogger()
+
+	_, err := accessLogInterceptor(log)(context.Background(), nil, testInfo, func(ctx context.Context, req any) (any, error) {
+		return nil, status.Error(codes.Unauthenticated, "invalid credentials")
+	})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	lines := logLines(t, buf, "request finished")
+	require.Len(t, lines, 1)
+	assert.Equal(t, "INFO", lines[0]["level"])
+	assert.Equal(t, "/auth.Auth/Login", lines[0]["method"])
+	assert.Equal(t, "Unauthenticated", lines[0]["code"])
+	assert.Contains(t, lines[0], "duration")
+	assert.Contains(t, lines[0], "peer")
+}
+
+// chain runs interceptors in order like grpc.ChainUnaryInterceptor
+func chain(interceptors []grpc.UnaryServerInterceptor, handler grpc.UnaryHandler) grpc.UnaryHandler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], handler
+		handler = func(ctx context.Context, req any) (any, error) {
+			return interceptor(ctx, req, testInfo, next)
+		}
+	}
+
+	return handler
+}
+
+func TestRecoveryInterceptor(t *testing.T) {
+	log, buf := testLogger()
+
+	handler := chain(unaryInterceptors(log, nil), func(ctx context.Context, req any) (any, error) {
+		panic("nil map")
+	})
+	resp, err := handler(context.Background(), nil)
+
+	assert.Nil(t, resp)
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.Equal(t, "internal error", status.Convert(err).Message())
+
+	panics := logLines(t, buf, "panic recovered")
+	require.Len(t, panics, 1)
+	assert.Equal(t, "nil map", panics[0]["panic"])
+	assert.Contains(t, panics[0]["stack"], "runtime/debug.Stack")
+
+	// access log marks the panic and has request id
+	finished := logLines(t, buf, "request finished")
+	require.Len(t, finished, 1)
+	assert.Equal(t, "ERROR", finished[0]["level"])
+	assert.Equal(t, "Internal", finished[0]["code"])
+	assert.Equal(t, "nil map", finished[0]["panic"])
+	assert.NotEmpty(t, finished[0]["request_id"])
+
+	// stack trace is matched to the access log by request id
+	assert.Equal(t, finished[0]["request_id"], panics[0]["request_id"])
+}
+
+func TestRecoveryInterceptor_LoggingInterceptorPanics(t *testing.T) {
+	log, _ := testLogger()
+
+	panicking := func(context.Context, any, *grpc.UnaryServerInfo, grpc.UnaryHandler) (any, error) {
+		panic("logging failed")
+	}
+	handler := chain([]grpc.UnaryServerInterceptor{recoveryInterceptor(log), panicking}, func(ctx context.Context, req any) (any, error) {
+		return nil, nil
+	})
+
+	_, err := handler(context.Background(), nil)
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
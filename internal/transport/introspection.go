@@ -0,0 +1,97 @@
+package transport
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+type introspectionResponse struct {
+	Active   bool   `json:"active"`
+	Scope    string `json:"scope,omitempty"`
+	ClientID string `json:"client_id,omitempty"`
+	Sub      string `json:"sub,omitempty"`
+	Exp      int64  `json:"exp,omitempty"`
+	Iat      int64  `json:"iat,omitempty"`
+	Iss      string `json:"iss,omitempty"`
+	Sid      string `json:"sid,omitempty"`
+}
+
+type oauthError struct {
+	Error string `json:"error"`
+}
+
+// Introspect implements token introspection (RFC 7662), refresh tokens
+// also require fingerprint parameter
+func (a *httpApi) Introspect(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, oauthError{Error: "invalid_request"})
+
+		return
+	}
+
+	if !a.authenticateClient(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="introspection"`)
+		writeJSON(w, http.StatusUnauthorized, oauthError{Error: "invalid_client"})
+
+		return
+	}
+
+	token := r.PostForm.Get("token")
+	if token == "" {
+		writeJSON(w, http.StatusBadRequest, oauthError{Error: "invalid_request"})
+
+		return
+	}
+
+	introspection := a.auth.IntrospectToken(r.Context(), token, r.PostForm.Get("token_type_hint"), r.PostForm.Get("fingerprint"))
+	if !introspection.Active {
+		writeJSON(w, http.StatusOK, introspectionResponse{Active: false})
+
+		return
+	}
+
+	response := introspectionResponse{
+		Active:   true,
+		Scope:    introspection.Scope,
+		ClientID: introspection.ClientID,
+		Sub:      strconv.Itoa(int(introspection.UserID)),
+		Iss:      introspection.Issuer,
+		Sid:      introspection.SessionID,
+	}
+	if !introspection.ExpiresAt.IsZero() {
+		response.Exp = introspection.ExpiresAt.Unix()
+	}
+	if !introspection.IssuedAt.IsZero() {
+		response.Iat = introspection.IssuedAt.Unix()
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// authenticateClient checks client_secret_basic or client_secret_post credentials
+func (a *httpApi) authenticateClient(r *http.Request) bool {
+	clientID, secret, ok := r.BasicAuth()
+	if ok {
+		// credentials are form-urlencoded before Basic encoding (RFC 6749, 2.3.1)
+		var err error
+		if clientID, err = url.QueryUnescape(clientID); err != nil {
+			return false
+		}
+		if secret, err = url.QueryUnescape(secret); err != nil {
+			return false
+		}
+	} else {
+		clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+
+	expected, found := a.introspectionClients[clientID]
+	if clientID == "" || !found || expected == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(secret), []byte(expected)) == 1
+}
@@ -0,0 +1,129 @@
+package transport
+
+import (
+	"context"
+	"errors"
+
+	auth "github.com/kuromii5/sync-auth/api/sync-auth/v1"
+	"github.com/kuromii5/sync-auth/internal/models"
+	"github.com/kuromii5/sync-auth/internal/service"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (a *api) VerifyMFA(ctx context.Context, req *auth.VerifyMFARequest) (*auth.AuthResponse, error) {
+	if req.GetMfaToken() == "" {
+		return nil, status.Error(codes.Unauthenticated, service.ErrInvalidMFAToken.Error())
+	}
+	if req.GetCode() == "" {
+		return nil, status.Error(codes.InvalidArgument, ErrRequired.Error())
+	}
+
+	if err := a.auth.VerifyMFA(ctx, req.GetMfaToken(), req.GetCode()); err != nil {
+		if st, ok := mfaStatus(err); ok {
+			return nil, st
+		}
+
+		return nil, status.Error(codes.Internal, "failed to verify second factor")
+	}
+
+	return &auth.AuthResponse{}, nil
+}
+
+func (a *api) EnrollTOTP(ctx context.Context, req *auth.EnrollTOTPRequest) (*auth.EnrollTOTPResponse, error) {
+	enrollment, err := a.auth.EnrollTOTP(ctx, req.GetAccessToken())
+	if err != nil {
+		if st, ok := mfaStatus(err); ok {
+			return nil, st
+		}
+
+		return nil, status.Error(codes.Internal, "failed to enroll totp")
+	}
+
+	return &auth.EnrollTOTPResponse{
+		Secret: enrollment.Secret,
+		Uri:    enrollment.URI,
+	}, nil
+}
+
+func (a *api) ConfirmTOTP(ctx context.Context, req *auth.ConfirmTOTPRequest) (*auth.RecoveryCodesResponse, error) {
+	if req.GetCode() == "" {
+		return nil, status.Error(codes.InvalidArgument, ErrRequired.Error())
+	}
+
+	recoveryCodes, err := a.auth.ConfirmTOTP(ctx, req.GetAccessToken(), req.GetCode())
+	if err != nil {
+		if st, ok := mfaStatus(err); ok {
+			return nil, st
+		}
+
+		return nil, status.Error(codes.Internal, "failed to confirm totp")
+	}
+
+	return &auth.RecoveryCodesResponse{RecoveryCodes: recoveryCodes}, nil
+}
+
+func (a *api) DisableTOTP(ctx context.Context, req *auth.DisableTOTPRequest) (*auth.DisableTOTPResponse, error) {
+	if req.GetCode() == "" {
+		return nil, status.Error(codes.InvalidArgument, ErrRequired.Error())
+	}
+
+	if err := a.auth.DisableTOTP(ctx, req.GetAccessToken(), req.GetCode()); err != nil {
+		if st, ok := mfaStatus(err); ok {
+			return nil, st
+		}
+
+		return nil, status.Error(codes.Internal, "failed to disable totp")
+	}
+
+	return &auth.DisableTOTPResponse{}, nil
+}
+
+func (a *api) RegenerateRecoveryCodes(ctx context.Context, req *auth.RegenerateRecoveryCodesRequest) (*auth.RecoveryCodesResponse, error) {
+	if req.GetCode() == "" {
+		return nil, status.Error(codes.InvalidArgument, ErrRequired.Error())
+	}
+
+	recoveryCodes, err := a.auth.RegenerateRecoveryCodes(ctx, req.GetAccessToken(), req.GetCode())
+	if err != nil {
+		if st, ok := mfaStatus(err); ok {
+			return nil, st
+		}
+
+		return nil, status.Error(codes.Internal, "failed to regenerate recovery codes")
+	}
+
+	return &auth.RecoveryCodesResponse{RecoveryCodes: recoveryCodes}, nil
+}
+
+// authResponse tells the client to call VerifyMFA if login needs the second factor
+func authResponse(challenge models.MFAChallenge) *auth.AuthResponse {
+	if challenge.Token == "" {
+		return &auth.AuthResponse{}
+	}
+
+	return &auth.AuthResponse{
+		MfaRequired: true,
+		MfaToken:    challenge.Token,
+		MfaTokenTTL: seconds(challenge.ExpiresIn),
+		MfaMethods:  challenge.Methods,
+	}
+}
+
+// mfaStatus maps errors of two-factor authentication
+func mfaStatus(err error) (error, bool) {
+	switch {
+	case errors.Is(err, service.ErrUnauthenticated):
+		return status.Error(codes.Unauthenticated, "invalid access token"), true
+	case errors.Is(err, service.ErrInvalidMFAToken):
+		return status.Error(codes.Unauthenticated, service.ErrInvalidMFAToken.Error()), true
+	case errors.Is(err, service.ErrInvalidMFACode):
+		return status.Error(codes.InvalidArgument, service.ErrInvalidMFACode.Error()), true
+	case errors.Is(err, service.ErrMFAEnabled):
+		return status.Error(codes.FailedPrecondition, service.ErrMFAEnabled.Error()), true
+	case errors.Is(err, service.ErrMFANotEnabled):
+		return status.Error(codes.FailedPrecondition, service.ErrMFANotEnabled.Error()), true
+	default:
+		return nil, false
+	}
+}
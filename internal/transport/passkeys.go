@@ -0,0 +1,174 @@
+package transport
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+
+	auth "github.com/kuromii5/sync-auth/api/sync-auth/v1"
+	"github.com/kuromii5/sync-auth/internal/models"
+	"github.com/kuromii5/sync-auth/internal/service"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (a *api) BeginPasskeyRegistration(ctx context.Context, req *auth.BeginPasskeyRegistrationRequest) (*auth.PasskeyOptionsResponse, error) {
+	options, err := a.auth.BeginPasskeyRegistration(ctx, req.GetAccessToken())
+	if err != nil {
+		if st, ok := passkeyStatus(err); ok {
+			return nil, st
+		}
+
+		return nil, status.Error(codes.Internal, "failed to start passkey registration")
+	}
+
+	return passkeyOptions(options)
+}
+
+func (a *api) FinishPasskeyRegistration(ctx context.Context, req *auth.FinishPasskeyRegistrationRequest) (*auth.FinishPasskeyRegistrationResponse, error) {
+	if req.GetCredential() == "" {
+		return nil, status.Error(codes.InvalidArgument, ErrRequired.Error())
+	}
+
+	passkey, err := a.auth.FinishPasskeyRegistration(ctx, req.GetAccessToken(), req.GetName(), []byte(req.GetCredential()))
+	if err != nil {
+		if st, ok := passkeyStatus(err); ok {
+			return nil, st
+		}
+
+		return nil, status.Error(codes.Internal, "failed to register passkey")
+	}
+
+	return &auth.FinishPasskeyRegistrationResponse{Passkey: passkeyToProto(passkey)}, nil
+}
+
+func (a *api) ListPasskeys(ctx context.Context, req *auth.ListPasskeysRequest) (*auth.ListPasskeysResponse, error) {
+	passkeys, err := a.auth.ListPasskeys(ctx, req.GetAccessToken())
+	if err != nil {
+		if st, ok := passkeyStatus(err); ok {
+			return nil, st
+		}
+
+		return nil, status.Error(codes.Internal, "failed to list passkeys")
+	}
+
+	response := &auth.ListPasskeysResponse{Passkeys: make([]*auth.Passkey, 0, len(passkeys))}
+	for _, passkey := range passkeys {
+		response.Passkeys = append(response.Passkeys, passkeyToProto(passkey))
+	}
+
+	return response, nil
+}
+
+func (a *api) DeletePasskey(ctx context.Context, req *auth.DeletePasskeyRequest) (*auth.DeletePasskeyResponse, error) {
+	id, err := base64.RawURLEncoding.DecodeString(req.GetId())
+	if err != nil || len(id) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "invalid passkey id")
+	}
+
+	if err := a.auth.DeletePasskey(ctx, req.GetAccessToken(), id); err != nil {
+		if st, ok := passkeyStatus(err); ok {
+			return nil, st
+		}
+
+		return nil, status.Error(codes.Internal, "failed to delete passkey")
+	}
+
+	return &auth.DeletePasskeyResponse{}, nil
+}
+
+func (a *api) BeginPasskeyLogin(ctx context.Context, req *auth.BeginPasskeyLoginRequest) (*auth.PasskeyOptionsResponse, error) {
+	options, err := a.auth.BeginPasskeyLogin(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to start passkey login")
+	}
+
+	return passkeyOptions(options)
+}
+
+func (a *api) FinishPasskeyLogin(ctx context.Context, req *auth.FinishPasskeyLoginRequest) (*auth.AuthResponse, error) {
+	if req.GetCredential() == "" {
+		return nil, status.Error(codes.InvalidArgument, ErrRequired.Error())
+	}
+
+	if err := a.auth.FinishPasskeyLogin(ctx, []byte(req.GetCredential()), req.GetFingerprint()); err != nil {
+		if st, ok := passkeyStatus(err); ok {
+			return nil, st
+		}
+
+		return nil, status.Error(codes.Internal, "failed to login with passkey")
+	}
+
+	return &auth.AuthResponse{}, nil
+}
+
+func (a *api) BeginPasskeyMFA(ctx context.Context, req *auth.BeginPasskeyMFARequest) (*auth.PasskeyOptionsResponse, error) {
+	if req.GetMfaToken() == "" {
+		return nil, status.Error(codes.Unauthenticated, service.ErrInvalidMFAToken.Error())
+	}
+
+	options, err := a.auth.BeginPasskeyMFA(ctx, req.GetMfaToken())
+	if err != nil {
+		if st, ok := passkeyStatus(err); ok {
+			return nil, st
+		}
+
+		return nil, status.Error(codes.Internal, "failed to start passkey verification")
+	}
+
+	return passkeyOptions(options)
+}
+
+func (a *api) FinishPasskeyMFA(ctx context.Context, req *auth.FinishPasskeyMFARequest) (*auth.AuthResponse, error) {
+	if req.GetMfaToken() == "" {
+		return nil, status.Error(codes.Unauthenticated, service.ErrInvalidMFAToken.Error())
+	}
+	if req.GetCredential() == "" {
+		return nil, status.Error(codes.InvalidArgument, ErrRequired.Error())
+	}
+
+	if err := a.auth.FinishPasskeyMFA(ctx, req.GetMfaToken(), []byte(req.GetCredential())); err != nil {
+		if st, ok := passkeyStatus(err); ok {
+			return nil, st
+		}
+
+		return nil, status.Error(codes.Internal, "failed to verify passkey")
+	}
+
+	return &auth.AuthResponse{}, nil
+}
+
+// passkeyOptions serializes options for parseCreationOptionsFromJSON and parseRequestOptionsFromJSON
+func passkeyOptions(options any) (*auth.PasskeyOptionsResponse, error) {
+	data, err := json.Marshal(options)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to encode passkey options")
+	}
+
+	return &auth.PasskeyOptionsResponse{Options: string(data)}, nil
+}
+
+func passkeyToProto(passkey models.Passkey) *auth.Passkey {
+	return &auth.Passkey{
+		Id:         base64.RawURLEncoding.EncodeToString(passkey.ID),
+		Name:       passkey.Name,
+		Transports: passkey.Transports,
+		CreatedAt:  passkey.CreatedAt.Unix(),
+		LastUsedAt: passkey.LastUsedAt.Unix(),
+	}
+}
+
+// passkeyStatus maps errors of passkey flows, the reason of failed verification is not exposed
+func passkeyStatus(err error) (error, bool) {
+	switch {
+	case errors.Is(err, service.ErrInvalidPasskey):
+		return status.Error(codes.Unauthenticated, service.ErrInvalidPasskey.Error()), true
+	case errors.Is(err, service.ErrPasskeyExists):
+		return status.Error(codes.AlreadyExists, service.ErrPasskeyExists.Error()), true
+	case errors.Is(err, service.ErrPasskeyNotFound):
+		return status.Error(codes.NotFound, service.ErrPasskeyNotFound.Error()), true
+	default:
+		return mfaStatus(err)
+	}
+}
@@ -0,0 +1,44 @@
+package transport
+
+import (
+	"context"
+	"errors"
+
+	auth "github.com/kuromii5/sync-auth/api/sync-auth/v1"
+	"github.com/kuromii5/sync-auth/internal/service"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (a *api) StartPasswordlessLogin(ctx context.Context, req *auth.StartPasswordlessLoginRequest) (*auth.StartPasswordlessLoginResponse, error) {
+	if err := validateEmail(req.GetEmail()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	response, err := a.auth.StartPasswordlessLogin(ctx, req.GetEmail())
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to start passwordless login")
+	}
+
+	return &auth.StartPasswordlessLoginResponse{
+		CodeTTL:     seconds(response.CodeTTL),
+		ResendAfter: seconds(response.ResendAfter),
+	}, nil
+}
+
+func (a *api) CompletePasswordlessLogin(ctx context.Context, req *auth.CompletePasswordlessLoginRequest) (*auth.AuthResponse, error) {
+	if err := validatePasswordlessLogin(req); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	challenge, err := a.auth.CompletePasswordlessLogin(ctx, req.GetEmail(), req.GetCode(), req.GetToken(), req.GetFingerprint())
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidLoginCode) {
+			return nil, status.Error(codes.InvalidArgument, service.ErrInvalidLoginCode.Error())
+		}
+
+		return nil, status.Error(codes.Internal, "failed to complete passwordless login")
+	}
+
+	return authResponse(challenge), nil
+}
@@ -3,47 +3,110 @@ package transport
 import (
 	"context"
 	"errors"
+	"fmt"
+	"log/slog"
+	"time"
 
 	auth "github.com/kuromii5/sync-auth/api/sync-auth/v1"
 	"github.com/kuromii5/sync-auth/internal/models"
 	"github.com/kuromii5/sync-auth/internal/repo/redis"
 	"github.com/kuromii5/sync-auth/internal/service"
+	"github.com/kuromii5/sync-auth/internal/service/cookies"
+	"github.com/kuromii5/sync-auth/internal/service/oauth"
+	"github.com/kuromii5/sync-auth/internal/service/webauthn"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/reflection"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
 )
 
 type api struct {
 	auth.UnimplementedAuthServer
-	auth *service.Auth
+	auth            *service.Auth
+	adminToken      string
+	rotationKeyFile string
 }
 
 type Auth interface {
 	SignUp(ctx context.Context, email, password string) (int32, error)
-	Login(ctx context.Context, email, password, fingerprint string) (models.TokenPair, error)
+	Login(ctx context.Context, email, password, fingerprint string) (models.MFAChallenge, error)
 	Logout(ctx context.Context, accessToken, fingerprint string) error
 
-	ExchangeCodeForToken(ctx context.Context, code, provider string) (models.TokenPair, error)
+	RequestPasswordReset(ctx context.Context, email string) error
+	ResetPassword(ctx context.Context, token, password string) error
+	ChangePassword(ctx context.Context, accessToken, currentPassword, newPassword string, signOutOthers bool) (int, error)
+
+	StartPasswordlessLogin(ctx context.Context, email string) (models.VerifyEmailResp, error)
+	CompletePasswordlessLogin(ctx context.Context, email string, code int32, token, fingerprint string) (models.MFAChallenge, error)
+
+	VerifyMFA(ctx context.Context, mfaToken, code string) error
+	EnrollTOTP(ctx context.Context, accessToken string) (models.TOTPEnrollment, error)
+	ConfirmTOTP(ctx context.Context, accessToken, code string) ([]string, error)
+	DisableTOTP(ctx context.Context, accessToken, code string) error
+	RegenerateRecoveryCodes(ctx context.Context, accessToken, code string) ([]string, error)
+
+	BeginPasskeyRegistration(ctx context.Context, accessToken string) (webauthn.CreationOptions, error)
+	FinishPasskeyRegistration(ctx context.Context, accessToken, name string, credentialJSON []byte) (models.Passkey, error)
+	ListPasskeys(ctx context.Context, accessToken string) ([]models.Passkey, error)
+	DeletePasskey(ctx context.Context, accessToken string, id []byte) error
+	BeginPasskeyLogin(ctx context.Context) (webauthn.RequestOptions, error)
+	FinishPasskeyLogin(ctx context.Context, credentialJSON []byte, fingerprint string) error
+	BeginPasskeyMFA(ctx context.Context, mfaToken string) (webauthn.RequestOptions, error)
+	FinishPasskeyMFA(ctx context.Context, mfaToken string, credentialJSON []byte) error
+
+	OAuthURL(ctx context.Context, provider string) (models.OAuthRedirect, error)
+	ExchangeCodeForToken(ctx context.Context, code, provider, state, fingerprint string) (models.MFAChallenge, error)
 
 	VerifyEmail(ctx context.Context, accessToken string) (models.VerifyEmailResp, error)
 	ConfirmCode(ctx context.Context, code int32, accessToken string) (models.ConfirmCodeResp, error)
-
-	GetAccessToken(ctx context.Context, refreshToken, fingerprint string) (string, error)
-	ValidateAccessToken(ctx context.Context, token string) (int32, error)
+	RequestEmailChange(ctx context.Context, accessToken, newEmail string) (models.VerifyEmailResp, error)
+	ConfirmEmailChange(ctx context.Context, accessToken string, code int32) (models.ConfirmCodeResp, error)
+
+	LinkIdentityURL(ctx context.Context, accessToken, provider string) (models.OAuthRedirect, error)
+	LinkIdentity(ctx context.Context, accessToken, provider, code, state string) (models.Identity, error)
+	UnlinkIdentity(ctx context.Context, accessToken, provider string) error
+	ListIdentities(ctx context.Context, accessToken string) ([]models.Identity, error)
+
+	ListSessions(ctx context.Context, accessToken string) ([]models.Session, error)
+	RevokeSession(ctx context.Context, accessToken, sessionID string) error
+	RevokeAllOtherSessions(ctx context.Context, accessToken string) (int, error)
+
+	GetAccessToken(ctx context.Context, refreshToken, fingerprint string) (models.TokenPair, error)
+	ValidateAccessToken(ctx context.Context, token string) (models.AccessClaims, error)
+	IntrospectToken(ctx context.Context, token, tokenTypeHint, fingerprint string) models.TokenIntrospection
+	JWKS(ctx context.Context) []models.JWK
+	RotateSigningKey(ctx context.Context, keyFile string) (models.KeyRotation, error)
+	RevokeUserTokens(ctx context.Context, userID int32) error
 }
 
-func NewGrpcServer(authApi *service.Auth) *grpc.Server {
-	api := &api{auth: authApi}
+func NewGrpcServer(log *slog.Logger, authApi *service.Auth, adminToken, rotationKeyFile string) *grpc.Server {
+	api := &api{auth: authApi, adminToken: adminToken, rotationKeyFile: rotationKeyFile}
 
-	grpc := grpc.NewServer(grpc.Creds(insecure.NewCredentials()))
+	grpc := grpc.NewServer(
+		grpc.Creds(insecure.NewCredentials()),
+		grpc.ChainUnaryInterceptor(unaryInterceptors(log, authApi.Cookies)...),
+	)
 	reflection.Register(grpc)
 	auth.RegisterAuthServer(grpc, api)
 
 	return grpc
 }
 
+// unaryInterceptors are run in order. Request id goes first, so logs of the
+// rest have it, recovery goes next to catch panics of the others too
+func unaryInterceptors(log *slog.Logger, cookiePolicy *cookies.Policy) []grpc.UnaryServerInterceptor {
+	return []grpc.UnaryServerInterceptor{
+		requestIDInterceptor(log),
+		recoveryInterceptor(log),
+		accessLogInterceptor(log),
+		clientInfoInterceptor,
+		cookieTokensInterceptor(cookiePolicy),
+	}
+}
+
 func (a *api) SignUp(ctx context.Context, req *auth.SignUpRequest) (*auth.AuthResponse, error) {
 	err := validateSignUpRequest(req)
 	if err != nil {
@@ -59,8 +122,8 @@ func (a *api) SignUp(ctx context.Context, req *auth.SignUpRequest) (*auth.AuthRe
 		return nil, status.Error(codes.Internal, "internal register error")
 	}
 
-	// automatically log in after register
-	err = a.auth.Login(ctx, req.GetEmail(), req.GetPassword(), req.GetFingerprint())
+	// automatically log in after register, new user has no second factor
+	_, err = a.auth.Login(ctx, req.GetEmail(), req.GetPassword(), req.GetFingerprint())
 	if err != nil {
 		return nil, status.Error(codes.Internal, "internal login error")
 	}
@@ -74,7 +137,7 @@ func (a *api) Login(ctx context.Context, req *auth.LoginRequest) (*auth.AuthResp
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	err = a.auth.Login(ctx, req.GetEmail(), req.GetPassword(), req.GetFingerprint())
+	challenge, err := a.auth.Login(ctx, req.GetEmail(), req.GetPassword(), req.GetFingerprint())
 	if err != nil {
 		if errors.Is(err, service.ErrInvalidCreds) {
 			return nil, status.Error(codes.InvalidArgument, "invalid credentials")
@@ -83,7 +146,7 @@ func (a *api) Login(ctx context.Context, req *auth.LoginRequest) (*auth.AuthResp
 		return nil, status.Error(codes.Internal, "internal login error")
 	}
 
-	return &auth.AuthResponse{}, nil
+	return authResponse(challenge), nil
 }
 
 func (a *api) Logout(ctx context.Context, req *auth.LogoutRequest) (*auth.LogoutResponse, error) {
@@ -94,16 +157,73 @@ func (a *api) Logout(ctx context.Context, req *auth.LogoutRequest) (*auth.Logout
 	return &auth.LogoutResponse{}, nil
 }
 
+func (a *api) RequestPasswordReset(ctx context.Context, req *auth.RequestPasswordResetRequest) (*auth.RequestPasswordResetResponse, error) {
+	if req.GetEmail() == "" {
+		return nil, status.Error(codes.InvalidArgument, ErrRequired.Error())
+	}
+
+	if err := a.auth.RequestPasswordReset(ctx, req.GetEmail()); err != nil {
+		return nil, status.Error(codes.Internal, "failed to request password reset")
+	}
+
+	return &auth.RequestPasswordResetResponse{}, nil
+}
+
+func (a *api) ResetPassword(ctx context.Context, req *auth.ResetPasswordRequest) (*auth.ResetPasswordResponse, error) {
+	if req.GetToken() == "" {
+		return nil, status.Error(codes.InvalidArgument, service.ErrInvalidResetToken.Error())
+	}
+	if err := validatePassword(req.GetPassword()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := a.auth.ResetPassword(ctx, req.GetToken(), req.GetPassword()); err != nil {
+		if errors.Is(err, service.ErrInvalidResetToken) {
+			return nil, status.Error(codes.InvalidArgument, service.ErrInvalidResetToken.Error())
+		}
+
+		return nil, status.Error(codes.Internal, "failed to reset password")
+	}
+
+	return &auth.ResetPasswordResponse{}, nil
+}
+
+func (a *api) ChangePassword(ctx context.Context, req *auth.ChangePasswordRequest) (*auth.ChangePasswordResponse, error) {
+	if req.GetCurrentPassword() == "" {
+		return nil, status.Error(codes.InvalidArgument, ErrRequired.Error())
+	}
+	if err := validatePassword(req.GetNewPassword()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	revoked, err := a.auth.ChangePassword(ctx, req.GetAccessToken(), req.GetCurrentPassword(), req.GetNewPassword(), req.GetSignOutOtherDevices())
+	if err != nil {
+		if errors.Is(err, service.ErrUnauthenticated) {
+			return nil, status.Error(codes.Unauthenticated, "invalid access token")
+		}
+		if errors.Is(err, service.ErrInvalidCreds) {
+			return nil, status.Error(codes.InvalidArgument, "invalid credentials")
+		}
+
+		return nil, status.Error(codes.Internal, "failed to change password")
+	}
+
+	return &auth.ChangePasswordResponse{
+		RevokedSessions: int32(revoked),
+	}, nil
+}
+
 func (a *api) VerifyEmail(ctx context.Context, req *auth.VerifyEmailRequest) (*auth.VerifyEmailResponse, error) {
 	response, err := a.auth.VerifyEmail(ctx, req.GetAccessToken())
 	if err != nil {
+		if errors.Is(err, service.ErrTooManyRequests) {
+			return nil, tooManyRequests(response.ResendAfter)
+		}
+
 		return nil, status.Error(codes.Internal, "internal server error")
 	}
 
-	return &auth.VerifyEmailResponse{
-		Status:  response.Status,
-		CodeTTL: int32(response.CodeTTL.Seconds()),
-	}, nil
+	return verifyEmailResponse(response), nil
 }
 
 func (a *api) ConfirmCode(ctx context.Context, req *auth.ConfirmCodeRequest) (*auth.ConfirmCodeResponse, error) {
@@ -112,43 +232,289 @@ func (a *api) ConfirmCode(ctx context.Context, req *auth.ConfirmCodeRequest) (*a
 		return nil, status.Error(codes.Internal, "internal server error")
 	}
 
+	return confirmCodeResponse(response), nil
+}
+
+func (a *api) RequestEmailChange(ctx context.Context, req *auth.RequestEmailChangeRequest) (*auth.VerifyEmailResponse, error) {
+	if err := validateEmail(req.GetNewEmail()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	response, err := a.auth.RequestEmailChange(ctx, req.GetAccessToken(), req.GetNewEmail())
+	if err != nil {
+		if errors.Is(err, service.ErrUnauthenticated) {
+			return nil, status.Error(codes.Unauthenticated, "invalid access token")
+		}
+		if errors.Is(err, service.ErrUserExists) {
+			return nil, status.Error(codes.AlreadyExists, "email is already taken")
+		}
+		if errors.Is(err, service.ErrTooManyRequests) {
+			return nil, tooManyRequests(response.ResendAfter)
+		}
+
+		return nil, status.Error(codes.Internal, "failed to request email change")
+	}
+
+	return verifyEmailResponse(response), nil
+}
+
+func (a *api) ConfirmEmailChange(ctx context.Context, req *auth.ConfirmCodeRequest) (*auth.ConfirmCodeResponse, error) {
+	response, err := a.auth.ConfirmEmailChange(ctx, req.GetAccessToken(), req.GetCode())
+	if err != nil {
+		if errors.Is(err, service.ErrUnauthenticated) {
+			return nil, status.Error(codes.Unauthenticated, "invalid access token")
+		}
+		if errors.Is(err, service.ErrUserExists) {
+			return nil, status.Error(codes.AlreadyExists, "email is already taken")
+		}
+
+		return nil, status.Error(codes.Internal, "failed to confirm email change")
+	}
+
+	return confirmCodeResponse(response), nil
+}
+
+func verifyEmailResponse(response models.VerifyEmailResp) *auth.VerifyEmailResponse {
+	return &auth.VerifyEmailResponse{
+		Status:      response.Status,
+		CodeTTL:     int32(response.CodeTTL.Seconds()),
+		ResendAfter: seconds(response.ResendAfter),
+		SendsLeft:   int32(response.SendsLeft),
+	}
+}
+
+func confirmCodeResponse(response models.ConfirmCodeResp) *auth.ConfirmCodeResponse {
 	return &auth.ConfirmCodeResponse{
-		Success: response.Success,
-		Message: response.Message,
+		Success:      response.Success,
+		Message:      response.Message,
+		AttemptsLeft: int32(response.AttemptsLeft),
+	}
+}
+
+// tooManyRequests returns RESOURCE_EXHAUSTED with RetryInfo telling when to retry
+func tooManyRequests(retryAfter time.Duration) error {
+	st := status.New(codes.ResourceExhausted, fmt.Sprintf("too many requests, retry after %ds", seconds(retryAfter)))
+
+	detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(retryAfter)})
+	if err != nil {
+		return st.Err()
+	}
+
+	return detailed.Err()
+}
+
+// seconds rounds duration up to whole seconds
+func seconds(d time.Duration) int32 {
+	return int32((d + time.Second - 1) / time.Second)
+}
+
+func (a *api) GetOAuthURL(ctx context.Context, req *auth.GetOAuthURLRequest) (*auth.GetOAuthURLResponse, error) {
+	redirect, err := a.auth.OAuthURL(ctx, req.GetProvider())
+	if err != nil {
+		if errors.Is(err, oauth.ErrUnknownProvider) {
+			return nil, status.Error(codes.InvalidArgument, "unknown oauth provider")
+		}
+
+		return nil, status.Error(codes.Internal, "failed to generate oauth url")
+	}
+
+	return &auth.GetOAuthURLResponse{
+		Url:       redirect.URL,
+		State:     redirect.State,
+		ExpiresAt: redirect.ExpiresAt.Unix(),
 	}, nil
 }
 
 func (a *api) ExchangeCodeForToken(ctx context.Context, req *auth.ExchangeCodeRequest) (*auth.AuthResponse, error) {
-	err := a.auth.ExchangeCodeForToken(ctx, req.GetCode(), req.GetProvider(), req.GetFingerprint())
+	if req.GetState() == "" {
+		return nil, status.Error(codes.InvalidArgument, "state is required")
+	}
+
+	challenge, err := a.auth.ExchangeCodeForToken(ctx, req.GetCode(), req.GetProvider(), req.GetState(), req.GetFingerprint())
 	if err != nil {
+		if st, ok := oauthStatus(err); ok {
+			return nil, st
+		}
+		if errors.Is(err, service.ErrEmailNotVerified) {
+			return nil, status.Error(codes.FailedPrecondition, "email is not verified by oauth provider")
+		}
+		if errors.Is(err, service.ErrIdentityNotLinked) {
+			return nil, status.Error(codes.FailedPrecondition, "account with this email already exists, sign in and link the provider")
+		}
+
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	return &auth.AuthResponse{}, nil
+	return authResponse(challenge), nil
+}
+
+// oauthStatus maps errors of the social login flow
+func oauthStatus(err error) (error, bool) {
+	switch {
+	case errors.Is(err, oauth.ErrUnknownProvider):
+		return status.Error(codes.InvalidArgument, "unknown oauth provider"), true
+	case errors.Is(err, redis.ErrStateNotFound), errors.Is(err, oauth.ErrStateMismatch), errors.Is(err, oauth.ErrStateMisused):
+		return status.Error(codes.PermissionDenied, "invalid or expired oauth state"), true
+	default:
+		return nil, false
+	}
+}
+
+func (a *api) ListSessions(ctx context.Context, req *auth.ListSessionsRequest) (*auth.ListSessionsResponse, error) {
+	sessions, err := a.auth.ListSessions(ctx, req.GetAccessToken())
+	if err != nil {
+		if errors.Is(err, service.ErrUnauthenticated) {
+			return nil, status.Error(codes.Unauthenticated, "invalid access token")
+		}
+
+		return nil, status.Error(codes.Internal, "failed to list sessions")
+	}
+
+	response := &auth.ListSessionsResponse{Sessions: make([]*auth.Session, 0, len(sessions))}
+	for _, session := range sessions {
+		response.Sessions = append(response.Sessions, &auth.Session{
+			Id:          session.ID,
+			Fingerprint: session.Fingerprint,
+			Ip:          session.IP,
+			UserAgent:   session.UserAgent,
+			CreatedAt:   session.CreatedAt.Unix(),
+			LastUsedAt:  session.LastUsedAt.Unix(),
+			Current:     session.Current,
+		})
+	}
+
+	return response, nil
+}
+
+func (a *api) RevokeSession(ctx context.Context, req *auth.RevokeSessionRequest) (*auth.RevokeSessionResponse, error) {
+	if req.GetSessionId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "session id is required")
+	}
+
+	if err := a.auth.RevokeSession(ctx, req.GetAccessToken(), req.GetSessionId()); err != nil {
+		if errors.Is(err, service.ErrUnauthenticated) {
+			return nil, status.Error(codes.Unauthenticated, "invalid access token")
+		}
+		if errors.Is(err, redis.ErrSessionNotFound) {
+			return nil, status.Error(codes.NotFound, "session not found")
+		}
+
+		return nil, status.Error(codes.Internal, "failed to revoke session")
+	}
+
+	return &auth.RevokeSessionResponse{}, nil
+}
+
+func (a *api) RevokeAllOtherSessions(ctx context.Context, req *auth.RevokeAllOtherSessionsRequest) (*auth.RevokeAllOtherSessionsResponse, error) {
+	revoked, err := a.auth.RevokeAllOtherSessions(ctx, req.GetAccessToken())
+	if err != nil {
+		if errors.Is(err, service.ErrUnauthenticated) {
+			return nil, status.Error(codes.Unauthenticated, "invalid access token")
+		}
+
+		return nil, status.Error(codes.Internal, "failed to revoke sessions")
+	}
+
+	return &auth.RevokeAllOtherSessionsResponse{
+		Revoked: int32(revoked),
+	}, nil
 }
 
 func (a *api) GetAccessToken(ctx context.Context, req *auth.GetATRequest) (*auth.GetATResponse, error) {
-	accessToken, err := a.auth.GetAccessToken(ctx, req.GetRefreshToken(), req.GetFingerprint())
+	tokens, err := a.auth.GetAccessToken(ctx, req.GetRefreshToken(), req.GetFingerprint())
 	if err != nil {
 		if errors.Is(err, redis.ErrTokenNotFound) {
 			return nil, status.Error(codes.NotFound, "the refresh token does not exist")
 		}
+		if errors.Is(err, redis.ErrTokenReused) {
+			return nil, status.Error(codes.Unauthenticated, "the refresh token was already used, session revoked")
+		}
 
 		return nil, status.Error(codes.Internal, "failed to generate access token")
 	}
 
+	// HTTP clients get tokens only in HttpOnly cookies, scripts can't read them
+	if viaGateway(ctx) {
+		return &auth.GetATResponse{}, nil
+	}
+
 	return &auth.GetATResponse{
-		AccessToken: accessToken,
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
 	}, nil
 }
 
 func (a *api) ValidateAccessToken(ctx context.Context, req *auth.ValidateATRequest) (*auth.ValidateATResponse, error) {
-	userID, err := a.auth.ValidateAccessToken(ctx, req.GetAccessToken())
+	claims, err := a.auth.ValidateAccessToken(ctx, req.GetAccessToken())
 	if err != nil {
 		return nil, status.Error(codes.Unauthenticated, err.Error())
 	}
 
 	return &auth.ValidateATResponse{
-		UserId: userID,
+		UserId:        claims.UserID,
+		EmailVerified: claims.EmailVerified,
+		Roles:         claims.Roles,
+		SessionId:     claims.SessionID,
 	}, nil
 }
+
+func (a *api) GetJWKS(ctx context.Context, _ *auth.GetJWKSRequest) (*auth.GetJWKSResponse, error) {
+	keys := a.auth.JWKS(ctx)
+
+	response := &auth.GetJWKSResponse{Keys: make([]*auth.JWK, 0, len(keys))}
+	for _, key := range keys {
+		response.Keys = append(response.Keys, &auth.JWK{
+			Kty: key.Kty,
+			Kid: key.Kid,
+			Use: key.Use,
+			Alg: key.Alg,
+			N:   key.N,
+			E:   key.E,
+			Crv: key.Crv,
+			X:   key.X,
+			Y:   key.Y,
+		})
+	}
+
+	return response, nil
+}
+
+// RotateSigningKey loads key from the configured file, callers can't
+// point the server to arbitrary files
+func (a *api) RotateSigningKey(ctx context.Context, _ *auth.RotateSigningKeyRequest) (*auth.RotateSigningKeyResponse, error) {
+	if err := a.authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	if a.rotationKeyFile == "" {
+		return nil, status.Error(codes.FailedPrecondition, "key rotation is not configured")
+	}
+
+	rotation, err := a.auth.RotateSigningKey(ctx, a.rotationKeyFile)
+	if err != nil {
+		return nil, status.Error(codes.FailedPrecondition, "failed to load signing key")
+	}
+
+	return &auth.RotateSigningKeyResponse{
+		Kid:          rotation.KeyID,
+		Alg:          rotation.Algorithm,
+		PreviousKid:  rotation.PreviousKeyID,
+		RetiredUntil: rotation.RetiredUntil.Unix(),
+	}, nil
+}
+
+func (a *api) RevokeUserTokens(ctx context.Context, req *auth.RevokeUserTokensRequest) (*auth.RevokeUserTokensResponse, error) {
+	if err := a.authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	if req.GetUserId() == 0 {
+		return nil, status.Error(codes.InvalidArgument, "user id is required")
+	}
+
+	if err := a.auth.RevokeUserTokens(ctx, req.GetUserId()); err != nil {
+		return nil, status.Error(codes.Internal, "failed to revoke tokens")
+	}
+
+	return &auth.RevokeUserTokensResponse{}, nil
+}
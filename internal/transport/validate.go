@@ -38,12 +38,7 @@ func validateSignUpRequest(req *authv1.SignUpRequest) error {
 					return ErrRequired
 
 				case "Password":
-					if ve.Tag() == "min" {
-						return ErrShortPassword
-					} else if ve.Tag() == "max" {
-						return ErrLongPassword
-					}
-					return ErrRequired
+					return passwordError(ve.Tag())
 
 				default:
 					return errors.New(ve.Error())
@@ -57,6 +52,66 @@ func validateSignUpRequest(req *authv1.SignUpRequest) error {
 	return nil
 }
 
+type EmailRequest struct {
+	Email string `validate:"required,email,max=254"`
+}
+
+func validateEmail(email string) error {
+	validate := validator.New()
+
+	if err := validate.Struct(EmailRequest{Email: email}); err != nil {
+		if email == "" {
+			return ErrRequired
+		}
+
+		return ErrInvalidEmail
+	}
+
+	return nil
+}
+
+// validatePasswordlessLogin requires either magic link token or email with code
+func validatePasswordlessLogin(req *authv1.CompletePasswordlessLoginRequest) error {
+	if req.GetToken() != "" {
+		return nil
+	}
+	if req.GetCode() == 0 {
+		return ErrRequired
+	}
+
+	return validateEmail(req.GetEmail())
+}
+
+type PasswordRequest struct {
+	Password string `validate:"required,min=8,max=64"`
+}
+
+// validatePassword checks new password with the same policy as sign up
+func validatePassword(password string) error {
+	validate := validator.New()
+
+	if err := validate.Struct(PasswordRequest{Password: password}); err != nil {
+		if validationErrors, ok := err.(validator.ValidationErrors); ok && len(validationErrors) > 0 {
+			return passwordError(validationErrors[0].Tag())
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func passwordError(tag string) error {
+	switch tag {
+	case "min":
+		return ErrShortPassword
+	case "max":
+		return ErrLongPassword
+	default:
+		return ErrRequired
+	}
+}
+
 type LoginRequest struct {
 	Email    string `validate:"required"`
 	Password string `validate:"required"`
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS passkeys (
+    id BYTEA PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name VARCHAR(64) DEFAULT '' NOT NULL,
+    public_key BYTEA NOT NULL,
+    sign_count BIGINT DEFAULT 0 NOT NULL,
+    aaguid BYTEA NOT NULL,
+    transports TEXT[] DEFAULT '{}' NOT NULL,
+    created_at TIMESTAMP DEFAULT NOW() NOT NULL,
+    last_used_at TIMESTAMP DEFAULT NOW() NOT NULL
+);
+CREATE INDEX IF NOT EXISTS index_passkeys_user_id ON passkeys (user_id);
@@ -0,0 +1 @@
+-- cleared bodies can't be restored
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS signing_keys (
+    kid VARCHAR(128) PRIMARY KEY,
+    private_key BYTEA NOT NULL,
+    activated_at TIMESTAMP DEFAULT NOW() NOT NULL,
+    retired_until TIMESTAMP
+);
+CREATE UNIQUE INDEX IF NOT EXISTS index_signing_keys_active ON signing_keys ((retired_until IS NULL)) WHERE retired_until IS NULL;
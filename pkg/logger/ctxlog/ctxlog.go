@@ -0,0 +1,22 @@
+package ctxlog
+
+import (
+	"context"
+	"log/slog"
+)
+
+type ctxKey struct{}
+
+// With attaches logger of the request, e.g. with its request id
+func With(ctx context.Context, log *slog.Logger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, log)
+}
+
+// From returns logger attached to the context, fallback if none
+func From(ctx context.Context, fallback *slog.Logger) *slog.Logger {
+	if log, ok := ctx.Value(ctxKey{}).(*slog.Logger); ok {
+		return log
+	}
+
+	return fallback
+}